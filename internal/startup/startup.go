@@ -1,6 +1,8 @@
 package startup
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 
@@ -8,9 +10,13 @@ import (
 
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/datreeio/admission-webhook-datree/pkg/certificateManager"
 	"github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
 	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
 	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 	v1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
@@ -18,11 +24,16 @@ import (
 	"github.com/datreeio/admission-webhook-datree/pkg/k8sClient"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/config"
+	"github.com/datreeio/admission-webhook-datree/pkg/configWatcher"
 	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/admission-webhook-datree/pkg/metrics"
+	"github.com/datreeio/admission-webhook-datree/pkg/namespaceLabels"
+	"github.com/datreeio/admission-webhook-datree/pkg/policyException"
 	"github.com/datreeio/admission-webhook-datree/pkg/services"
 	"github.com/robfig/cron/v3"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/controllers"
+	webhookDeploymentConfig "github.com/datreeio/admission-webhook-datree/pkg/deploymentConfig"
 	"github.com/datreeio/admission-webhook-datree/pkg/errorReporter"
 	"github.com/datreeio/admission-webhook-datree/pkg/k8sMetadataUtil"
 	"github.com/datreeio/admission-webhook-datree/pkg/server"
@@ -33,6 +44,12 @@ import (
 
 const DefaultErrExitCode = 1
 
+// shutdownTimeout is kept below the default terminationGracePeriodSeconds of 30 seconds, after which the pod is killed
+const shutdownTimeout = 25 * time.Second
+
+// certificateManagerStartTimeout how long a replica waits for the leader to store the self-signed certificate
+const certificateManagerStartTimeout = time.Minute
+
 // Start this function was previously the main function in main.go
 func Start() {
 	port := os.Getenv("LISTEN_PORT")
@@ -54,6 +71,14 @@ func Start() {
 		}
 	}()
 
+	if err := servicestate.CheckMultiplePolicies(); err != nil {
+		logger.PanicLevel(fmt.Sprintf("Failed to load the policies scoping: %s \n", err.Error()))
+	}
+
+	if err := servicestate.CheckFailurePolicy(); err != nil {
+		logger.PanicLevel(fmt.Sprintf("Failed to load the failure policy: %s \n", err.Error()))
+	}
+
 	openshiftServiceInstance, err := openshiftService.NewOpenshiftService()
 	if err != nil {
 		panic(err) // should never happen
@@ -84,42 +109,201 @@ func Start() {
 	state.SetClusterUuid(clusterUuid)
 	state.SetK8sVersion(k8sVersion)
 
-	err = server.InitSkipList()
+	// the config files are mounted from ConfigMaps, reload them when they are edited instead of requiring a rollout
+	configWatcherInstance := configWatcher.New(servicestate.DATREE_CONFIG_FILE_DIR, configWatcher.DefaultPollInterval, &logger,
+		configWatcher.Reloader{Name: "config files", Reload: state.ReloadConfigFiles},
+	)
+	configWatcherInstance.Start()
+	configWatchers := []*configWatcher.ConfigWatcher{configWatcherInstance}
+
+	var certificateReloader *server.CertificateReloader
+	var certificateManagerInstance *certificateManager.CertificateManager
+	if state.GetSelfSignedCertificate() && k8sMetadataUtilInstance.ClientSet != nil {
+		certificateManagerInstance = certificateManager.New(k8sMetadataUtilInstance.ClientSet, os.Getenv(enums.Namespace), leaderElectionInstance, &logger)
+		startContext, cancelStart := context.WithTimeout(context.Background(), certificateManagerStartTimeout)
+		certificateReloader, err = certificateManagerInstance.Start(startContext, certificateManager.DefaultSyncInterval)
+		cancelStart()
+	} else {
+		var certPath, keyPath string
+		certPath, keyPath, err = server.ValidateCertificate()
+		if err != nil {
+			logger.PanicLevel(fmt.Sprintf("Failed to validate certificate: %s \n", err.Error()))
+		}
+		certificateReloader, err = server.NewCertificateReloader(certPath, keyPath)
+		if err == nil {
+			// cert-manager and other rotations replace the mounted secret, the new certificate is served without a restart
+			certificateWatcher := configWatcher.New(server.TLS_DIR, configWatcher.DefaultPollInterval, &logger,
+				configWatcher.Reloader{Name: "tls certificate", Reload: certificateReloader.Reload},
+			)
+			certificateWatcher.Start()
+			configWatchers = append(configWatchers, certificateWatcher)
+		}
+	}
 	if err != nil {
-		logger.LogError(fmt.Sprintf("Failed init skip list: %s \n", err.Error()))
+		if webhookDeploymentConfig.ShouldValidateCertificate {
+			logger.PanicLevel(fmt.Sprintf("Failed to load certificate: %s \n", err.Error()))
+		}
+		logger.LogWarn(fmt.Sprintf("Failed to load certificate, serving plain http: %s \n", err.Error()))
+	} else {
+		metrics.RegisterCertificateExpiry(certificateReloader.NotAfter)
 	}
 
-	certPath, keyPath, err := server.ValidateCertificate()
-	if err != nil {
-		logger.PanicLevel(fmt.Sprintf("Failed to validate certificate: %s \n", err.Error()))
+	var cronJobs []*cron.Cron
+	prerunDataProvider := services.NewPrerunDataProvider(basicCliClient, state)
+	if !state.GetIsOfflineMode() && state.GetPrerunCacheTTL() > 0 {
+		prerunDataCache := services.NewPrerunDataCache(basicCliClient, state, k8sMetadataUtilInstance.ClientSet, leaderElectionInstance, &logger)
+		cronJobs = append(cronJobs, initPrerunDataRefreshCronjob(prerunDataCache, state.GetPrerunCacheTTL(), &logger))
+		prerunDataProvider = prerunDataCache
 	}
 
-	validationController := controllers.NewValidationController(basicCliClient, state, errorReporter, k8sMetadataUtilInstance, &logger, openshiftServiceInstance)
-	healthController := controllers.NewHealthController()
+	validationController := controllers.NewValidationController(basicCliClient, state, errorReporter, k8sMetadataUtilInstance, &logger, openshiftServiceInstance, prerunDataProvider, leaderElectionInstance)
+	if state.GetPolicyExceptions() {
+		dynamicClient, err := k8sClient.NewDynamicClient()
+		if err != nil {
+			logger.LogError(fmt.Sprintf("Failed to create the kubernetes client of the policy exceptions, err: %s \n", err.Error()))
+		} else {
+			policyExceptionStore := policyException.NewStore(dynamicClient, &logger)
+			policyExceptionStore.Start()
+			validationController.ValidationService.PolicyExceptions = policyExceptionStore
+		}
+	}
+	mutationController := controllers.NewMutationController(basicCliClient, state, errorReporter, &logger, prerunDataProvider, validationController.ValidationService)
+	// the policies of multiplePolicies can be scoped by a namespace label selector, the labels are resolved from this cache
+	if k8sMetadataUtilInstance.ClientSet != nil {
+		namespaceLabelsCache := namespaceLabels.New(k8sMetadataUtilInstance.ClientSet, &logger)
+		namespaceLabelsCache.Start()
+		validationController.ValidationService.NamespaceLabels = namespaceLabelsCache
+		mutationController.MutationService.NamespaceLabels = namespaceLabelsCache
+	}
+	healthController := controllers.NewHealthController(certificateReloader, state)
 	// set routes
 	http.HandleFunc("/validate", validationController.Validate)
+	http.HandleFunc("/mutate", mutationController.Mutate)
 	http.HandleFunc("/health", healthController.Health)
 	http.HandleFunc("/ready", healthController.Ready)
+	http.HandleFunc("/config-check", healthController.ConfigCheck)
+	http.Handle("/metrics", metrics.Handler())
+
+	metrics.RegisterIsLeader(leaderElectionInstance.IsLeader)
+	metrics.RegisterMetadataAggregatorSize(services.MetadataAggregatorSize)
+	if evaluationResultQueue := validationController.ValidationService.ResultSinks.GetEvaluationResultQueue(); evaluationResultQueue != nil {
+		metrics.RegisterEvaluationResultQueueSize(evaluationResultQueue.Len)
+	}
 
 	// use validation service to send metadata in batch
-	initMetadataLogsCronjob(validationController.ValidationService)
+	cronJobs = append(cronJobs, initMetadataLogsCronjob(validationController.ValidationService))
+
+	var auditScanner *services.AuditScanner
+	if auditConfig := state.GetAudit(); auditConfig.Enabled {
+		dynamicClient, err := k8sClient.NewDynamicClient()
+		if err != nil {
+			logger.LogError(fmt.Sprintf("Failed to create the kubernetes client of the audit scan, err: %s \n", err.Error()))
+		} else {
+			auditScanner = services.NewAuditScanner(validationController.ValidationService, dynamicClient, leaderElectionInstance, auditConfig, &logger)
+			cronJobs = append(cronJobs, initAuditCronjob(auditScanner, auditConfig.Interval, &logger))
+		}
+	}
 
 	logger.LogInfo(fmt.Sprintf("server starting in webhook-version: %s", config.WebhookVersion))
 
 	// start server
-	if err := http.ListenAndServeTLS(":"+port, certPath, keyPath, nil); err != nil {
-		err = http.ListenAndServe(":"+port, nil)
-		if err != nil {
+	httpServer := &http.Server{Addr: ":" + port}
+	serverErrors := make(chan error, 1)
+	go func() {
+		if certificateReloader == nil {
+			serverErrors <- httpServer.ListenAndServe()
+			return
+		}
+		httpServer.TLSConfig = &tls.Config{GetCertificate: certificateReloader.GetCertificate}
+		serverErrors <- httpServer.ListenAndServeTLS("", "")
+	}()
+
+	terminationContext, stopListeningForTermination := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopListeningForTermination()
+
+	select {
+	case err := <-serverErrors:
+		if !errors.Is(err, http.ErrServerClosed) {
 			logger.LogError(fmt.Sprintf("Failed to start http server: %s \n", err.Error()))
 		}
+	case <-terminationContext.Done():
+		logger.LogInfo("Received termination, shutting down")
+	}
+
+	shutdown(httpServer, cronJobs, configWatchers, certificateManagerInstance, auditScanner, k8sMetadataUtilInstance, validationController.ValidationService, leaderElectionInstance, &logger)
+}
+
+// shutdown stops accepting connections and waits for the in-flight requests, then stops the background jobs and flushes
+// what they would have sent later, and finally releases the leader lease so another replica takes over right away
+func shutdown(httpServer *http.Server, cronJobs []*cron.Cron, configWatchers []*configWatcher.ConfigWatcher, certificateManagerInstance *certificateManager.CertificateManager, auditScanner *services.AuditScanner, k8sMetadataUtilInstance *k8sMetadataUtil.K8sMetadataUtil,
+	validationService *services.ValidationService, leaderElectionInstance *leaderElection.LeaderElection, logger *logger.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.LogError(fmt.Sprintf("Failed to wait for the in-flight requests, err: %s \n", err.Error()))
+	}
+
+	for _, configWatcherInstance := range configWatchers {
+		configWatcherInstance.Stop()
+	}
+	certificateManagerInstance.Stop()
+	// a running scan holds its cron job, stopping it first lets the cron job return
+	auditScanner.Stop()
+	cronJobContexts := []context.Context{k8sMetadataUtilInstance.StopCronJob()}
+	for _, cronJob := range cronJobs {
+		cronJobContexts = append(cronJobContexts, cronJob.Stop())
+	}
+	for _, cronJobContext := range cronJobContexts {
+		select {
+		case <-cronJobContext.Done():
+		case <-ctx.Done():
+		}
+	}
+
+	validationService.SendMetadataInBatch()
+	validationService.ResultSinks.Shutdown(ctx)
+	validationService.EventRecorder.Shutdown()
+	validationService.PolicyExceptions.Stop()
+	validationService.NamespaceLabels.Stop()
+
+	if err := leaderElectionInstance.Stop(ctx); err != nil {
+		logger.LogError(fmt.Sprintf("Failed to release the leader lease, err: %s \n", err.Error()))
 	}
+	logger.LogInfo("shutdown completed")
 }
 
-func initMetadataLogsCronjob(validationService *services.ValidationService) {
+func initMetadataLogsCronjob(validationService *services.ValidationService) *cron.Cron {
 	cornJob := cron.New(cron.WithLocation(time.UTC))
 	_, err := cornJob.AddFunc("@every 1h", validationService.SendMetadataInBatch)
 	if err != nil {
 		validationService.Logger.LogError(fmt.Sprintf("Metadata cronjon failed to be added, err: %s \n", err.Error()))
 	}
 	cornJob.Start()
+	return cornJob
+}
+
+func initPrerunDataRefreshCronjob(prerunDataCache *services.PrerunDataCache, ttl time.Duration, logger *logger.Logger) *cron.Cron {
+	cornJob := cron.New(cron.WithLocation(time.UTC))
+	_, err := cornJob.AddFunc(fmt.Sprintf("@every %s", ttl), func() {
+		if err := prerunDataCache.Refresh(); err != nil {
+			logger.LogWarn(fmt.Sprintf("Failed to refresh the prerun data cache, err: %s \n", err.Error()))
+		}
+	})
+	if err != nil {
+		logger.LogError(fmt.Sprintf("Prerun data refresh cronjob failed to be added, err: %s \n", err.Error()))
+	}
+	cornJob.Start()
+	return cornJob
+}
+
+// initAuditCronjob a scan that is still running when the next one is due is not started again
+func initAuditCronjob(auditScanner *services.AuditScanner, interval time.Duration, logger *logger.Logger) *cron.Cron {
+	cornJob := cron.New(cron.WithLocation(time.UTC))
+	_, err := cornJob.AddFunc(fmt.Sprintf("@every %s", interval), auditScanner.Scan)
+	if err != nil {
+		logger.LogError(fmt.Sprintf("Audit cronjob failed to be added, err: %s \n", err.Error()))
+	}
+	cornJob.Start()
+	return cornJob
 }
@@ -0,0 +1,228 @@
+package certificateManager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/admission-webhook-datree/pkg/server"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	SecretName               = "webhook-server-tls"
+	webhookConfigurationName = "datree-webhook"
+	serviceName              = "datree-webhook-server"
+
+	DefaultSyncInterval = time.Minute
+)
+
+// CertificateManager generates a self-signed CA and a serving certificate signed by it, instead of the certificate
+// and caBundle rendered by helm. the leader stores them in a Secret shared by all the replicas, patches the caBundle
+// of the webhook configurations, and rotates the certificates before they expire
+type CertificateManager struct {
+	clientSet      kubernetes.Interface
+	namespace      string
+	leaderElection *leaderElection.LeaderElection
+	logger         *logger.Logger
+	// now is replaced in tests
+	now func() time.Time
+
+	certificateReloader *server.CertificateReloader
+	stopChannel         chan struct{}
+	stopOnce            sync.Once
+}
+
+func New(clientSet kubernetes.Interface, namespace string, leaderElection *leaderElection.LeaderElection, logger *logger.Logger) *CertificateManager {
+	return &CertificateManager{
+		clientSet:      clientSet,
+		namespace:      namespace,
+		leaderElection: leaderElection,
+		logger:         logger,
+		now:            time.Now,
+		stopChannel:    make(chan struct{}),
+	}
+}
+
+// Start returns once there is a certificate to serve, followers wait until the leader stores it.
+// the returned reloader keeps serving the certificate from the Secret after every rotation
+func (m *CertificateManager) Start(ctx context.Context, syncInterval time.Duration) (*server.CertificateReloader, error) {
+	for {
+		err := m.sync()
+		if err == nil {
+			break
+		}
+		m.logger.LogWarn(fmt.Sprintf("certificate manager: %s", err))
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no certificate to serve, last err: %w", err)
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.sync(); err != nil {
+					m.logger.LogWarn(fmt.Sprintf("certificate manager: %s", err))
+				}
+			case <-m.stopChannel:
+				return
+			}
+		}
+	}()
+
+	return m.certificateReloader, nil
+}
+
+// Stop a nil CertificateManager does nothing
+func (m *CertificateManager) Stop() {
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() {
+		close(m.stopChannel)
+	})
+}
+
+// sync the leader renews the certificates when needed and makes sure the webhook configurations trust the CA,
+// since helm re-creates them on every upgrade. every replica then serves the certificate stored in the Secret
+func (m *CertificateManager) sync() error {
+	secret, err := m.clientSet.CoreV1().Secrets(m.namespace).Get(context.Background(), SecretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		secret = nil
+	} else if err != nil {
+		return err
+	}
+
+	current, err := parseCertificates(secret)
+	if err != nil {
+		m.logger.LogWarn(fmt.Sprintf("certificate manager: the stored certificates are invalid and will be replaced, err: %s", err))
+	}
+
+	if m.isLeader() {
+		renewed, err := renewCertificates(current, fmt.Sprintf("%s.%s.svc", serviceName, m.namespace), m.now())
+		if err != nil {
+			return err
+		}
+		if renewed != nil {
+			// the new CA is trusted before any replica serves a certificate signed by it
+			if err := m.patchCaBundle(renewed.caBundle(m.now())); err != nil {
+				return err
+			}
+			if err := m.saveCertificates(secret, renewed); err != nil {
+				return err
+			}
+			m.logger.LogInfo(fmt.Sprintf("certificate manager: stored a certificate that expires at %s", renewed.cert.NotAfter.Format(time.RFC3339)))
+			current = renewed
+		} else if current != nil {
+			if err := m.patchCaBundle(current.caBundle(m.now())); err != nil {
+				return err
+			}
+		}
+	}
+
+	if current == nil {
+		return errors.New("the leader didn't store a certificate yet")
+	}
+	return m.serve(current)
+}
+
+func (m *CertificateManager) serve(current *certificates) error {
+	if m.certificateReloader == nil {
+		certificateReloader, err := server.NewCertificateReloaderFromPEM(current.certPEM, current.keyPEM)
+		if err != nil {
+			return err
+		}
+		m.certificateReloader = certificateReloader
+		return nil
+	}
+
+	if m.certificateReloader.NotAfter().Equal(current.cert.NotAfter) {
+		return nil
+	}
+	return m.certificateReloader.SetKeyPair(current.certPEM, current.keyPEM)
+}
+
+func (m *CertificateManager) isLeader() bool {
+	return m.leaderElection == nil || m.leaderElection.IsLeader()
+}
+
+func (m *CertificateManager) saveCertificates(secret *v1.Secret, renewed *certificates) error {
+	secrets := m.clientSet.CoreV1().Secrets(m.namespace)
+	if secret == nil {
+		_, err := secrets.Create(context.Background(), &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      SecretName,
+				Namespace: m.namespace,
+				Labels:    map[string]string{"app": serviceName, "owner": "datree"},
+			},
+			Type: v1.SecretTypeTLS,
+			Data: renewed.secretData(),
+		}, metav1.CreateOptions{})
+		return err
+	}
+
+	secret.Type = v1.SecretTypeTLS
+	secret.Data = renewed.secretData()
+	_, err := secrets.Update(context.Background(), secret, metav1.UpdateOptions{})
+	return err
+}
+
+// patchCaBundle the webhook configurations are created by a helm hook after the server is deployed,
+// a missing configuration is patched on a later sync
+func (m *CertificateManager) patchCaBundle(caBundle []byte) error {
+	admissionRegistration := m.clientSet.AdmissionregistrationV1()
+
+	validatingWebhookConfiguration, err := admissionRegistration.ValidatingWebhookConfigurations().Get(context.Background(), webhookConfigurationName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		isChanged := false
+		for i := range validatingWebhookConfiguration.Webhooks {
+			if !bytes.Equal(validatingWebhookConfiguration.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				validatingWebhookConfiguration.Webhooks[i].ClientConfig.CABundle = caBundle
+				isChanged = true
+			}
+		}
+		if isChanged {
+			if _, err := admissionRegistration.ValidatingWebhookConfigurations().Update(context.Background(), validatingWebhookConfiguration, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	mutatingWebhookConfiguration, err := admissionRegistration.MutatingWebhookConfigurations().Get(context.Background(), webhookConfigurationName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		isChanged := false
+		for i := range mutatingWebhookConfiguration.Webhooks {
+			if !bytes.Equal(mutatingWebhookConfiguration.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				mutatingWebhookConfiguration.Webhooks[i].ClientConfig.CABundle = caBundle
+				isChanged = true
+			}
+		}
+		if isChanged {
+			if _, err := admissionRegistration.MutatingWebhookConfigurations().Update(context.Background(), mutatingWebhookConfiguration, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
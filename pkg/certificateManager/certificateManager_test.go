@@ -0,0 +1,115 @@
+package certificateManager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var mockLogger = logger.New(zapcore.InfoLevel, nil)
+
+func mockValidatingWebhookConfiguration() *admissionregistrationv1.ValidatingWebhookConfiguration {
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: webhookConfigurationName},
+		Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "webhook-server.datree.svc"}},
+	}
+}
+
+func getCaBundle(t *testing.T, clientSet *fake.Clientset) []byte {
+	validatingWebhookConfiguration, err := clientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), webhookConfigurationName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	return validatingWebhookConfiguration.Webhooks[0].ClientConfig.CABundle
+}
+
+func TestStart(t *testing.T) {
+	t.Run("the leader should store the certificates and patch the caBundle", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(mockValidatingWebhookConfiguration())
+		certificateManager := New(clientSet, "datree", nil, &mockLogger)
+		defer certificateManager.Stop()
+
+		certificateReloader, err := certificateManager.Start(context.Background(), time.Hour)
+		assert.NoError(t, err)
+
+		secret, err := clientSet.CoreV1().Secrets("datree").Get(context.Background(), SecretName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		current, err := parseCertificates(secret)
+		assert.NoError(t, err)
+		assert.Equal(t, current.caCertPEM, getCaBundle(t, clientSet))
+		assert.Equal(t, []string{"datree-webhook-server.datree.svc", "datree-webhook-server.datree.svc.cluster.local"}, current.cert.DNSNames)
+		assert.True(t, certificateReloader.NotAfter().Equal(current.cert.NotAfter))
+	})
+
+	t.Run("a follower should serve the certificate stored by the leader", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(mockValidatingWebhookConfiguration())
+		leader := New(clientSet, "datree", nil, &mockLogger)
+		assert.NoError(t, leader.sync())
+
+		follower := New(clientSet, "datree", &leaderElection.LeaderElection{}, &mockLogger)
+		defer follower.Stop()
+		certificateReloader, err := follower.Start(context.Background(), time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, certificateReloader.NotAfter().Equal(leader.certificateReloader.NotAfter()))
+	})
+
+	t.Run("a follower should fail when the leader didn't store a certificate", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		follower := New(clientSet, "datree", &leaderElection.LeaderElection{}, &mockLogger)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := follower.Start(ctx, time.Hour)
+		assert.Error(t, err)
+	})
+}
+
+func TestSync(t *testing.T) {
+	t.Run("should keep the certificates that are not about to expire", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(mockValidatingWebhookConfiguration())
+		certificateManager := New(clientSet, "datree", nil, &mockLogger)
+		assert.NoError(t, certificateManager.sync())
+		notAfter := certificateManager.certificateReloader.NotAfter()
+
+		assert.NoError(t, certificateManager.sync())
+		assert.True(t, certificateManager.certificateReloader.NotAfter().Equal(notAfter))
+	})
+
+	t.Run("should renew the serving certificate before it expires", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(mockValidatingWebhookConfiguration())
+		certificateManager := New(clientSet, "datree", nil, &mockLogger)
+		assert.NoError(t, certificateManager.sync())
+		notAfter := certificateManager.certificateReloader.NotAfter()
+		caBundle := getCaBundle(t, clientSet)
+
+		certificateManager.now = func() time.Time { return notAfter.Add(-renewBefore + time.Hour) }
+		assert.NoError(t, certificateManager.sync())
+		assert.True(t, certificateManager.certificateReloader.NotAfter().After(notAfter))
+		assert.Equal(t, caBundle, getCaBundle(t, clientSet))
+	})
+
+	t.Run("should keep trusting the previous CA after rotating it", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(mockValidatingWebhookConfiguration())
+		certificateManager := New(clientSet, "datree", nil, &mockLogger)
+		assert.NoError(t, certificateManager.sync())
+		previousCaBundle := getCaBundle(t, clientSet)
+		previousCa, err := parseCertificatePEM(previousCaBundle)
+		assert.NoError(t, err)
+
+		certificateManager.now = func() time.Time { return previousCa.NotAfter.Add(-renewBefore + time.Hour) }
+		assert.NoError(t, certificateManager.sync())
+
+		secret, err := clientSet.CoreV1().Secrets("datree").Get(context.Background(), SecretName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		current, err := parseCertificates(secret)
+		assert.NoError(t, err)
+		assert.NotEqual(t, previousCaBundle, current.caCertPEM)
+		assert.Equal(t, append(append([]byte{}, current.caCertPEM...), previousCaBundle...), getCaBundle(t, clientSet))
+	})
+}
@@ -0,0 +1,215 @@
+package certificateManager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	caValidity          = 5 * 365 * 24 * time.Hour
+	certificateValidity = 365 * 24 * time.Hour
+	// certificates are renewed when less than this is left before they expire
+	renewBefore = 30 * 24 * time.Hour
+
+	caCertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+	// previousCaCertKey the CA that was replaced keeps being trusted until it expires,
+	// so replicas that still serve a certificate signed by it keep working until they sync
+	previousCaCertKey = "ca-previous.crt"
+)
+
+type certificates struct {
+	ca        *x509.Certificate
+	caCertPEM []byte
+	caKeyPEM  []byte
+	caKey     crypto.Signer
+
+	previousCaCertPEM []byte
+
+	cert    *x509.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// parseCertificates returns nil when the Secret doesn't exist or wasn't created by the certificate manager
+func parseCertificates(secret *v1.Secret) (*certificates, error) {
+	if secret == nil || secret.Data[caCertKey] == nil || secret.Data[caKeyKey] == nil {
+		return nil, nil
+	}
+
+	ca, err := parseCertificatePEM(secret.Data[caCertKey])
+	if err != nil {
+		return nil, err
+	}
+	caKey, err := parsePrivateKeyPEM(secret.Data[caKeyKey])
+	if err != nil {
+		return nil, err
+	}
+	cert, err := parseCertificatePEM(secret.Data[v1.TLSCertKey])
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return nil, err
+	}
+
+	return &certificates{
+		ca:                ca,
+		caCertPEM:         secret.Data[caCertKey],
+		caKeyPEM:          secret.Data[caKeyKey],
+		caKey:             caKey,
+		previousCaCertPEM: secret.Data[previousCaCertKey],
+		cert:              cert,
+		certPEM:           secret.Data[v1.TLSCertKey],
+		keyPEM:            secret.Data[v1.TLSPrivateKeyKey],
+	}, nil
+}
+
+// renewCertificates returns nil when the current certificates don't need to be renewed yet
+func renewCertificates(current *certificates, dnsName string, now time.Time) (*certificates, error) {
+	isCaValid := current != nil && current.ca.NotAfter.Sub(now) > renewBefore
+	isCertValid := current != nil && current.cert.NotAfter.Sub(now) > renewBefore
+	if isCaValid && isCertValid {
+		return nil, nil
+	}
+
+	renewed := &certificates{}
+	if isCaValid {
+		renewed.ca, renewed.caCertPEM, renewed.caKeyPEM, renewed.caKey = current.ca, current.caCertPEM, current.caKeyPEM, current.caKey
+		renewed.previousCaCertPEM = current.previousCaCertPEM
+	} else {
+		caKey, caKeyPEM, err := generatePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		caTemplate := &x509.Certificate{
+			SerialNumber:          newSerialNumber(),
+			Subject:               pkix.Name{CommonName: "Datree Admission Webhook CA"},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(caValidity),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		ca, caCertPEM, err := createCertificate(caTemplate, caTemplate, caKey.Public(), caKey)
+		if err != nil {
+			return nil, err
+		}
+		renewed.ca, renewed.caCertPEM, renewed.caKeyPEM, renewed.caKey = ca, caCertPEM, caKeyPEM, caKey
+		if current != nil {
+			renewed.previousCaCertPEM = current.caCertPEM
+		}
+	}
+
+	key, keyPEM, err := generatePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	certTemplate := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName, dnsName + ".cluster.local"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if certTemplate.NotAfter.After(renewed.ca.NotAfter) {
+		certTemplate.NotAfter = renewed.ca.NotAfter
+	}
+	cert, certPEM, err := createCertificate(certTemplate, renewed.ca, key.Public(), renewed.caKey)
+	if err != nil {
+		return nil, err
+	}
+	renewed.cert, renewed.certPEM, renewed.keyPEM = cert, certPEM, keyPEM
+
+	return renewed, nil
+}
+
+// caBundle the current CA, and the previous one while it is still valid
+func (c *certificates) caBundle(now time.Time) []byte {
+	caBundle := append([]byte{}, c.caCertPEM...)
+	if previousCa, err := parseCertificatePEM(c.previousCaCertPEM); err == nil && previousCa.NotAfter.After(now) {
+		caBundle = append(caBundle, c.previousCaCertPEM...)
+	}
+	return caBundle
+}
+
+func (c *certificates) secretData() map[string][]byte {
+	secretData := map[string][]byte{
+		caCertKey:           c.caCertPEM,
+		caKeyKey:            c.caKeyPEM,
+		v1.TLSCertKey:       c.certPEM,
+		v1.TLSPrivateKeyKey: c.keyPEM,
+	}
+	if c.previousCaCertPEM != nil {
+		secretData[previousCaCertKey] = c.previousCaCertPEM
+	}
+	return secretData
+}
+
+func generatePrivateKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+func createCertificate(template *x509.Certificate, parent *x509.Certificate, publicKey crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, []byte, error) {
+	certDer, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certDer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), nil
+}
+
+func newSerialNumber() *big.Int {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serialNumber
+}
+
+func parseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the private key can't sign certificates")
+	}
+	return signer, nil
+}
@@ -0,0 +1,165 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/metrics"
+)
+
+const (
+	// circuitFailureThreshold consecutive backend failures after which the circuit is opened
+	circuitFailureThreshold = 5
+	// circuitOpenDuration how long the requests are short-circuited before a single request is let through to probe the backend
+	circuitOpenDuration = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned instead of sending the request while the backend is considered unavailable,
+// the callers fall back to the cached prerun data and the queued results the same way they do on a failed request
+var ErrCircuitOpen = errors.New("the Datree backend is unavailable, backend requests are short-circuited until it recovers")
+
+type circuitState int
+
+// the values are exposed as the backend_circuit_state metric
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// requestOutcome how a backend request affects the circuit
+type requestOutcome int
+
+const (
+	requestSucceeded requestOutcome = iota
+	requestFailed
+	// requestAbandoned the request was canceled by its caller or outlived the caller's deadline, it says nothing about the backend
+	requestAbandoned
+)
+
+// circuitBreaker stops sending requests to the backend after consecutive failures, so during an outage the admission
+// requests don't wait for requests that are bound to fail. it is shared by all the clients of the same backend
+type circuitBreaker struct {
+	mutex               sync.Mutex
+	state               circuitState
+	consecutiveFailures int
+	openedAt            time.Time
+	// isProbing a request is let through while half-open, the others are short-circuited until it completes
+	isProbing bool
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		state: circuitClosed,
+		now:   time.Now,
+	}
+}
+
+// backendCircuitBreaker is used by every client created with NewCliServiceClient, they all call the same backend
+var backendCircuitBreaker = newCircuitBreaker()
+
+// allow returns false when the request should be short-circuited
+func (b *circuitBreaker) allow(logger Logger) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < circuitOpenDuration {
+			return false
+		}
+		b.transition(circuitHalfOpen, logger)
+		b.isProbing = true
+		return true
+	case circuitHalfOpen:
+		if b.isProbing {
+			return false
+		}
+		b.isProbing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *circuitBreaker) record(outcome requestOutcome, logger Logger) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.isProbing = false
+	}
+
+	switch outcome {
+	case requestSucceeded:
+		b.consecutiveFailures = 0
+		if b.state != circuitClosed {
+			b.transition(circuitClosed, logger)
+		}
+	case requestFailed:
+		b.consecutiveFailures++
+		if b.state == circuitHalfOpen || (b.state == circuitClosed && b.consecutiveFailures >= circuitFailureThreshold) {
+			b.openedAt = b.now()
+			b.transition(circuitOpen, logger)
+		}
+	}
+}
+
+func (b *circuitBreaker) getState() circuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// transition must be called while holding the mutex
+func (b *circuitBreaker) transition(state circuitState, logger Logger) {
+	message := fmt.Sprintf("backend circuit breaker changed from %s to %s", b.state, state)
+	if state == circuitOpen {
+		message += fmt.Sprintf(" after %d consecutive failures, backend requests are short-circuited for %s", b.consecutiveFailures, circuitOpenDuration)
+	}
+	b.state = state
+	metrics.SetBackendCircuitState(int(state))
+
+	if logger == nil {
+		// using fmt.Printf instead of logger to avoid circular dependency
+		fmt.Printf("%s\n", message)
+		return
+	}
+	if state == circuitClosed {
+		logger.LogDebug(message)
+	} else {
+		logger.LogWarn(message)
+	}
+}
+
+// getRequestOutcome client errors mean the backend is reachable, only the errors without a response and the server errors count as failures.
+// the context errors come from the caller, which canceled the request or whose deadline passed, the client's own timeout isn't one of them
+func getRequestOutcome(statusCode int, err error) requestOutcome {
+	if err == nil {
+		return requestSucceeded
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return requestAbandoned
+	}
+	if statusCode == 0 || statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests {
+		return requestFailed
+	}
+	return requestSucceeded
+}
@@ -0,0 +1,137 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/datreeio/datree/pkg/httpClient"
+	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockHttpClient struct {
+	mock.Mock
+}
+
+func (m *mockHttpClient) Request(method string, resourceURI string, body interface{}, headers map[string]string) (httpClient.Response, error) {
+	args := m.Called(method)
+	return args.Get(0).(httpClient.Response), args.Error(1)
+}
+
+func mockCliClient(mockedHttpClient *mockHttpClient) *CliClient {
+	return NewCustomCliServiceClient("", mockedHttpClient, nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string))
+}
+
+var (
+	unavailableResponse = httpClient.Response{StatusCode: http.StatusServiceUnavailable}
+	errUnavailable      = errors.New("backend unavailable")
+	messagesResponse    = httpClient.Response{StatusCode: http.StatusOK, Body: []byte(`{"cliVersion": "1.0.0"}`)}
+)
+
+func TestRequestRetries(t *testing.T) {
+	t.Run("should retry a failed GET request", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", http.MethodGet).Return(unavailableResponse, errUnavailable).Once()
+		mockedHttpClient.On("Request", http.MethodGet).Return(messagesResponse, nil).Once()
+
+		res, err := mockCliClient(mockedHttpClient).GetVersionRelatedMessages("1.0.0")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "1.0.0", res.CliVersion)
+		mockedHttpClient.AssertNumberOfCalls(t, "Request", 2)
+	})
+
+	t.Run("should not retry a GET request that failed with a client error", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", http.MethodGet).Return(httpClient.Response{StatusCode: http.StatusNotFound}, errors.New("not found"))
+
+		_, err := mockCliClient(mockedHttpClient).GetVersionRelatedMessages("1.0.0")
+
+		assert.EqualError(t, err, "not found")
+		mockedHttpClient.AssertNumberOfCalls(t, "Request", 1)
+	})
+
+	t.Run("should not retry a POST request", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", http.MethodPost).Return(unavailableResponse, errUnavailable)
+
+		_, err := mockCliClient(mockedHttpClient).SendWebhookEvaluationResult(&EvaluationResultRequest{})
+
+		assert.Error(t, err)
+		mockedHttpClient.AssertNumberOfCalls(t, "Request", 1)
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("should short-circuit the requests after consecutive failures, and close once a probe succeeds", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", http.MethodPost).Return(unavailableResponse, errUnavailable).Times(circuitFailureThreshold)
+		client := mockCliClient(mockedHttpClient)
+		now := time.Now()
+		client.circuitBreaker.now = func() time.Time { return now }
+
+		for i := 0; i < circuitFailureThreshold; i++ {
+			_, err := client.SendWebhookEvaluationResult(&EvaluationResultRequest{})
+			assert.ErrorIs(t, err, errUnavailable)
+		}
+		_, err := client.SendWebhookEvaluationResult(&EvaluationResultRequest{})
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.Equal(t, circuitOpen, client.circuitBreaker.getState())
+		mockedHttpClient.AssertNumberOfCalls(t, "Request", circuitFailureThreshold)
+
+		now = now.Add(circuitOpenDuration)
+		mockedHttpClient.On("Request", http.MethodGet).Return(messagesResponse, nil).Once()
+		_, err = client.GetVersionRelatedMessages("1.0.0")
+		assert.NoError(t, err)
+		assert.Equal(t, circuitClosed, client.circuitBreaker.getState())
+	})
+
+	t.Run("should open again when the probe fails", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", http.MethodPost).Return(unavailableResponse, errUnavailable)
+		client := mockCliClient(mockedHttpClient)
+		now := time.Now()
+		client.circuitBreaker.now = func() time.Time { return now }
+
+		for i := 0; i < circuitFailureThreshold; i++ {
+			_, _ = client.SendWebhookEvaluationResult(&EvaluationResultRequest{})
+		}
+		now = now.Add(circuitOpenDuration)
+		_, err := client.SendWebhookEvaluationResult(&EvaluationResultRequest{})
+		assert.ErrorIs(t, err, errUnavailable)
+
+		_, err = client.SendWebhookEvaluationResult(&EvaluationResultRequest{})
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		mockedHttpClient.AssertNumberOfCalls(t, "Request", circuitFailureThreshold+1)
+	})
+	t.Run("should count the requests that time out as failures", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", http.MethodPost).Return(messagesResponse, nil).After(50 * time.Millisecond)
+		client := mockCliClient(mockedHttpClient)
+		client.requestTimeout = time.Millisecond
+
+		for i := 0; i < circuitFailureThreshold; i++ {
+			_, err := client.SendWebhookEvaluationResult(&EvaluationResultRequest{})
+			assert.Error(t, err)
+		}
+		assert.Equal(t, circuitOpen, client.circuitBreaker.getState())
+	})
+
+	t.Run("should not count the requests that outlived the caller's deadline as failures", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", http.MethodPost).Return(messagesResponse, nil).After(50 * time.Millisecond)
+		client := mockCliClient(mockedHttpClient)
+
+		for i := 0; i < circuitFailureThreshold; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			_, err := client.WithContext(ctx).SendWebhookEvaluationResult(&EvaluationResultRequest{})
+			cancel()
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}
+		assert.Equal(t, circuitClosed, client.circuitBreaker.getState())
+	})
+}
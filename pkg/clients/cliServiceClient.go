@@ -1,13 +1,19 @@
 package clients
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	"github.com/datreeio/admission-webhook-datree/pkg/metrics"
 	"github.com/datreeio/admission-webhook-datree/pkg/server"
 	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 
@@ -24,6 +30,12 @@ type HTTPClient interface {
 	Request(method string, resourceURI string, body interface{}, headers map[string]string) (httpClient.Response, error)
 }
 
+// Logger is implemented by logger.Logger, which can't be imported here since it depends on this package through the error reporter
+type Logger interface {
+	LogDebug(message string, data ...any)
+	LogWarn(message string, data ...any)
+}
+
 type CliClient struct {
 	baseUrl          string
 	httpClient       HTTPClient
@@ -31,16 +43,33 @@ type CliClient struct {
 	httpErrors       []string
 	networkValidator cliClient.NetworkValidator
 	flagsHeaders     map[string]string
+	logger           Logger
+	circuitBreaker   *circuitBreaker
+	// requestTimeout bounds every backend request, a request that times out counts as a backend failure
+	requestTimeout time.Duration
+	// ctx bounds the backend requests, the http client doesn't accept a context so a request that outlives it is abandoned,
+	// and runs until the http client times out. an abandoned request says nothing about the backend, it doesn't count as a failure
+	ctx context.Context
+}
+
+// backendRequestTimeout bounds both the wait for a backend response and the http request itself, so the request of
+// a caller that stopped waiting doesn't outlive it by more than the timeout
+const backendRequestTimeout = 30 * time.Second
+
+// newBackendHttpClient the http client doesn't accept a context, its own timeout is what ends an abandoned request
+func newBackendHttpClient(url string, timeout time.Duration) *httpClient.Client {
+	return httpClient.NewClientTimeout(url, nil, timeout)
 }
 
 func NewCliServiceClient(url string, networkValidator cliClient.NetworkValidator, state *servicestate.ServiceState) *CliClient {
-	httpClient := httpClient.NewClient(url, nil)
 	return &CliClient{
 		baseUrl:          url,
-		httpClient:       httpClient,
+		httpClient:       newBackendHttpClient(url, backendRequestTimeout),
 		timeoutClient:    nil,
 		httpErrors:       []string{},
 		networkValidator: networkValidator,
+		circuitBreaker:   backendCircuitBreaker,
+		requestTimeout:   backendRequestTimeout,
 		flagsHeaders: map[string]string{
 			"x-cli-flags-policyName":  state.GetPolicyName(),
 			"x-cli-flags-verbose":     state.GetVerbose(),
@@ -59,41 +88,207 @@ func NewCustomCliServiceClient(baseUrl string, httpClient HTTPClient, timeoutCli
 		httpErrors:       httpErrors,
 		networkValidator: networkValidator,
 		flagsHeaders:     flagsHeaders,
+		circuitBreaker:   newCircuitBreaker(),
+		requestTimeout:   backendRequestTimeout,
+	}
+}
+
+// IsLocalMode returns true when the prerun data requests return the empty policy-as-code fallback when the backend is
+// unavailable, instead of an error
+func (c *CliClient) IsLocalMode() bool {
+	return c.networkValidator.IsLocalMode()
+}
+
+// WithLogger returns a copy of the client that logs its backend requests with the given request logger
+func (c *CliClient) WithLogger(logger Logger) *CliClient {
+	requestClient := *c
+	requestClient.logger = logger
+	return &requestClient
+}
+
+// WithContext returns a copy of the client whose backend requests return the context error once ctx is done
+func (c *CliClient) WithContext(ctx context.Context) *CliClient {
+	requestClient := *c
+	requestClient.ctx = ctx
+	return &requestClient
+}
+
+// request sends a request to the backend and records the duration of every attempt under the given call name.
+// it is short-circuited while the backend circuit breaker is open, and retried according to the retry policy of the call
+func (c *CliClient) request(call string, method string, resourceURI string, body interface{}, headers map[string]string) (httpClient.Response, error) {
+	policy := getRetryPolicy(call, method)
+	for attempt := 1; ; attempt++ {
+		if !c.circuitBreaker.allow(c.logger) {
+			metrics.BackendRequestsShortCircuitedTotal.WithLabelValues(call).Inc()
+			return httpClient.Response{}, ErrCircuitOpen
+		}
+
+		res, err := c.attempt(call, method, resourceURI, body, headers)
+		outcome := getRequestOutcome(res.StatusCode, err)
+		c.circuitBreaker.record(outcome, c.logger)
+		if outcome != requestFailed || attempt >= policy.maxAttempts {
+			return res, err
+		}
+
+		metrics.BackendRequestRetriesTotal.WithLabelValues(call).Inc()
+		if waitErr := c.wait(policy.backoff(attempt)); waitErr != nil {
+			return res, err
+		}
+	}
+}
+
+func (c *CliClient) attempt(call string, method string, resourceURI string, body interface{}, headers map[string]string) (httpClient.Response, error) {
+	startTime := time.Now()
+	res, err := c.requestWithinContext(method, resourceURI, body, headers)
+	metrics.ObserveBackendRequest(call, res.StatusCode, startTime)
+
+	if c.logger != nil {
+		requestFields := map[string]interface{}{"call": call, "statusCode": res.StatusCode, "durationMs": time.Since(startTime).Milliseconds()}
+		if err != nil {
+			c.logger.LogWarn(fmt.Sprintf("backend request %s failed, err: %s", call, err), requestFields)
+		} else {
+			c.logger.LogDebug(fmt.Sprintf("backend request %s", call), requestFields)
+		}
+	}
+	return res, err
+}
+
+// wait returns the context error when the context is done before the backoff
+func (c *CliClient) wait(backoff time.Duration) error {
+	if c.ctx == nil {
+		time.Sleep(backoff)
+		return nil
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+type httpResult struct {
+	res httpClient.Response
+	err error
+}
+
+func (c *CliClient) requestWithinContext(method string, resourceURI string, body interface{}, headers map[string]string) (httpClient.Response, error) {
+	var ctxDone <-chan struct{}
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			return httpClient.Response{}, err
+		}
+		ctxDone = c.ctx.Done()
+	}
+
+	// buffered, the abandoned request doesn't block once it completes
+	resultChannel := make(chan httpResult, 1)
+	go func() {
+		res, err := c.httpClient.Request(method, resourceURI, body, headers)
+		resultChannel <- httpResult{res: res, err: err}
+	}()
+
+	timer := time.NewTimer(c.requestTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-resultChannel:
+		// the http client has no context, a deadline error is its own timeout and not the caller's
+		if errors.Is(result.err, context.DeadlineExceeded) {
+			return result.res, c.timeoutError()
+		}
+		return result.res, result.err
+	case <-timer.C:
+		return httpClient.Response{}, c.timeoutError()
+	case <-ctxDone:
+		return httpClient.Response{}, c.ctx.Err()
 	}
 }
 
+func (c *CliClient) timeoutError() error {
+	return fmt.Errorf("the backend didn't respond within %s", c.requestTimeout)
+}
+
 type ClusterEvaluationPrerunDataResponse struct {
 	cliClient.EvaluationPrerunDataResponse `json:",inline"`
-	ActivePolicies                         []string                       `json:"activePolicies"`
-	ActionOnFailure                        enums.ActionOnFailure          `json:"actionOnFailure"`
-	IgnorePatterns                         []string                       `json:"ignorePatterns"`
-	BypassPermissions                      servicestate.BypassPermissions `json:"bypassPermissions"`
+	ActivePolicies                         []string                         `json:"activePolicies"`
+	ActionOnFailure                        enums.ActionOnFailure            `json:"actionOnFailure"`
+	ActivePoliciesActions                  map[string]enums.ActionOnFailure `json:"activePoliciesActions,omitempty"`
+	IgnorePatterns                         []string                         `json:"ignorePatterns"`
+	BypassPermissions                      servicestate.BypassPermissions   `json:"bypassPermissions"`
+}
+
+// ValidateActions returns an error listing the policies whose action isn't a known action
+func (r *ClusterEvaluationPrerunDataResponse) ValidateActions() error {
+	var policyNames []string
+	for policyName, actionOnFailure := range r.ActivePoliciesActions {
+		if actionOnFailure != "" && !actionOnFailure.IsValid() {
+			policyNames = append(policyNames, policyName)
+		}
+	}
+	if len(policyNames) == 0 {
+		return nil
+	}
+
+	sort.Strings(policyNames)
+	var messages []string
+	for _, policyName := range policyNames {
+		messages = append(messages, fmt.Sprintf("unknown action %q for policy %s", r.ActivePoliciesActions[policyName], policyName))
+	}
+	return fmt.Errorf("invalid prerun data: %s", strings.Join(messages, "; "))
 }
 
 func (c *CliClient) RequestClusterEvaluationPrerunData(tokenId string, clusterUuid k8sTypes.UID) (*ClusterEvaluationPrerunDataResponse, error) {
+	evaluationPrerunDataResponse, _, err := c.RequestClusterEvaluationPrerunDataIfModified(tokenId, clusterUuid, "")
+	return evaluationPrerunDataResponse, err
+}
+
+// RequestClusterEvaluationPrerunDataIfModified returns the prerun data along with its version, a hash of the response body.
+// the version is sent as If-None-Match, when the prerun data did not change since that version the returned prerun data is nil
+func (c *CliClient) RequestClusterEvaluationPrerunDataIfModified(tokenId string, clusterUuid k8sTypes.UID, version string) (*ClusterEvaluationPrerunDataResponse, string, error) {
 	if c.networkValidator.IsLocalMode() {
 		return &ClusterEvaluationPrerunDataResponse{
 			EvaluationPrerunDataResponse: cliClient.EvaluationPrerunDataResponse{
 				IsPolicyAsCodeMode: true,
 			},
-		}, nil
+		}, "", nil
 	}
 
-	res, err := c.httpClient.Request(http.MethodGet, "/cli/evaluation/policyCheck/tokens/"+tokenId+"/clusters/"+string(clusterUuid)+"/prerun?", nil, c.flagsHeaders)
+	headers := c.flagsHeaders
+	if version != "" {
+		headers = make(map[string]string, len(c.flagsHeaders)+1)
+		for key, value := range c.flagsHeaders {
+			headers[key] = value
+		}
+		headers["If-None-Match"] = version
+	}
+
+	res, err := c.request("RequestClusterEvaluationPrerunData", http.MethodGet, "/cli/evaluation/policyCheck/tokens/"+tokenId+"/clusters/"+string(clusterUuid)+"/prerun?", nil, headers)
 
 	if err != nil {
 		networkErr := c.networkValidator.IdentifyNetworkError(err)
 		if networkErr != nil {
-			return &ClusterEvaluationPrerunDataResponse{}, networkErr
+			return &ClusterEvaluationPrerunDataResponse{}, "", networkErr
 		}
 
 		if c.networkValidator.IsLocalMode() {
 			return &ClusterEvaluationPrerunDataResponse{EvaluationPrerunDataResponse: cliClient.EvaluationPrerunDataResponse{
 				IsPolicyAsCodeMode: true,
-			}}, nil
+			}}, "", nil
 		}
 
-		return &ClusterEvaluationPrerunDataResponse{}, err
+		return &ClusterEvaluationPrerunDataResponse{}, "", err
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, version, nil
+	}
+
+	responseVersion := fmt.Sprintf("%x", sha256.Sum256(res.Body))
+	if version != "" && responseVersion == version {
+		return nil, version, nil
 	}
 
 	var evaluationPrerunDataResponse = &ClusterEvaluationPrerunDataResponse{EvaluationPrerunDataResponse: cliClient.EvaluationPrerunDataResponse{
@@ -101,10 +296,10 @@ func (c *CliClient) RequestClusterEvaluationPrerunData(tokenId string, clusterUu
 	}}
 	err = json.Unmarshal(res.Body, &evaluationPrerunDataResponse)
 	if err != nil {
-		return &ClusterEvaluationPrerunDataResponse{}, err
+		return &ClusterEvaluationPrerunDataResponse{}, "", err
 	}
 
-	return evaluationPrerunDataResponse, nil
+	return evaluationPrerunDataResponse, responseVersion, nil
 }
 
 // SendEvaluationResult needed to override cliClient for evaluation
@@ -144,7 +339,7 @@ type ClusterRequestMetadataBatchReqBody struct {
 }
 
 func (c *CliClient) SendRequestMetadataBatch(clusterRequestMetadataAggregator ClusterRequestMetadataBatchReqBody) {
-	httpRes, err := c.httpClient.Request(http.MethodPost, "/cli/evaluation/clusterRequestMetadataBatch", clusterRequestMetadataAggregator, c.flagsHeaders)
+	httpRes, err := c.request("SendRequestMetadataBatch", http.MethodPost, "/cli/evaluation/clusterRequestMetadataBatch", clusterRequestMetadataAggregator, c.flagsHeaders)
 	if err != nil {
 		// using fmt.Printf instead of logger to avoid circular dependency
 		fmt.Printf("SendRequestMetadataBatch status code: %d, err: %s \n", httpRes.StatusCode, err.Error())
@@ -176,6 +371,9 @@ type EvaluationResultRequest struct {
 	Namespace          string                                      `json:"namespace,omitempty"`
 	Kind               string                                      `json:"kind"`
 	MetadataName       string                                      `json:"metadataName"`
+	// EvaluationUploadId is generated by the webhook before the result is uploaded, the report link is built with it
+	// instead of the evaluation id, which is only known once the upload is done
+	EvaluationUploadId string `json:"evaluationUploadId"`
 }
 
 type Metadata struct {
@@ -197,7 +395,7 @@ func (c *CliClient) SendWebhookEvaluationResult(request *EvaluationResultRequest
 	if c.networkValidator.IsLocalMode() {
 		return &cliClient.SendEvaluationResultsResponse{}, nil
 	}
-	httpRes, err := c.httpClient.Request(http.MethodPost, "/cli/evaluation/policyCheck/result", request, c.flagsHeaders)
+	httpRes, err := c.request("SendWebhookEvaluationResult", http.MethodPost, "/cli/evaluation/policyCheck/result", request, c.flagsHeaders)
 	if err != nil {
 		networkErr := c.networkValidator.IdentifyNetworkError(err)
 		if networkErr != nil {
@@ -238,7 +436,7 @@ func (c *CliClient) GetVersionRelatedMessages(webhookVersion string) (*VersionRe
 	if webhookVersion == "" {
 		return nil, errors.New("can't get current webhook version")
 	}
-	httpRes, err := c.httpClient.Request(http.MethodGet, "/cli/messages/versions/"+webhookVersion+"/webhook", nil, c.flagsHeaders)
+	httpRes, err := c.request("GetVersionRelatedMessages", http.MethodGet, "/cli/messages/versions/"+webhookVersion+"/webhook", nil, c.flagsHeaders)
 	if err != nil {
 		return nil, err
 	}
@@ -263,7 +461,7 @@ type ReportK8sMetadataRequest struct {
 }
 
 func (c *CliClient) ReportK8sMetadata(request *ReportK8sMetadataRequest) {
-	_, err := c.httpClient.Request(http.MethodPost, "/cli/clusterEvents", request, c.flagsHeaders)
+	_, err := c.request("ReportK8sMetadata", http.MethodPost, "/cli/clusterEvents", request, c.flagsHeaders)
 	if err != nil {
 		fmt.Printf("Failed to report cluster metadata: %s\n", err.Error())
 	}
@@ -284,7 +482,8 @@ type ReportErrorRequest struct {
 
 func (c *CliClient) ReportError(reportCliErrorRequest ReportErrorRequest, uri string) (StatusCode int, Error error) {
 	headers := map[string]string{}
-	res, err := c.httpClient.Request(
+	res, err := c.request(
+		"ReportError",
 		http.MethodPost,
 		"/cli/public"+uri,
 		reportCliErrorRequest,
@@ -0,0 +1,66 @@
+package clients
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestWithinContext(t *testing.T) {
+	t.Run("should not leak the requests the caller stopped waiting for", func(t *testing.T) {
+		releaseHandlers := make(chan struct{})
+		hangingBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the request context is only done on a closed connection once the body is read
+			_, _ = io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+			case <-releaseHandlers:
+			}
+		}))
+		defer hangingBackend.Close()
+		defer close(releaseHandlers)
+
+		requestTimeout := 100 * time.Millisecond
+		client := NewCustomCliServiceClient(hangingBackend.URL, newBackendHttpClient(hangingBackend.URL, requestTimeout), nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string))
+		client.requestTimeout = requestTimeout
+		goroutinesCount := runtime.NumGoroutine()
+
+		for i := 0; i < 10; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			_, err := client.WithContext(ctx).SendWebhookEvaluationResult(&EvaluationResultRequest{})
+			cancel()
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}
+
+		assert.Eventually(t, func() bool { return runtime.NumGoroutine() <= goroutinesCount }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should count the requests the http client timed out as failures", func(t *testing.T) {
+		releaseHandlers := make(chan struct{})
+		hangingBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the request context is only done on a closed connection once the body is read
+			_, _ = io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+			case <-releaseHandlers:
+			}
+		}))
+		defer hangingBackend.Close()
+		defer close(releaseHandlers)
+
+		// the http client times out before the client stops waiting for it
+		client := NewCustomCliServiceClient(hangingBackend.URL, newBackendHttpClient(hangingBackend.URL, 10*time.Millisecond), nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string))
+
+		_, err := client.SendWebhookEvaluationResult(&EvaluationResultRequest{})
+
+		assert.NotErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, requestFailed, getRequestOutcome(0, err))
+	})
+}
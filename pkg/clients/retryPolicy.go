@@ -0,0 +1,43 @@
+package clients
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// noRetryPolicy is used by the calls that aren't idempotent, a failed upload is retried by the evaluation result queue instead
+var noRetryPolicy = retryPolicy{maxAttempts: 1}
+
+// retryPolicies by call name, only the idempotent GET calls are listed
+var retryPolicies = map[string]retryPolicy{
+	"RequestClusterEvaluationPrerunData": {maxAttempts: 3, initialBackoff: 200 * time.Millisecond, maxBackoff: 2 * time.Second},
+	"GetVersionRelatedMessages":          {maxAttempts: 2, initialBackoff: 200 * time.Millisecond, maxBackoff: time.Second},
+}
+
+func getRetryPolicy(call string, method string) retryPolicy {
+	policy, ok := retryPolicies[call]
+	if !ok || method != http.MethodGet {
+		return noRetryPolicy
+	}
+	return policy
+}
+
+// backoff the exponential backoff after the given failed attempt, jittered between half of it and all of it
+// so the replicas don't retry in sync
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.initialBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	if backoff <= 1 {
+		return backoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
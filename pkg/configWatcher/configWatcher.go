@@ -0,0 +1,137 @@
+package configWatcher
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+)
+
+const DefaultPollInterval = 10 * time.Second
+
+// Reloader re-reads and applies its config, it should keep the current config when it returns an error
+type Reloader struct {
+	Name   string
+	Reload func() error
+}
+
+// ConfigWatcher polls a config directory and runs the reloaders when any of its files changes.
+// files are compared by content, so the symlink swap kubelet does when a mounted ConfigMap is updated is detected as well
+type ConfigWatcher struct {
+	dir          string
+	pollInterval time.Duration
+	reloaders    []Reloader
+	logger       *logger.Logger
+
+	lastFingerprint string
+	stopChannel     chan struct{}
+	stopOnce        sync.Once
+}
+
+func New(dir string, pollInterval time.Duration, logger *logger.Logger, reloaders ...Reloader) *ConfigWatcher {
+	return &ConfigWatcher{
+		dir:          dir,
+		pollInterval: pollInterval,
+		reloaders:    reloaders,
+		logger:       logger,
+		stopChannel:  make(chan struct{}),
+	}
+}
+
+// Start the config is expected to be loaded already, only changes from this point on trigger a reload
+func (w *ConfigWatcher) Start() {
+	fingerprint, err := getDirFingerprint(w.dir)
+	if err != nil {
+		w.logger.LogWarn(fmt.Sprintf("config watcher: failed to read %s, err: %s", w.dir, err))
+	}
+	w.lastFingerprint = fingerprint
+
+	go func() {
+		ticker := time.NewTicker(w.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.checkForChanges()
+			case <-w.stopChannel:
+				return
+			}
+		}
+	}()
+}
+
+func (w *ConfigWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopChannel)
+	})
+}
+
+func (w *ConfigWatcher) checkForChanges() {
+	fingerprint, err := getDirFingerprint(w.dir)
+	if err != nil {
+		w.logger.LogError(fmt.Sprintf("config watcher: failed to read %s, keeping the current config, err: %s", w.dir, err))
+		return
+	}
+	if fingerprint == w.lastFingerprint {
+		return
+	}
+	w.lastFingerprint = fingerprint
+
+	w.logger.LogInfo(fmt.Sprintf("config watcher: change detected in %s, reloading", w.dir))
+	for _, reloader := range w.reloaders {
+		if err := reloader.Reload(); err != nil {
+			w.logger.LogError(fmt.Sprintf("config watcher: invalid %s, keeping the current config, err: %s", reloader.Name, err))
+		}
+	}
+}
+
+// getDirFingerprint hashes the names and contents of the files in dir.
+// kubelet's hidden ..data and timestamped directories are skipped, the files are read through their symlinks
+func getDirFingerprint(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	fileNames := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "..") {
+			fileNames = append(fileNames, entry.Name())
+		}
+	}
+	sort.Strings(fileNames)
+
+	hash := sha256.New()
+	for _, fileName := range fileNames {
+		filePath := filepath.Join(dir, fileName)
+		fileInfo, err := os.Stat(filePath)
+		if err != nil || fileInfo.IsDir() {
+			continue
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return "", err
+		}
+		_, err = fmt.Fprintf(hash, "%s\x00%d\x00", fileName, fileInfo.Size())
+		if err == nil {
+			_, err = io.Copy(hash, file)
+		}
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,70 @@
+package configWatcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+// writeConfigMapVolume lays out files the way kubelet does for a ConfigMap volume:
+// the files are symlinks to ..data/<file>, and ..data is a symlink to a timestamped directory that is swapped on every update
+func writeConfigMapVolume(t *testing.T, dir string, version string, files map[string]string) {
+	versionDir := filepath.Join(dir, "..version_"+version)
+	assert.NoError(t, os.Mkdir(versionDir, 0755))
+	for fileName, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(versionDir, fileName), []byte(content), 0644))
+	}
+
+	tmpDataLink := filepath.Join(dir, "..data_tmp")
+	assert.NoError(t, os.Symlink(filepath.Base(versionDir), tmpDataLink))
+	assert.NoError(t, os.Rename(tmpDataLink, filepath.Join(dir, "..data")))
+
+	for fileName := range files {
+		fileLink := filepath.Join(dir, fileName)
+		if _, err := os.Lstat(fileLink); errors.Is(err, os.ErrNotExist) {
+			assert.NoError(t, os.Symlink(filepath.Join("..data", fileName), fileLink))
+		}
+	}
+}
+
+func TestConfigWatcher(t *testing.T) {
+	configDir := t.TempDir()
+	writeConfigMapVolume(t, configDir, "1", map[string]string{"datreeSkipList": "- a;b;c"})
+
+	reloadCount := 0
+	reloadErr := error(nil)
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	watcher := New(configDir, DefaultPollInterval, &mockLogger, Reloader{Name: "test", Reload: func() error {
+		reloadCount++
+		return reloadErr
+	}})
+	watcher.Start()
+	defer watcher.Stop()
+
+	t.Run("should not reload when nothing changed", func(t *testing.T) {
+		watcher.checkForChanges()
+		assert.Equal(t, 0, reloadCount)
+	})
+
+	t.Run("should reload after kubelet swaps the ..data symlink", func(t *testing.T) {
+		writeConfigMapVolume(t, configDir, "2", map[string]string{"datreeSkipList": "- a;b;d"})
+		watcher.checkForChanges()
+		assert.Equal(t, 1, reloadCount)
+
+		watcher.checkForChanges()
+		assert.Equal(t, 1, reloadCount)
+	})
+
+	t.Run("should not retry an invalid config until it changes again", func(t *testing.T) {
+		reloadErr = errors.New("invalid")
+		writeConfigMapVolume(t, configDir, "3", map[string]string{"datreeSkipList": "- a;b"})
+		watcher.checkForChanges()
+		watcher.checkForChanges()
+		assert.Equal(t, 2, reloadCount)
+	})
+}
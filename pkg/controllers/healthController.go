@@ -1,15 +1,26 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/responseWriter"
+	"github.com/datreeio/admission-webhook-datree/pkg/server"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 )
 
-type HealthController struct{}
+type HealthController struct {
+	// certificateReloader is nil when the server doesn't serve TLS
+	certificateReloader *server.CertificateReloader
+	state               *servicestate.ServiceState
+}
 
-func NewHealthController() *HealthController {
-	return &HealthController{}
+func NewHealthController(certificateReloader *server.CertificateReloader, state *servicestate.ServiceState) *HealthController {
+	return &HealthController{
+		certificateReloader: certificateReloader,
+		state:               state,
+	}
 }
 
 func (h *HealthController) Health(w http.ResponseWriter, req *http.Request) {
@@ -17,7 +28,33 @@ func (h *HealthController) Health(w http.ResponseWriter, req *http.Request) {
 	writer.Write("OK")
 }
 
+type ConfigCheckResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+// ConfigCheck validates the mounted config files, an invalid file is not applied by the config reload, so its errors
+// are otherwise only found in the logs. the reason the last fetched prerun data was rejected is reported as well
+func (h *HealthController) ConfigCheck(w http.ResponseWriter, req *http.Request) {
+	writer := responseWriter.New(w)
+	response := ConfigCheckResponse{Valid: true, Errors: []string{}}
+	errs := servicestate.CheckConfigFiles()
+	if prerunDataErr := h.state.GetPrerunDataError(); prerunDataErr != nil {
+		errs = append(errs, prerunDataErr)
+	}
+	for _, err := range errs {
+		response.Valid = false
+		response.Errors = append(response.Errors, err.Error())
+	}
+	writer.WriteBody(response)
+}
+
+// Ready the replica can't serve the API server once its certificate expired, until a renewed certificate is reloaded
 func (h *HealthController) Ready(w http.ResponseWriter, req *http.Request) {
 	writer := responseWriter.New(w)
+	if h.certificateReloader != nil && h.certificateReloader.IsExpired() {
+		writer.ServiceUnavailable(fmt.Sprintf("TLS certificate expired at %s", h.certificateReloader.NotAfter().Format(time.RFC3339)))
+		return
+	}
 	writer.Write("OK")
 }
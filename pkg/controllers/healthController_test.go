@@ -1,11 +1,16 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,7 +18,7 @@ func TestHealth(t *testing.T) {
 	request := httptest.NewRequest(http.MethodGet, "/health", nil)
 	responseRecorder := httptest.NewRecorder()
 
-	healthController := NewHealthController()
+	healthController := NewHealthController(nil, servicestate.New())
 	healthController.Health(responseRecorder, request)
 
 	assert.Equal(t, responseRecorder.Code, http.StatusOK)
@@ -24,9 +29,54 @@ func TestReady(t *testing.T) {
 	request := httptest.NewRequest(http.MethodGet, "/ready", nil)
 	responseRecorder := httptest.NewRecorder()
 
-	healthController := NewHealthController()
+	healthController := NewHealthController(nil, servicestate.New())
 	healthController.Ready(responseRecorder, request)
 
 	assert.Equal(t, responseRecorder.Code, http.StatusOK)
 	assert.Equal(t, strings.TrimSpace(responseRecorder.Body.String()), "OK")
 }
+
+func TestConfigCheck(t *testing.T) {
+	checkConfig := func(t *testing.T, skipList string) ConfigCheckResponse {
+		setMockConfigFileDir(t)
+		assert.NoError(t, os.WriteFile(filepath.Join(servicestate.DATREE_CONFIG_FILE_DIR, "datreeSkipList"), []byte(skipList), 0644))
+		request := httptest.NewRequest(http.MethodGet, "/config-check", nil)
+		responseRecorder := httptest.NewRecorder()
+
+		NewHealthController(nil, servicestate.New()).ConfigCheck(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Code)
+		var response ConfigCheckResponse
+		assert.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &response))
+		return response
+	}
+
+	t.Run("should be valid when the config files are valid", func(t *testing.T) {
+		response := checkConfig(t, `- "(.*);(.*);(^aws-node.*)"`)
+
+		assert.Equal(t, ConfigCheckResponse{Valid: true, Errors: []string{}}, response)
+	})
+
+	t.Run("should list the errors of the config files", func(t *testing.T) {
+		response := checkConfig(t, `
+- "(.*);(.*)"
+- name: "("
+`)
+
+		assert.False(t, response.Valid)
+		assert.Len(t, response.Errors, 2)
+	})
+
+	t.Run("should report the rejected prerun data", func(t *testing.T) {
+		setMockConfigFileDir(t)
+		state := servicestate.New()
+		state.SetPrerunDataError(errors.New(`invalid prerun data: unknown action "Enforc" for policy Typo`))
+		responseRecorder := httptest.NewRecorder()
+
+		NewHealthController(nil, state).ConfigCheck(responseRecorder, httptest.NewRequest(http.MethodGet, "/config-check", nil))
+
+		var response ConfigCheckResponse
+		assert.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &response))
+		assert.Equal(t, ConfigCheckResponse{Valid: false, Errors: []string{`invalid prerun data: unknown action "Enforc" for policy Typo`}}, response)
+	})
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/errorReporter"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/admission-webhook-datree/pkg/responseWriter"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/admission-webhook-datree/pkg/services"
+	"github.com/datreeio/datree/pkg/utils"
+	"github.com/google/uuid"
+)
+
+type MutationController struct {
+	MutationService *services.MutationService
+	ErrorReporter   *errorReporter.ErrorReporter
+	logger          *logger.Logger
+}
+
+func NewMutationController(cliServiceClient *clients.CliClient, state *servicestate.ServiceState, errorReporter *errorReporter.ErrorReporter, logger *logger.Logger, prerunDataProvider services.PrerunDataProvider, validationService *services.ValidationService) *MutationController {
+	mutationService := &services.MutationService{
+		CliServiceClient:   cliServiceClient,
+		State:              state,
+		ErrorReporter:      errorReporter,
+		PrerunDataProvider: prerunDataProvider,
+		ValidationService:  validationService,
+		Logger:             logger,
+	}
+
+	return &MutationController{
+		MutationService: mutationService,
+		ErrorReporter:   errorReporter,
+		logger:          logger,
+	}
+}
+
+func (c *MutationController) Mutate(w http.ResponseWriter, req *http.Request) {
+	requestLogger := c.logger.WithRequestId(uuid.NewString())
+
+	var warningMessages []string
+	writer := responseWriter.New(w)
+
+	if req.Method != http.MethodPost {
+		writer.NotAllowed("Method not allowed")
+		return
+	}
+
+	err := headerValidation(req)
+	if err != nil {
+		requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("header validation failed: %s", err))
+		writer.BadRequest(err.Error())
+		return
+	}
+
+	admissionReviewReq, err := ParseHTTPRequestBodyToAdmissionReview(req.Body)
+	if err != nil {
+		requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("parsing request body failed: %s", err))
+		writer.BadRequest(err.Error())
+		return
+	}
+	requestLogger = requestLogger.WithAdmissionRequest(admissionReviewReq.Request)
+
+	// global panic errors handler, a failed mutation should never block the resource
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			c.ErrorReporter.ReportPanicError(panicErr)
+			requestLogger.LogError(utils.ParseErrorToString(panicErr))
+			warningMessages = append(warningMessages, "Datree failed to auto-fix the applied resource. Check the pod logs for more details.")
+			writer.WriteBody(services.ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, warningMessages))
+		}
+	}()
+
+	ctx, cancel := newRequestContext(req, c.MutationService.State.GetValidationTimeout())
+	defer cancel()
+
+	requestLogger.LogAdmissionRequest(admissionReviewReq, false, logger.Incoming)
+	admissionReview, isSkipped := c.MutationService.Mutate(ctx, admissionReviewReq, &warningMessages, requestLogger)
+	writer.WriteBody(admissionReview)
+
+	admissionReview.Request = admissionReviewReq.Request
+	requestLogger.LogAdmissionRequest(admissionReview, isSkipped, logger.Outgoing)
+}
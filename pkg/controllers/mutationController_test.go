@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/errorReporter"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/admission-webhook-datree/pkg/services"
+	"github.com/datreeio/datree/pkg/httpClient"
+	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zapcore"
+	admission "k8s.io/api/admission/v1"
+)
+
+func TestMutateRequestBodyWithFixableK8sResource(t *testing.T) {
+	setMockEnv(t)
+	request := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(getPodApplyRequest()))
+	request.Header.Set("Content-Type", "application/json")
+	responseRecorder := httptest.NewRecorder()
+
+	mutationController := mockMutationController(httpClient.Response{
+		StatusCode: http.StatusOK,
+		Body:       getPrerunDataResponse,
+	})
+	mutationController.Mutate(responseRecorder, request)
+
+	admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+	assert.Equal(t, true, admissionResponse.Allowed)
+	assert.Equal(t, admission.PatchTypeJSONPatch, *admissionResponse.PatchType)
+	assert.Contains(t, admissionResponse.Warnings[0], "CONTAINERS_MISSING_MEMORY_LIMIT_KEY")
+
+	var patchOperations []map[string]interface{}
+	err := json.Unmarshal(admissionResponse.Patch, &patchOperations)
+	assert.NoError(t, err)
+	assert.Equal(t, "replace", patchOperations[0]["op"])
+	assert.Equal(t, "/spec/containers/0", patchOperations[0]["path"])
+
+	container := patchOperations[0]["value"].(map[string]interface{})
+	limits := container["resources"].(map[string]interface{})["limits"].(map[string]interface{})
+	assert.Equal(t, "512Mi", limits["memory"])
+}
+
+func TestMutateRequestBodyWithUpdate(t *testing.T) {
+	setMockEnv(t)
+	var admissionReview admission.AdmissionReview
+	assert.NoError(t, json.Unmarshal([]byte(getPodApplyRequest()), &admissionReview))
+	admissionReview.Request.Operation = admission.Update
+	updateRequest, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(string(updateRequest)))
+	request.Header.Set("Content-Type", "application/json")
+	responseRecorder := httptest.NewRecorder()
+
+	mutationController := mockMutationController(httpClient.Response{
+		StatusCode: http.StatusOK,
+		Body:       getPrerunDataResponse,
+	})
+	mutationController.Mutate(responseRecorder, request)
+
+	admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+	assert.Equal(t, true, admissionResponse.Allowed)
+	assert.Nil(t, admissionResponse.Patch)
+}
+
+func TestMutateRequestBodyWithExceptions(t *testing.T) {
+	mutate := func(t *testing.T, mutationController *MutationController, podApplyRequest string) *admission.AdmissionResponse {
+		request := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(podApplyRequest))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+		mutationController.Mutate(responseRecorder, request)
+		return responseToAdmissionResponse(responseRecorder.Body.String())
+	}
+
+	t.Run("should not fix the rules the resource is excepted from", func(t *testing.T) {
+		setMockEnv(t)
+		mutationController := mockMutationController(httpClient.Response{
+			StatusCode: http.StatusOK,
+			Body:       getPrerunDataResponse,
+		})
+		mutationController.MutationService.State.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+			config.RuleExceptions = &servicestate.RuleExceptions{Allowances: []servicestate.RuleExceptionAllowance{{Rules: []string{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY"}}}}
+			return config
+		})
+
+		admissionResponse := mutate(t, mutationController, getPodApplyRequestWithAnnotations(map[string]string{
+			"admission.datree/exception-rules":         "CONTAINERS_MISSING_MEMORY_LIMIT_KEY",
+			"admission.datree/exception-justification": "the limits are tuned by the vertical pod autoscaler",
+			"admission.datree/exception-expires":       time.Now().Add(24 * time.Hour).Format(time.RFC3339),
+		}))
+
+		assert.Equal(t, true, admissionResponse.Allowed)
+		assert.Nil(t, admissionResponse.Patch)
+	})
+
+	t.Run("should not fix the resources of the requesters with bypass permissions", func(t *testing.T) {
+		setMockEnv(t)
+		mutationController := mockMutationController(httpClient.Response{
+			StatusCode: http.StatusOK,
+			Body:       getPrerunDataResponse,
+		})
+		mutationController.MutationService.State.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+			config.BypassPermissions = &servicestate.BypassPermissions{UserAccounts: []string{"^admin$"}}
+			return config
+		})
+
+		admissionResponse := mutate(t, mutationController, getPodApplyRequest())
+
+		assert.Equal(t, true, admissionResponse.Allowed)
+		assert.Nil(t, admissionResponse.Patch)
+	})
+}
+
+func TestMutateRequestBodyWithUnsupportedKind(t *testing.T) {
+	setMockEnv(t)
+	request := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader(applyRequestNotAllowedJson))
+	request.Header.Set("Content-Type", "application/json")
+	responseRecorder := httptest.NewRecorder()
+
+	mutationController := mockMutationController(httpClient.Response{
+		StatusCode: http.StatusOK,
+		Body:       getPrerunDataResponse,
+	})
+	mutationController.Mutate(responseRecorder, request)
+
+	admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+	assert.Equal(t, true, admissionResponse.Allowed)
+	assert.Nil(t, admissionResponse.PatchType)
+	assert.Nil(t, admissionResponse.Patch)
+}
+
+// getPodApplyRequest returns the not allowed request fixture as a request that creates a Pod instead of updating a Scale sub resource
+func getPodApplyRequest() string {
+	return getPodApplyRequestWithAnnotations(nil)
+}
+
+func getPodApplyRequestWithAnnotations(annotations map[string]string) string {
+	var admissionReview admission.AdmissionReview
+	if err := json.Unmarshal([]byte(applyRequestNotAllowedJson), &admissionReview); err != nil {
+		panic(err)
+	}
+	admissionReview.Request.Kind.Group = ""
+	admissionReview.Request.Kind.Kind = "Pod"
+	admissionReview.Request.SubResource = ""
+	admissionReview.Request.Operation = admission.Create
+	admissionReview.Request.OldObject.Raw = nil
+	if annotations != nil {
+		var object map[string]interface{}
+		if err := json.Unmarshal(admissionReview.Request.Object.Raw, &object); err != nil {
+			panic(err)
+		}
+		object["metadata"].(map[string]interface{})["annotations"] = annotations
+		objectRaw, err := json.Marshal(object)
+		if err != nil {
+			panic(err)
+		}
+		admissionReview.Request.Object.Raw = objectRaw
+	}
+
+	podApplyRequest, err := json.Marshal(admissionReview)
+	if err != nil {
+		panic(err)
+	}
+	return string(podApplyRequest)
+}
+
+func mockMutationController(mockedResponse httpClient.Response) *MutationController {
+	mockedHttpClient := &MockHttpClient{mockedResponse: mockedResponse}
+	mockedCliServiceClient := clients.NewCustomCliServiceClient("", mockedHttpClient, nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string))
+
+	mockState := servicestate.New()
+	mockState.SetClusterUuid("test-cluster-uuid")
+	mockState.SetK8sVersion("1.18.0")
+	mockState.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+		config.AutoFix = &servicestate.AutoFix{Rules: []string{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY"}}
+		return config
+	})
+
+	mockErrorReporterClient := &MockErrorReporterClient{}
+	mockErrorReporterClient.On("ReportError", mock.Anything, mock.Anything).Return(200, nil)
+	mockErrorReporter := errorReporter.NewErrorReporter(mockErrorReporterClient, mockState)
+
+	mockLogger := logger.New(zapcore.InfoLevel, mockErrorReporter)
+
+	prerunDataProvider := services.NewPrerunDataProvider(mockedCliServiceClient, mockState)
+	validationService := &services.ValidationService{CliServiceClient: mockedCliServiceClient, State: mockState, PrerunDataProvider: prerunDataProvider, Logger: &mockLogger}
+	return NewMutationController(mockedCliServiceClient, mockState, mockErrorReporter, &mockLogger, prerunDataProvider, validationService)
+}
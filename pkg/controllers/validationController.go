@@ -1,17 +1,24 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/openshiftService"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	"github.com/datreeio/admission-webhook-datree/pkg/eventRecorder"
+	"github.com/datreeio/admission-webhook-datree/pkg/k8sClient"
 	"github.com/datreeio/admission-webhook-datree/pkg/k8sMetadataUtil"
+	"github.com/datreeio/admission-webhook-datree/pkg/metrics"
 	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
 	"github.com/datreeio/admission-webhook-datree/pkg/logger"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/errorReporter"
@@ -30,15 +37,27 @@ type ValidationController struct {
 	logger            *logger.Logger
 }
 
-func NewValidationController(cliServiceClient *clients.CliClient, state *servicestate.ServiceState, errorReporter *errorReporter.ErrorReporter, k8sMetadataUtilInstance *k8sMetadataUtil.K8sMetadataUtil, logger *logger.Logger, openshiftService *openshiftService.OpenshiftService) *ValidationController {
+func NewValidationController(cliServiceClient *clients.CliClient, state *servicestate.ServiceState, errorReporter *errorReporter.ErrorReporter, k8sMetadataUtilInstance *k8sMetadataUtil.K8sMetadataUtil, logger *logger.Logger, openshiftService *openshiftService.OpenshiftService, prerunDataProvider services.PrerunDataProvider, leaderElection *leaderElection.LeaderElection) *ValidationController {
 	validationService := &services.ValidationService{
+		CliServiceClient:   cliServiceClient,
+		State:              state,
+		K8sMetadataUtil:    k8sMetadataUtilInstance,
+		ErrorReporter:      errorReporter,
+		OpenshiftService:   openshiftService,
+		PrerunDataProvider: prerunDataProvider,
+		Logger:             logger,
+	}
+	resultSinkDependencies := services.ResultSinkDependencies{
 		CliServiceClient: cliServiceClient,
-		State:            state,
-		K8sMetadataUtil:  k8sMetadataUtilInstance,
-		ErrorReporter:    errorReporter,
-		OpenshiftService: openshiftService,
+		SpoolDir:         state.GetResultSpoolDir(),
+		LeaderElection:   leaderElection,
 		Logger:           logger,
 	}
+	setPolicyReportClients(&resultSinkDependencies, state, k8sMetadataUtilInstance, logger)
+	validationService.ResultSinks = services.NewResultSinks(state.GetResultSinks(), resultSinkDependencies)
+	if state.GetKubernetesEvents() && k8sMetadataUtilInstance.ClientSet != nil {
+		validationService.EventRecorder = eventRecorder.New(k8sMetadataUtilInstance.ClientSet)
+	}
 
 	return &ValidationController{
 		ValidationService: validationService,
@@ -47,8 +66,32 @@ func NewValidationController(cliServiceClient *clients.CliClient, state *service
 	}
 }
 
+// setPolicyReportClients the kubernetes clients are only created when the policyReport sink is configured
+func setPolicyReportClients(resultSinkDependencies *services.ResultSinkDependencies, state *servicestate.ServiceState, k8sMetadataUtilInstance *k8sMetadataUtil.K8sMetadataUtil, logger *logger.Logger) {
+	for _, resultSinkConfig := range state.GetResultSinks() {
+		if resultSinkConfig.Type != enums.PolicyReportResultSink {
+			continue
+		}
+		dynamicClient, err := k8sClient.NewDynamicClient()
+		if err != nil {
+			logger.LogError(fmt.Sprintf("failed to create the kubernetes client of the policyReport sink: %s", err))
+			return
+		}
+		resultSinkDependencies.DynamicClient = dynamicClient
+
+		metadataClient, err := k8sClient.NewMetadataClient()
+		if err != nil || k8sMetadataUtilInstance.ClientSet == nil {
+			logger.LogError(fmt.Sprintf("the results of the deleted resources won't be pruned from the policy reports, err: %v", err))
+			return
+		}
+		resultSinkDependencies.MetadataClient = metadataClient
+		resultSinkDependencies.DiscoveryClient = k8sMetadataUtilInstance.ClientSet.Discovery()
+		return
+	}
+}
+
 func (c *ValidationController) Validate(w http.ResponseWriter, req *http.Request) {
-	c.logger.SetRequestId(uuid.NewString())
+	requestLogger := c.logger.WithRequestId(uuid.NewString())
 
 	var warningMessages []string
 	writer := responseWriter.New(w)
@@ -60,34 +103,53 @@ func (c *ValidationController) Validate(w http.ResponseWriter, req *http.Request
 
 	err := headerValidation(req)
 	if err != nil {
-		c.logger.LogAndReportUnexpectedError(fmt.Sprintf("header validation failed: %s", err))
+		requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("header validation failed: %s", err))
 		writer.BadRequest(err.Error())
 		return
 	}
 
 	admissionReviewReq, err := ParseHTTPRequestBodyToAdmissionReview(req.Body)
 	if err != nil {
-		c.logger.LogAndReportUnexpectedError(fmt.Sprintf("parsing request body failed: %s", err))
+		requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("parsing request body failed: %s", err))
 		writer.BadRequest(err.Error())
 		return
 	}
+	requestLogger = requestLogger.WithAdmissionRequest(admissionReviewReq.Request)
 
 	// global panic errors handler
 	defer func() {
 		if panicErr := recover(); panicErr != nil {
 			c.ErrorReporter.ReportPanicError(panicErr)
-			c.logger.LogError(utils.ParseErrorToString(panicErr))
+			requestLogger.LogError(utils.ParseErrorToString(panicErr))
+			metrics.RecordAdmission(metrics.OutcomeError, admissionReviewReq.Request.Kind.Kind, admissionReviewReq.Request.Namespace, "")
+			if c.ValidationService.State.GetConfig().FailurePolicy.IsFailClosed(admissionReviewReq.Request.Namespace) {
+				writer.WriteBody(services.ParseEvaluationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, false, services.GetFailClosedMessage(utils.ParseErrorToString(panicErr)), warningMessages))
+				return
+			}
 			warningMessages = append(warningMessages, "Datree failed to validate the applied resource. Check the pod logs for more details.")
 			writer.WriteBody(services.ParseEvaluationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, true, utils.ParseErrorToString(panicErr), warningMessages))
 		}
 	}()
 
-	c.logger.LogAdmissionRequest(admissionReviewReq, false, logger.Incoming)
-	admissionReview, isSkipped := c.ValidationService.Validate(admissionReviewReq, &warningMessages)
+	ctx, cancel := newRequestContext(req, c.ValidationService.State.GetValidationTimeout())
+	defer cancel()
+
+	requestLogger.LogAdmissionRequest(admissionReviewReq, false, logger.Incoming)
+	admissionReview, isSkipped := c.ValidationService.Validate(ctx, admissionReviewReq, &warningMessages, requestLogger)
 	writer.WriteBody(admissionReview)
 
 	admissionReview.Request = admissionReviewReq.Request
-	c.logger.LogAdmissionRequest(admissionReview, isSkipped, logger.Outgoing)
+	requestLogger.LogAdmissionRequest(admissionReview, isSkipped, logger.Outgoing)
+}
+
+// newRequestContext bounds the request by the validation timeout, or by 90% of the timeout the API server appends to the
+// webhook URL when it is shorter, so the decision is returned before the API server gives up on the webhook
+func newRequestContext(req *http.Request, validationTimeout time.Duration) (context.Context, context.CancelFunc) {
+	budget := validationTimeout
+	if apiServerTimeout, err := time.ParseDuration(req.URL.Query().Get("timeout")); err == nil && apiServerTimeout*9/10 < budget {
+		budget = apiServerTimeout * 9 / 10
+	}
+	return context.WithTimeout(req.Context(), budget)
 }
 
 func headerValidation(req *http.Request) error {
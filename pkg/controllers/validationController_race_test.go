@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/datree/pkg/httpClient"
+	"github.com/stretchr/testify/assert"
+	admission "k8s.io/api/admission/v1"
+)
+
+// TestValidateConcurrentRequests is meant to run with -race, it hammers /validate while the config is being replaced.
+// every request is decided by its own config, the config snapshot overridden with the prerun data, never by the
+// config of another request or by the config updates
+func TestValidateConcurrentRequests(t *testing.T) {
+	setMockEnv(t)
+	// the prerun data overrides the config on every request when the config is managed from the dashboard
+	t.Setenv(enums.ConfigFromHelm, "false")
+	setMockConfigFileDir(t)
+
+	validationController := mockValidationController(httpClient.Response{
+		StatusCode: http.StatusOK,
+		Body:       getEnforcePrerunDataResponse(t, "(^skipped-namespace$);(.*);(.*)"),
+	})
+	state := validationController.ValidationService.State
+	otherSkipList, errs := servicestate.ParseLegacySkipList([]string{"(^my-namespace$);(.*);(.*)"})
+	assert.Empty(t, errs)
+
+	const requestsCount = 50
+	var waitGroup sync.WaitGroup
+	stopConfigUpdates := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-stopConfigUpdates:
+				return
+			default:
+				assert.NoError(t, state.ReloadConfigFiles())
+				// none of these may apply, the prerun data of every request overrides them
+				state.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+					config.IsEnforceMode = false
+					config.SkipList = otherSkipList
+					config.BypassPermissions = &servicestate.BypassPermissions{UserAccounts: []string{"admin"}}
+					return config
+				})
+			}
+		}
+	}()
+
+	deniedRequest := applyRequestNotAllowedJson
+	skippedRequest := getRequestInNamespace(t, applyRequestNotAllowedJson, "skipped-namespace")
+	for i := 0; i < requestsCount; i++ {
+		isDenied := i%2 == 0
+		requestBody := skippedRequest
+		if isDenied {
+			requestBody = deniedRequest
+		}
+
+		waitGroup.Add(1)
+		go func(requestBody string, isDenied bool) {
+			defer waitGroup.Done()
+			request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(requestBody))
+			request.Header.Set("Content-Type", "application/json")
+			responseRecorder := httptest.NewRecorder()
+
+			validationController.Validate(responseRecorder, request)
+
+			assert.Equal(t, http.StatusOK, responseRecorder.Code)
+			admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+			if isDenied {
+				assert.Equal(t, false, admissionResponse.Allowed)
+				assert.Contains(t, admissionResponse.Result.Message, "Ensure each container has a configured memory limit")
+			} else {
+				assert.Equal(t, true, admissionResponse.Allowed)
+				assert.Equal(t, "We're good!", admissionResponse.Result.Message)
+			}
+		}(requestBody, isDenied)
+	}
+
+	waitGroup.Wait()
+	close(stopConfigUpdates)
+}
+
+// getEnforcePrerunDataResponse returns the prerun data fixture in enforce mode, with the given ignore patterns
+func getEnforcePrerunDataResponse(t *testing.T, ignorePatterns ...string) []byte {
+	var prerunData map[string]interface{}
+	assert.NoError(t, json.Unmarshal(getPrerunDataResponse, &prerunData))
+	prerunData["actionOnFailure"] = enums.EnforceActionOnFailure
+	prerunData["ignorePatterns"] = ignorePatterns
+	prerunDataResponse, err := json.Marshal(prerunData)
+	assert.NoError(t, err)
+	return prerunDataResponse
+}
+
+// getRequestInNamespace returns the request fixture for a resource of the given namespace
+func getRequestInNamespace(t *testing.T, requestBody string, namespace string) string {
+	var admissionReview admission.AdmissionReview
+	assert.NoError(t, json.Unmarshal([]byte(requestBody), &admissionReview))
+	admissionReview.Request.Namespace = namespace
+	for _, rawObject := range []*[]byte{&admissionReview.Request.Object.Raw, &admissionReview.Request.OldObject.Raw} {
+		if *rawObject == nil {
+			continue
+		}
+		var object map[string]interface{}
+		assert.NoError(t, json.Unmarshal(*rawObject, &object))
+		object["metadata"].(map[string]interface{})["namespace"] = namespace
+		raw, err := json.Marshal(object)
+		assert.NoError(t, err)
+		*rawObject = raw
+	}
+
+	namespacedRequest, err := json.Marshal(admissionReview)
+	assert.NoError(t, err)
+	return string(namespacedRequest)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/logger"
 	"github.com/datreeio/admission-webhook-datree/pkg/openshiftService"
@@ -22,8 +23,11 @@ import (
 
 	"github.com/datreeio/admission-webhook-datree/pkg/clients"
 	"github.com/datreeio/admission-webhook-datree/pkg/config"
+	"github.com/datreeio/admission-webhook-datree/pkg/metrics"
+	"github.com/datreeio/admission-webhook-datree/pkg/services"
 	"github.com/datreeio/datree/pkg/httpClient"
 	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	admission "k8s.io/api/admission/v1"
 	"k8s.io/client-go/kubernetes/fake"
@@ -51,6 +55,13 @@ func setMockEnv(t *testing.T) {
 	t.Setenv(enums.Enforce, "true")
 }
 
+// setMockConfigFileDir points the config files to a temporary directory until the end of the test
+func setMockConfigFileDir(t *testing.T) {
+	configFileDir := servicestate.DATREE_CONFIG_FILE_DIR
+	t.Cleanup(func() { servicestate.DATREE_CONFIG_FILE_DIR = configFileDir })
+	servicestate.DATREE_CONFIG_FILE_DIR = t.TempDir()
+}
+
 func TestHeaderValidation(t *testing.T) {
 	setMockEnv(t)
 	request := httptest.NewRequest(http.MethodPost, "/validate", nil)
@@ -144,9 +155,34 @@ func TestValidateRequestBodyWithNotAllowedK8sResource(t *testing.T) {
 		StatusCode: http.StatusOK,
 		Body:       getPrerunDataResponse,
 	})
+	deniedCounter := metrics.AdmissionRequestsTotal.WithLabelValues(metrics.OutcomeDenied, "Scale", "my-namespace", "Default")
+	deniedCountBefore := testutil.ToFloat64(deniedCounter)
 
 	validationController.Validate(responseRecorder, request)
 	assert.Equal(t, false, responseToAdmissionResponse(responseRecorder.Body.String()).Allowed)
+	assert.Equal(t, deniedCountBefore+1, testutil.ToFloat64(deniedCounter))
+}
+
+func TestValidateRequestBodyWithNoPolicyInScope(t *testing.T) {
+	setMockEnv(t)
+	request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(applyRequestNotAllowedJson))
+	request.Header.Set("Content-Type", "application/json")
+	responseRecorder := httptest.NewRecorder()
+
+	validationController := mockValidationController(httpClient.Response{
+		StatusCode: http.StatusOK,
+		Body:       getPrerunDataResponse,
+	})
+	validationController.ValidationService.State.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+		config.MultiplePolicies = &servicestate.MultiplePolicies{{Policy: "Default", Namespaces: servicestate.Namespaces{IncludePatterns: []string{"^other-namespace$"}}}}
+		return config
+	})
+	skippedCounter := metrics.AdmissionRequestsTotal.WithLabelValues(metrics.OutcomeSkipped, "Scale", "my-namespace", "")
+	skippedCountBefore := testutil.ToFloat64(skippedCounter)
+
+	validationController.Validate(responseRecorder, request)
+	assert.Equal(t, true, responseToAdmissionResponse(responseRecorder.Body.String()).Allowed)
+	assert.Equal(t, skippedCountBefore+1, testutil.ToFloat64(skippedCounter))
 }
 
 func TestValidateRequestBodyWithNotAllowedK8sResourceBypassed(t *testing.T) {
@@ -161,10 +197,16 @@ func TestValidateRequestBodyWithNotAllowedK8sResourceBypassed(t *testing.T) {
 		Body:       getPrerunDataResponse,
 	})
 
-	validationController.ValidationService.State.SetBypassPermissions(&servicestate.BypassPermissions{UserAccounts: []string{"admin"}})
+	validationController.ValidationService.State.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+		config.BypassPermissions = &servicestate.BypassPermissions{UserAccounts: []string{"admin"}}
+		return config
+	})
+	bypassedCounter := metrics.AdmissionRequestsTotal.WithLabelValues(metrics.OutcomeBypassed, "Scale", "my-namespace", "Default")
+	bypassedCountBefore := testutil.ToFloat64(bypassedCounter)
 
 	validationController.Validate(responseRecorder, request)
 	assert.Equal(t, true, responseToAdmissionResponse(responseRecorder.Body.String()).Allowed)
+	assert.Equal(t, bypassedCountBefore+1, testutil.ToFloat64(bypassedCounter))
 }
 
 func TestValidateRequestBodyWithNotAllowedK8sResourceEnforceModeOff(t *testing.T) {
@@ -221,6 +263,147 @@ func TestValidateRequestBodyWithPolicyNotExists(t *testing.T) {
 	assert.Contains(t, admissionResponse.Warnings[0], "Policy NotExistsPolicy not found, skipping evaluation")
 }
 
+func TestValidateRequestBodyWithFailClosedFailurePolicy(t *testing.T) {
+	failClosed := func(validationController *ValidationController, namespaceOverrides ...servicestate.FailurePolicyNamespaceOverride) {
+		validationController.ValidationService.State.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+			config.FailurePolicy = &servicestate.FailurePolicy{Mode: enums.FailClosed, NamespaceOverrides: namespaceOverrides}
+			return config
+		})
+	}
+
+	t.Run("should deny the resource when the prerun data can't be fetched", func(t *testing.T) {
+		setMockEnv(t)
+		request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(applyRequestNotAllowedJson))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+
+		validationController := mockValidationController(httpClient.Response{StatusCode: http.StatusInternalServerError})
+		failClosed(validationController)
+
+		validationController.Validate(responseRecorder, request)
+		admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+		assert.Equal(t, false, admissionResponse.Allowed)
+		assert.Contains(t, admissionResponse.Result.Message, "an error occurred when pulling your policy")
+	})
+
+	t.Run("should allow the resource in a namespace that overrides the failure policy to open", func(t *testing.T) {
+		setMockEnv(t)
+		request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(applyRequestNotAllowedJson))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+
+		validationController := mockValidationController(httpClient.Response{StatusCode: http.StatusInternalServerError})
+		failClosed(validationController, servicestate.FailurePolicyNamespaceOverride{NamespacePatterns: []string{"^my-"}, Mode: enums.FailOpen})
+
+		validationController.Validate(responseRecorder, request)
+		assert.Equal(t, true, responseToAdmissionResponse(responseRecorder.Body.String()).Allowed)
+	})
+
+	t.Run("should deny the resource when a policy can't be created", func(t *testing.T) {
+		setMockEnv(t)
+		request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(applyRequestNotAllowedJson))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+
+		validationController := mockValidationController(httpClient.Response{
+			StatusCode: http.StatusOK,
+			Body: getAndMutatePrerunResponse(func(prerunResponse *clients.ClusterEvaluationPrerunDataResponse) {
+				prerunResponse.ActivePolicies = []string{"NotExistsPolicy"}
+			}),
+		})
+		failClosed(validationController)
+
+		validationController.Validate(responseRecorder, request)
+		admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+		assert.Equal(t, false, admissionResponse.Allowed)
+		assert.Contains(t, admissionResponse.Result.Message, "Policy NotExistsPolicy not found")
+	})
+}
+
+func TestValidateRequestBodyWithinTheApiServerTimeout(t *testing.T) {
+	t.Run("should allow the resource before the API server times out when the prerun data is slow to fetch", func(t *testing.T) {
+		setMockEnv(t)
+		request := httptest.NewRequest(http.MethodPost, "/validate?timeout=1s", strings.NewReader(applyRequestNotAllowedJson))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+
+		validationController := mockValidationControllerWithHttpClient(&MockHttpClient{
+			mockedResponse: httpClient.Response{StatusCode: http.StatusOK, Body: getPrerunDataResponse},
+			delay:          5 * time.Second,
+		})
+
+		startTime := time.Now()
+		validationController.Validate(responseRecorder, request)
+		admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+		assert.Less(t, time.Since(startTime), time.Second)
+		assert.Equal(t, true, admissionResponse.Allowed)
+		assert.Contains(t, admissionResponse.Warnings, "Datree failed to run policy check - an error occurred when pulling your policy")
+	})
+
+	t.Run("should link to the report of a result that is still queued when the budget is tight", func(t *testing.T) {
+		setMockEnv(t)
+		t.Setenv(enums.EnabledWarnings, "failedPolicyCheck")
+		t.Setenv(enums.Enforce, "false")
+		request := httptest.NewRequest(http.MethodPost, "/validate?timeout=4s", strings.NewReader(applyRequestNotAllowedJson))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+
+		validationController := mockValidationController(httpClient.Response{
+			StatusCode: http.StatusOK,
+			Body:       getPrerunDataResponse,
+		})
+
+		validationController.Validate(responseRecorder, request)
+		admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+		assert.Equal(t, true, admissionResponse.Allowed)
+		assert.Contains(t, admissionResponse.Warnings[0], "failed the policy check")
+		assert.Regexp(t, `Get the full report https://app.staging.datree.io/cli/invocations/[0-9a-f-]{36}\?webhook=true`, admissionResponse.Warnings[1])
+	})
+}
+
+func TestValidateRequestBodyWithPerPolicyActionOnFailure(t *testing.T) {
+	t.Run("policy with enforce action should block the resource even though the cluster is in monitor mode", func(t *testing.T) {
+		setMockEnv(t)
+		t.Setenv(enums.ConfigFromHelm, "false")
+		request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(applyRequestNotAllowedJson))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+
+		validationController := mockValidationController(httpClient.Response{
+			StatusCode: http.StatusOK,
+			Body: getAndMutatePrerunResponse(func(prerunResponse *clients.ClusterEvaluationPrerunDataResponse) {
+				prerunResponse.ActionOnFailure = enums.MonitorActionOnFailure
+				prerunResponse.ActivePoliciesActions = map[string]enums.ActionOnFailure{"Default": enums.EnforceActionOnFailure}
+			}),
+		})
+
+		validationController.Validate(responseRecorder, request)
+		assert.Equal(t, false, responseToAdmissionResponse(responseRecorder.Body.String()).Allowed)
+	})
+
+	t.Run("policy with warn action should allow the resource and warn even when failedPolicyCheck warnings are disabled", func(t *testing.T) {
+		setMockEnv(t)
+		t.Setenv(enums.ConfigFromHelm, "false")
+		t.Setenv(enums.EnabledWarnings, "")
+		request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(applyRequestNotAllowedJson))
+		request.Header.Set("Content-Type", "application/json")
+		responseRecorder := httptest.NewRecorder()
+
+		validationController := mockValidationController(httpClient.Response{
+			StatusCode: http.StatusOK,
+			Body: getAndMutatePrerunResponse(func(prerunResponse *clients.ClusterEvaluationPrerunDataResponse) {
+				prerunResponse.ActionOnFailure = enums.EnforceActionOnFailure
+				prerunResponse.ActivePoliciesActions = map[string]enums.ActionOnFailure{"Default": enums.WarnActionOnFailure}
+			}),
+		})
+
+		validationController.Validate(responseRecorder, request)
+		admissionResponse := responseToAdmissionResponse(responseRecorder.Body.String())
+		assert.Equal(t, true, admissionResponse.Allowed)
+		assert.Contains(t, admissionResponse.Warnings[0], "failed the policy check for policy \"Default\"")
+	})
+}
+
 func TestValidateRequestBodyWithAllowedK8sResource(t *testing.T) {
 	setMockEnv(t)
 	request := httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(applyRequestAllowedJson))
@@ -285,9 +468,11 @@ func responseToAdmissionResponse(response string) *admission.AdmissionResponse {
 
 type MockHttpClient struct {
 	mockedResponse httpClient.Response
+	delay          time.Duration
 }
 
 func (mhc *MockHttpClient) Request(method string, resourceURI string, body interface{}, headers map[string]string) (httpClient.Response, error) {
+	time.Sleep(mhc.delay)
 	return mhc.mockedResponse, nil
 }
 
@@ -301,7 +486,10 @@ func (m *MockErrorReporterClient) ReportError(reportCliErrorRequest clients.Repo
 }
 
 func mockValidationController(mockedResponse httpClient.Response) *ValidationController {
-	mockedHttpClient := &MockHttpClient{mockedResponse: mockedResponse}
+	return mockValidationControllerWithHttpClient(&MockHttpClient{mockedResponse: mockedResponse})
+}
+
+func mockValidationControllerWithHttpClient(mockedHttpClient *MockHttpClient) *ValidationController {
 	mockedCliServiceClient := clients.NewCustomCliServiceClient("", mockedHttpClient, nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string))
 	mockK8sMetadataUtil := &k8sMetadataUtil.K8sMetadataUtil{
 		ClientSet: fake.NewSimpleClientset(),
@@ -319,7 +507,7 @@ func mockValidationController(mockedResponse httpClient.Response) *ValidationCon
 
 	mockOpenshiftService := &openshiftService.OpenshiftService{}
 
-	return NewValidationController(mockedCliServiceClient, mockState, mockErrorReporter, mockK8sMetadataUtil, &mockLogger, mockOpenshiftService)
+	return NewValidationController(mockedCliServiceClient, mockState, mockErrorReporter, mockK8sMetadataUtil, &mockLogger, mockOpenshiftService, services.NewPrerunDataProvider(mockedCliServiceClient, mockState), nil)
 }
 
 func convertPrerunResponseJsonToStruct(prerunResponse []byte) *clients.ClusterEvaluationPrerunDataResponse {
@@ -14,11 +14,72 @@ const (
 	PodName         = "POD_NAME"
 	EnabledWarnings = "DATREE_ENABLED_WARNINGS"
 	LogLevel        = "DATREE_LOG_LEVEL"
+	// OfflinePoliciesDir when set, the webhook runs in offline mode, policies are read from this directory and the backend is never called
+	OfflinePoliciesDir = "DATREE_OFFLINE_POLICIES_DIR"
+	// OfflineResultsFile the json lines file evaluation results are written to in offline mode, results are written to stdout when not set
+	OfflineResultsFile = "DATREE_OFFLINE_RESULTS_FILE"
+	// PrerunCacheTTLSeconds how often the cached prerun data is refreshed in the background, 0 fetches the prerun data on every request
+	PrerunCacheTTLSeconds = "DATREE_PRERUN_CACHE_TTL_SECONDS"
+	// KubernetesEvents a Kubernetes Event is recorded for every policy failure unless set to "false"
+	KubernetesEvents = "DATREE_KUBERNETES_EVENTS"
+	// SelfSignedCertificate when "true", the webhook generates its own CA and certificate instead of using the ones rendered by helm
+	SelfSignedCertificate = "DATREE_SELF_SIGNED_CERTIFICATE"
+	// ValidationTimeoutSeconds the time budget of a single admission request, including its backend calls
+	ValidationTimeoutSeconds = "DATREE_VALIDATION_TIMEOUT_SECONDS"
+	// ResultSpoolDir evaluation results that can't be uploaded right now are written to this directory, they are dropped when not set
+	ResultSpoolDir = "DATREE_RESULT_SPOOL_DIR"
+	// AuditEnabled when "true", the leader periodically evaluates the resources that are already in the cluster
+	AuditEnabled = "DATREE_AUDIT_ENABLED"
+	// AuditIntervalHours how often the audit scan runs
+	AuditIntervalHours = "DATREE_AUDIT_INTERVAL_HOURS"
+	// AuditResources the comma separated resources the audit scan lists, as group/version/resource, or version/resource for the core group
+	AuditResources = "DATREE_AUDIT_RESOURCES"
+	// AuditEvaluationsPerSecond the rate the audit scan evaluates resources at
+	AuditEvaluationsPerSecond = "DATREE_AUDIT_EVALUATIONS_PER_SECOND"
+	// PolicyExceptionsEnabled when "true", the DatreePolicyException resources are watched and applied
+	PolicyExceptionsEnabled = "DATREE_POLICY_EXCEPTIONS_ENABLED"
 )
 
 type ActionOnFailure string
 
 const (
+	// EnforceActionOnFailure blocks resources that fail the policy check
 	EnforceActionOnFailure ActionOnFailure = "enforce"
+	// MonitorActionOnFailure allows resources that fail the policy check, warnings are shown according to the enabled warnings
 	MonitorActionOnFailure ActionOnFailure = "monitor"
+	// WarnActionOnFailure allows resources that fail the policy check, but always warns about the failure
+	WarnActionOnFailure ActionOnFailure = "warn"
+)
+
+// IsValid returns true for the known actions
+func (a ActionOnFailure) IsValid() bool {
+	switch a {
+	case EnforceActionOnFailure, MonitorActionOnFailure, WarnActionOnFailure:
+		return true
+	}
+	return false
+}
+
+type FailureMode string
+
+const (
+	// FailOpen allows the resource when the policy check fails to run, e.g. when the backend is unavailable
+	FailOpen FailureMode = "open"
+	// FailClosed denies the resource when the policy check fails to run
+	FailClosed FailureMode = "closed"
+)
+
+type ResultSinkType string
+
+const (
+	// DatreeResultSink uploads the results to the Datree backend, the default unless in offline mode
+	DatreeResultSink ResultSinkType = "datree"
+	// FileResultSink appends the results to a json lines file
+	FileResultSink ResultSinkType = "file"
+	// StdoutResultSink writes the results to stdout as json lines
+	StdoutResultSink ResultSinkType = "stdout"
+	// WebhookResultSink posts every result to an HTTP endpoint
+	WebhookResultSink ResultSinkType = "webhook"
+	// PolicyReportResultSink keeps a wg-policy PolicyReport, or ClusterPolicyReport, up to date for every evaluated resource
+	PolicyReportResultSink ResultSinkType = "policyReport"
 )
@@ -35,6 +35,11 @@ func (reporter *ErrorReporter) ReportUnexpectedError(unexpectedError error) {
 }
 
 func (reporter *ErrorReporter) ReportError(error interface{}, uri string) {
+	// in offline mode errors are only logged, nothing is sent to the backend
+	if reporter.state.GetIsOfflineMode() {
+		return
+	}
+
 	errorMessage := utils.ParseErrorToString(error)
 	statusCode, err := reporter.client.ReportError(clients.ReportErrorRequest{
 		ClientId:       reporter.state.GetClientId(),
@@ -0,0 +1,125 @@
+package eventRecorder
+
+import (
+	"fmt"
+	"strings"
+
+	admission "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ReasonPolicyCheckDenied the resource failed a policy in enforce mode and was blocked
+	ReasonPolicyCheckDenied = "PolicyCheckDenied"
+	// ReasonPolicyCheckFailed the resource failed a policy but was allowed, by the policy action or by bypass permissions
+	ReasonPolicyCheckFailed = "PolicyCheckFailed"
+
+	eventSourceComponent = "datree-webhook"
+	// the maximal event message the events API accepts from the newer events clients, kept for the core events as well
+	maxMessageLength = 1024
+)
+
+// EventRecorder records a Kubernetes Event for every policy failure, so failures are visible to whoever watches
+// the cluster events and not only as kubectl warnings, which CI pipelines and GitOps controllers never show.
+// similar events are aggregated and each object is rate limited by the client-go event correlator,
+// so a controller that keeps applying the same failing resource doesn't flood the events API
+type EventRecorder struct {
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+}
+
+type PolicyFailure struct {
+	Request         *admission.AdmissionRequest
+	ResourceName    string
+	ResourceUid     k8sTypes.UID
+	PolicyName      string
+	FailedRuleNames []string
+	// EvaluationUrl the link to the full report, empty in offline mode
+	EvaluationUrl string
+	IsDenied      bool
+}
+
+func New(clientSet kubernetes.Interface) *EventRecorder {
+	// the default correlator options aggregate similar events after 10 events in 10 minutes, and allow a burst
+	// of 25 events per object, refilled at one event every 5 minutes
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+
+	return &EventRecorder{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventSourceComponent}),
+	}
+}
+
+// RecordPolicyFailure events are sent asynchronously, it never blocks the admission request. a nil EventRecorder records nothing.
+// dry-run requests aren't recorded, the webhook is registered with sideEffects: None
+func (r *EventRecorder) RecordPolicyFailure(policyFailure PolicyFailure) {
+	if r == nil {
+		return
+	}
+	if dryRun := policyFailure.Request.DryRun; dryRun != nil && *dryRun {
+		return
+	}
+
+	reason := ReasonPolicyCheckFailed
+	if policyFailure.IsDenied {
+		reason = ReasonPolicyCheckDenied
+	}
+	r.recorder.Event(getInvolvedObject(policyFailure), v1.EventTypeWarning, reason, getPolicyFailureMessage(policyFailure))
+}
+
+// Shutdown stops sending the recorded events, events that were not sent yet are dropped
+func (r *EventRecorder) Shutdown() {
+	if r == nil {
+		return
+	}
+	r.broadcaster.Shutdown()
+}
+
+// getInvolvedObject an object that is being created doesn't exist yet, and never will when it is denied,
+// so the event is attached to its namespace instead, where it is still found by `kubectl get events -n <namespace>`
+func getInvolvedObject(policyFailure PolicyFailure) *v1.ObjectReference {
+	request := policyFailure.Request
+	if policyFailure.ResourceUid == "" && request.Namespace != "" {
+		return &v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Name:       request.Namespace,
+			// events must be created in the namespace of the involved object
+			Namespace: request.Namespace,
+		}
+	}
+
+	return &v1.ObjectReference{
+		APIVersion: schema.GroupVersion{Group: request.Kind.Group, Version: request.Kind.Version}.String(),
+		Kind:       request.Kind.Kind,
+		Name:       policyFailure.ResourceName,
+		Namespace:  request.Namespace,
+		UID:        policyFailure.ResourceUid,
+	}
+}
+
+func getPolicyFailureMessage(policyFailure PolicyFailure) string {
+	verb := "failed"
+	if policyFailure.IsDenied {
+		verb = "was denied by"
+	}
+
+	message := fmt.Sprintf("%s \"%s\" %s policy \"%s\"", policyFailure.Request.Kind.Kind, policyFailure.ResourceName, verb, policyFailure.PolicyName)
+	if policyFailure.EvaluationUrl != "" {
+		message = fmt.Sprintf("%s, full report: %s", message, policyFailure.EvaluationUrl)
+	}
+	// the rules are last, the message is truncated when too many rules failed
+	message = fmt.Sprintf("%s, failed rules: %s", message, strings.Join(policyFailure.FailedRuleNames, ", "))
+
+	if len(message) > maxMessageLength {
+		message = message[:maxMessageLength-3] + "..."
+	}
+	return message
+}
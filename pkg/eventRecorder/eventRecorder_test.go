@@ -0,0 +1,117 @@
+package eventRecorder
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admission "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func mockPolicyFailure() PolicyFailure {
+	return PolicyFailure{
+		Request: &admission.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			Namespace: "my-namespace",
+			Name:      "my-deployment",
+			Operation: admission.Create,
+		},
+		ResourceName:    "my-deployment",
+		PolicyName:      "Default",
+		FailedRuleNames: []string{"Ensure each container has a configured memory limit"},
+		EvaluationUrl:   "https://app.datree.io/cli/invocations/1?webhook=true",
+		IsDenied:        true,
+	}
+}
+
+func waitForEvents(t *testing.T, clientSet *fake.Clientset, namespace string) []v1.Event {
+	var events []v1.Event
+	assert.Eventually(t, func() bool {
+		eventList, err := clientSet.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		events = eventList.Items
+		return len(events) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	return events
+}
+
+func TestRecordPolicyFailure(t *testing.T) {
+	t.Run("should attach the event to the namespace of an object that is being created", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		eventRecorder := New(clientSet)
+		defer eventRecorder.Shutdown()
+
+		eventRecorder.RecordPolicyFailure(mockPolicyFailure())
+
+		events := waitForEvents(t, clientSet, "my-namespace")
+		assert.Len(t, events, 1)
+		assert.Equal(t, "Namespace", events[0].InvolvedObject.Kind)
+		assert.Equal(t, "my-namespace", events[0].InvolvedObject.Name)
+		assert.Equal(t, v1.EventTypeWarning, events[0].Type)
+		assert.Equal(t, ReasonPolicyCheckDenied, events[0].Reason)
+		assert.Equal(t, `Deployment "my-deployment" was denied by policy "Default", full report: https://app.datree.io/cli/invocations/1?webhook=true, failed rules: Ensure each container has a configured memory limit`, events[0].Message)
+	})
+
+	t.Run("should attach the event to an existing object", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		eventRecorder := New(clientSet)
+		defer eventRecorder.Shutdown()
+
+		policyFailure := mockPolicyFailure()
+		policyFailure.Request.Operation = admission.Update
+		policyFailure.ResourceUid = "my-deployment-uid"
+		policyFailure.IsDenied = false
+		eventRecorder.RecordPolicyFailure(policyFailure)
+
+		events := waitForEvents(t, clientSet, "my-namespace")
+		assert.Equal(t, v1.ObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       "my-deployment",
+			Namespace:  "my-namespace",
+			UID:        "my-deployment-uid",
+		}, events[0].InvolvedObject)
+		assert.Equal(t, ReasonPolicyCheckFailed, events[0].Reason)
+	})
+
+	t.Run("should not record a dry-run request", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		eventRecorder := New(clientSet)
+		defer eventRecorder.Shutdown()
+
+		dryRun := true
+		dryRunFailure := mockPolicyFailure()
+		dryRunFailure.Request.DryRun = &dryRun
+		dryRunFailure.Request.Namespace = "dry-run-namespace"
+		eventRecorder.RecordPolicyFailure(dryRunFailure)
+		// recorded after the dry-run failure, the events are sent in order
+		eventRecorder.RecordPolicyFailure(mockPolicyFailure())
+
+		waitForEvents(t, clientSet, "my-namespace")
+		dryRunEvents, err := clientSet.CoreV1().Events("dry-run-namespace").List(context.Background(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, dryRunEvents.Items)
+	})
+
+	t.Run("a nil event recorder should record nothing", func(t *testing.T) {
+		var eventRecorder *EventRecorder
+		eventRecorder.RecordPolicyFailure(mockPolicyFailure())
+		eventRecorder.Shutdown()
+	})
+}
+
+func TestGetPolicyFailureMessage(t *testing.T) {
+	policyFailure := mockPolicyFailure()
+	for i := 0; i < 100; i++ {
+		policyFailure.FailedRuleNames = append(policyFailure.FailedRuleNames, "Ensure each container has a configured liveness probe")
+	}
+
+	message := getPolicyFailureMessage(policyFailure)
+	assert.Len(t, message, maxMessageLength)
+	assert.True(t, strings.Contains(message, policyFailure.EvaluationUrl))
+}
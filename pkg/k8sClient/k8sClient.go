@@ -1,7 +1,9 @@
 package k8sClient
 
 import (
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 )
 
@@ -18,3 +20,21 @@ func NewK8sClient() (*kubernetes.Clientset, error) {
 	}
 	return clientset, nil
 }
+
+// NewDynamicClient is used for the custom resources, which the clientset doesn't support
+func NewDynamicClient() (dynamic.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
+
+// NewMetadataClient lists any kind of resource, without the content of the resources
+func NewMetadataClient() (metadata.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return metadata.NewForConfig(config)
+}
@@ -29,6 +29,7 @@ type K8sMetadataUtil struct {
 	CreateClientSetError error
 	leaderElection       *leaderElection.LeaderElection
 	internalLogger       logger.Logger
+	cronJob              *cron.Cron
 }
 
 type K8sMetadata struct {
@@ -59,6 +60,10 @@ func NewK8sMetadataUtil(clientset *kubernetes.Clientset, createClientSetError er
 }
 
 func (k8sMetadataUtil *K8sMetadataUtil) InitK8sMetadataUtil(state *servicestate.ServiceState) {
+	if state.GetIsOfflineMode() {
+		return
+	}
+
 	validator := networkValidator.NewNetworkValidator()
 	cliClient := cliClient.NewCliServiceClient(deploymentConfig.URL, validator, state)
 
@@ -105,6 +110,7 @@ func (k8sMetadataUtil *K8sMetadataUtil) InitK8sMetadataUtil(state *servicestate.
 	k8sMetadataUtil.sendK8sMetadata(cliClient, k8sMetadataOnInit)
 
 	cornJob := cron.New(cron.WithLocation(time.UTC))
+	k8sMetadataUtil.cronJob = cornJob
 
 	_, err = cornJob.AddFunc("@hourly", func() {
 		if k8sMetadataUtil.leaderElection.IsLeader() {
@@ -127,6 +133,16 @@ func (k8sMetadataUtil *K8sMetadataUtil) InitK8sMetadataUtil(state *servicestate.
 	cornJob.Start()
 }
 
+// StopCronJob stops reporting the cluster metadata, the returned context is done once a running report is finished
+func (k8sMetadataUtil *K8sMetadataUtil) StopCronJob() context.Context {
+	if k8sMetadataUtil.cronJob == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+	return k8sMetadataUtil.cronJob.Stop()
+}
+
 func getNodesCount(clientset kubernetes.Interface) (int, *v1.NodeList, error) {
 	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
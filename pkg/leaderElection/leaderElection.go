@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/enums"
@@ -23,6 +21,9 @@ type LeaderElection struct {
 	k8sClientLeaseGetter *v1.LeasesGetter
 	logger               logger.Logger
 	isLeader             bool
+	// cancel stops the leader election and releases the lease, done is closed once it is released
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 func New(k8sClientLeaseGetter *v1.LeasesGetter, internalLogger logger.Logger) *LeaderElection {
@@ -34,15 +35,21 @@ func New(k8sClientLeaseGetter *v1.LeasesGetter, internalLogger logger.Logger) *L
 			isLeader:             true,
 		}
 	} else {
+		ctx, cancel := context.WithCancel(context.Background())
 		le := &LeaderElection{
 			k8sClientLeaseGetter: k8sClientLeaseGetter,
 			logger:               internalLogger,
 			isLeader:             false,
+			cancel:               cancel,
+			done:                 make(chan struct{}),
 		}
 		// le.listenForChangesInLeader is a blocking function call, therefore we run it in a goroutine
 		// we also wait for the first leader election to be done, before returning the leaderElection object, with a 5000ms timeout
 		hasSucceededFirstLeaderElectionChannel := make(chan bool)
-		go le.listenForChangesInLeader(hasSucceededFirstLeaderElectionChannel)
+		go func() {
+			defer close(le.done)
+			le.listenForChangesInLeader(ctx, hasSucceededFirstLeaderElectionChannel)
+		}()
 		go func() {
 			time.Sleep(5000 * time.Millisecond)
 			hasSucceededFirstLeaderElectionChannel <- false
@@ -60,7 +67,23 @@ func (le *LeaderElection) IsLeader() bool {
 	return le.isLeader
 }
 
-func (le *LeaderElection) listenForChangesInLeader(hasSucceededFirstLeaderElectionChannel chan bool) {
+// Stop releases the lease so another replica can take over right away instead of waiting for it to expire,
+// it is called on shutdown after the leader-only jobs were stopped
+func (le *LeaderElection) Stop(ctx context.Context) error {
+	if le.cancel == nil {
+		return nil
+	}
+	le.cancel()
+
+	select {
+	case <-le.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (le *LeaderElection) listenForChangesInLeader(ctx context.Context, hasSucceededFirstLeaderElectionChannel chan bool) {
 	uniquePodName := os.Getenv(enums.PodName)
 	if uniquePodName == "" {
 		hasSucceededFirstLeaderElectionChannel <- false
@@ -74,17 +97,6 @@ func (le *LeaderElection) listenForChangesInLeader(hasSucceededFirstLeaderElecti
 		return
 	}
 
-	// call cancel() on terminations, to release the leader lock
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-ch
-		le.logger.LogError("Received termination, signaling shutdown")
-		cancel()
-	}()
-
 	// create the leader election config
 	lock := &resourcelock.LeaseLock{
 		LeaseMeta: metav1.ObjectMeta{
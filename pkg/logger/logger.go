@@ -19,7 +19,6 @@ import (
 // Logger - instructions to get the logs are under /guides/developer-guide.md
 type Logger struct {
 	zapLogger     *zap.Logger
-	requestId     string
 	errorReporter *errorReporter.ErrorReporter
 }
 
@@ -38,32 +37,53 @@ func New(logLevel zapcore.Level, errorReporter *errorReporter.ErrorReporter) Log
 	}
 }
 
-func (l *Logger) SetRequestId(requestId string) {
-	l.requestId = requestId
+// WithRequestId returns a child logger for a single request, every line it logs carries the request id.
+// the parent logger is shared by concurrent requests and is never modified
+func (l *Logger) WithRequestId(requestId string) *Logger {
+	return &Logger{
+		zapLogger:     l.zapLogger.With(zap.String("requestId", requestId)),
+		errorReporter: l.errorReporter,
+	}
+}
+
+// WithAdmissionRequest returns a child logger whose lines also carry the details of the admission request,
+// so all the lines logged for one admission can be correlated
+func (l *Logger) WithAdmissionRequest(request *admission.AdmissionRequest) *Logger {
+	return &Logger{
+		zapLogger: l.zapLogger.With(
+			zap.String("admissionUid", string(request.UID)),
+			zap.String("kind", request.Kind.Kind),
+			zap.String("namespace", request.Namespace),
+			zap.String("name", request.Name),
+			zap.String("operation", string(request.Operation)),
+			zap.String("user", request.UserInfo.Username),
+		),
+		errorReporter: l.errorReporter,
+	}
 }
 
 func (l *Logger) LogDebug(message string, data ...any) {
-	l.zapLogger.Debug(message, zap.String("requestId", l.requestId), zap.Any("data", data))
+	l.zapLogger.Debug(message, zap.Any("data", data))
 }
 
 func (l *Logger) LogInfo(message string, data ...any) {
-	l.zapLogger.Info(message, zap.String("requestId", l.requestId), zap.Any("data", data))
+	l.zapLogger.Info(message, zap.Any("data", data))
 }
 
 func (l *Logger) LogWarn(message string, data ...any) {
-	l.zapLogger.Warn(message, zap.String("requestId", l.requestId), zap.Any("data", data))
+	l.zapLogger.Warn(message, zap.Any("data", data))
 }
 
 func (l *Logger) LogError(message string, data ...any) {
-	l.zapLogger.Error(message, zap.String("requestId", l.requestId), zap.Any("data", data))
+	l.zapLogger.Error(message, zap.Any("data", data))
 }
 
 func (l *Logger) Fatal(message string, data ...any) {
-	l.zapLogger.Fatal(message, zap.String("requestId", l.requestId), zap.Any("data", data))
+	l.zapLogger.Fatal(message, zap.Any("data", data))
 }
 
 func (l *Logger) PanicLevel(message string, data ...any) {
-	l.zapLogger.Panic(message, zap.String("requestId", l.requestId), zap.Any("data", data))
+	l.zapLogger.Panic(message, zap.Any("data", data))
 }
 
 func (l *Logger) LogAndReportUnexpectedError(message string) {
@@ -80,7 +100,6 @@ const (
 
 func (l *Logger) LogAdmissionRequest(admissionReview *admission.AdmissionReview, isSkipped bool, direction LogDirection) {
 	logFields := make(map[string]interface{})
-	logFields["requestId"] = l.requestId
 	logFields["requestDirection"] = direction
 	logFields["isSkipped"] = isSkipped
 	logFields["admissionReview"] = admissionReview
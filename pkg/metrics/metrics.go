@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "datree_webhook"
+
+// admission outcomes, used as the outcome label of AdmissionRequestsTotal
+const (
+	OutcomeAllowed  = "allowed"
+	OutcomeDenied   = "denied"
+	OutcomeSkipped  = "skipped"
+	OutcomeBypassed = "bypassed"
+	OutcomeError    = "error"
+)
+
+// Registry holds all the webhook metrics, it is served by Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	// AdmissionRequestsTotal is incremented once per evaluated policy, skipped and failed requests are counted once with an empty policy
+	AdmissionRequestsTotal = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_requests_total",
+		Help:      "Admission requests by outcome, counted once per evaluated policy.",
+	}, []string{"outcome", "kind", "namespace", "policy"})
+
+	// AuditEvaluationsTotal is counted like AdmissionRequestsTotal, for the resources evaluated by the audit scan
+	AuditEvaluationsTotal = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_evaluations_total",
+		Help:      "Resources evaluated by the audit scan by outcome, counted once per evaluated policy. denied is the outcome the resource would have been admitted with.",
+	}, []string{"outcome", "kind", "namespace", "policy"})
+
+	ValidateDurationSeconds = promauto.With(Registry).NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "validate_duration_seconds",
+		Help:      "Total duration of handling a validation admission request.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	PolicyEvaluationDurationSeconds = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "policy_evaluation_duration_seconds",
+		Help:      "Duration of evaluating a resource against a single policy.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"policy"})
+
+	BackendRequestDurationSeconds = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Duration of requests to the Datree backend by call and status code, the status code is 0 when no response was received.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"call", "status_code"})
+
+	BackendRequestRetriesTotal = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_request_retries_total",
+		Help:      "Requests to the Datree backend that were retried after a failed attempt, by call.",
+	}, []string{"call"})
+
+	BackendRequestsShortCircuitedTotal = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_requests_short_circuited_total",
+		Help:      "Requests to the Datree backend that weren't sent since the circuit breaker was open, by call.",
+	}, []string{"call"})
+
+	BackendCircuitState = promauto.With(Registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backend_circuit_state",
+		Help:      "State of the Datree backend circuit breaker, 0 closed, 1 half-open, 2 open.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+func RecordAdmission(outcome string, kind string, namespace string, policy string) {
+	AdmissionRequestsTotal.WithLabelValues(outcome, kind, namespace, policy).Inc()
+}
+
+func RecordAuditEvaluation(outcome string, kind string, namespace string, policy string) {
+	AuditEvaluationsTotal.WithLabelValues(outcome, kind, namespace, policy).Inc()
+}
+
+func ObserveBackendRequest(call string, statusCode int, startTime time.Time) {
+	BackendRequestDurationSeconds.WithLabelValues(call, strconv.Itoa(statusCode)).Observe(time.Since(startTime).Seconds())
+}
+
+func SetBackendCircuitState(state int) {
+	BackendCircuitState.Set(float64(state))
+}
+
+// RegisterIsLeader exposes whether this replica is the leader, isLeader is called on every scrape
+func RegisterIsLeader(isLeader func() bool) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "is_leader",
+		Help:      "1 when this replica is the leader, 0 otherwise.",
+	}, func() float64 {
+		if isLeader() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// RegisterMetadataAggregatorSize exposes the amount of request metadata entries waiting to be sent to the backend
+func RegisterMetadataAggregatorSize(size func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "metadata_aggregator_entries",
+		Help:      "Request metadata entries aggregated and not yet sent to the backend.",
+	}, func() float64 {
+		return float64(size())
+	}))
+}
+
+// RegisterEvaluationResultQueueSize exposes the amount of evaluation results waiting to be uploaded to the backend
+func RegisterEvaluationResultQueueSize(size func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "evaluation_result_queue_entries",
+		Help:      "Evaluation results queued and not yet uploaded to the backend, not including the spooled results.",
+	}, func() float64 {
+		return float64(size())
+	}))
+}
+
+// RegisterCertificateExpiry exposes the expiry of the TLS certificate being served, notAfter is called on every scrape
+func RegisterCertificateExpiry(notAfter func() time.Time) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tls_certificate_expiry_timestamp_seconds",
+		Help:      "Unix time at which the TLS certificate being served expires.",
+	}, func() float64 {
+		return float64(notAfter().Unix())
+	}))
+}
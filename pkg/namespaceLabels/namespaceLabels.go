@@ -0,0 +1,88 @@
+package namespaceLabels
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// resyncPeriod the informer re-lists the namespaces this often, in case a watch event was missed
+	resyncPeriod = 10 * time.Minute
+	// syncTimeout the namespaces that aren't cached by then are fetched from the API server until they are
+	syncTimeout = 30 * time.Second
+)
+
+// Cache keeps the labels of the cluster namespaces in an informer cache, so the policies scoped by a namespace label
+// selector are resolved without an API call
+type Cache struct {
+	clientSet kubernetes.Interface
+	factory   informers.SharedInformerFactory
+	informer  cache.SharedIndexInformer
+	lister    corev1listers.NamespaceLister
+	stop      chan struct{}
+	logger    *logger.Logger
+}
+
+func New(clientSet kubernetes.Interface, logger *logger.Logger) *Cache {
+	factory := informers.NewSharedInformerFactory(clientSet, resyncPeriod)
+	namespaceInformer := factory.Core().V1().Namespaces()
+	return &Cache{
+		clientSet: clientSet,
+		factory:   factory,
+		informer:  namespaceInformer.Informer(),
+		lister:    namespaceInformer.Lister(),
+		stop:      make(chan struct{}),
+		logger:    logger,
+	}
+}
+
+// Start starts watching the namespaces, and waits until they are cached or until the sync timeout
+func (c *Cache) Start() {
+	c.factory.Start(c.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		c.logger.LogWarn(fmt.Sprintf("the namespaces weren't cached within %s, they are fetched from the API server until they are", syncTimeout))
+	}
+}
+
+// Stop stops watching the namespaces. a nil Cache does nothing
+func (c *Cache) Stop() {
+	if c == nil {
+		return
+	}
+	close(c.stop)
+	c.factory.Shutdown()
+}
+
+// GetLabels returns the labels of the namespace. a namespace that isn't cached yet, e.g. one that was just created,
+// is fetched from the API server. a nil Cache returns an error
+func (c *Cache) GetLabels(ctx context.Context, namespace string) (map[string]string, error) {
+	if c == nil {
+		return nil, fmt.Errorf("the labels of namespace %s are unavailable, the namespaces aren't watched", namespace)
+	}
+
+	cachedNamespace, err := c.lister.Get(namespace)
+	if err == nil {
+		return cachedNamespace.Labels, nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	fetchedNamespace, err := c.clientSet.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fetchedNamespace.Labels, nil
+}
@@ -0,0 +1,58 @@
+package namespaceLabels
+
+import (
+	"context"
+	"testing"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func mockNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestGetLabels(t *testing.T) {
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+
+	t.Run("should return the labels of a cached namespace", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(mockNamespace("payments", map[string]string{"env": "prod", "team": "payments"}))
+		namespaceCache := New(clientSet, &mockLogger)
+		namespaceCache.Start()
+		t.Cleanup(namespaceCache.Stop)
+
+		labels, err := namespaceCache.GetLabels(context.Background(), "payments")
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "prod", "team": "payments"}, labels)
+	})
+
+	t.Run("should fetch a namespace that isn't cached yet", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset(mockNamespace("new-namespace", map[string]string{"env": "dev"}))
+		// the cache isn't started, as if the namespace's watch event didn't arrive yet
+		namespaceCache := New(clientSet, &mockLogger)
+
+		labels, err := namespaceCache.GetLabels(context.Background(), "new-namespace")
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"env": "dev"}, labels)
+	})
+
+	t.Run("should return an error for a missing namespace or a nil cache", func(t *testing.T) {
+		namespaceCache := New(fake.NewSimpleClientset(), &mockLogger)
+		namespaceCache.Start()
+		t.Cleanup(namespaceCache.Stop)
+
+		_, err := namespaceCache.GetLabels(context.Background(), "missing")
+		assert.Error(t, err)
+
+		var nilCache *Cache
+		_, err = nilCache.GetLabels(context.Background(), "payments")
+		assert.Error(t, err)
+		nilCache.Stop()
+	})
+}
@@ -0,0 +1,189 @@
+package policyException
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/strings/slices"
+)
+
+// Resource the DatreePolicyException custom resource, its CRD is installed by the helm chart
+var Resource = schema.GroupVersionResource{Group: "datree.io", Version: "v1alpha1", Resource: "datreepolicyexceptions"}
+
+const (
+	// resyncPeriod the informer re-lists the exceptions this often, in case a watch event was missed
+	resyncPeriod = 10 * time.Minute
+	// syncTimeout the exceptions that aren't cached by then are applied once they are
+	syncTimeout = 30 * time.Second
+)
+
+// DatreePolicyException excepts the matching resources of its namespace from rules until it expires. the review is the
+// RBAC of the DatreePolicyException resource, whoever may create it approves the exception, which is recorded in ApprovedBy
+type DatreePolicyException struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              DatreePolicyExceptionSpec `json:"spec"`
+}
+
+type DatreePolicyExceptionSpec struct {
+	Match Match `json:"match"`
+	// Rules the identifiers of the excepted rules
+	Rules         []string    `json:"rules"`
+	ExpiresAt     metav1.Time `json:"expiresAt"`
+	ApprovedBy    string      `json:"approvedBy"`
+	Justification string      `json:"justification,omitempty"`
+}
+
+// Match selects the resources of the namespace, every set field must match. an empty Match selects all of them
+type Match struct {
+	Kinds []string `json:"kinds,omitempty"`
+	// Names regexes of the resource names
+	Names         []string              `json:"names,omitempty"`
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+}
+
+// MatchedResource the resource the exceptions are looked up for
+type MatchedResource struct {
+	Namespace string
+	Kind      string
+	Name      string
+	Labels    map[string]string
+}
+
+// Store keeps the DatreePolicyExceptions of the cluster in an informer cache, so they are looked up without an API call
+type Store struct {
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   cache.GenericLister
+	stop     chan struct{}
+	logger   *logger.Logger
+}
+
+func NewStore(dynamicClient dynamic.Interface, logger *logger.Logger) *Store {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, resyncPeriod)
+	genericInformer := factory.ForResource(Resource)
+	return &Store{
+		factory:  factory,
+		informer: genericInformer.Informer(),
+		lister:   genericInformer.Lister(),
+		stop:     make(chan struct{}),
+		logger:   logger,
+	}
+}
+
+// Start starts watching the exceptions, and waits until they are cached or until the sync timeout
+func (s *Store) Start() {
+	s.factory.Start(s.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), s.informer.HasSynced) {
+		s.logger.LogWarn(fmt.Sprintf("the policy exceptions weren't cached within %s, they are applied once they are", syncTimeout))
+	}
+}
+
+// Stop stops watching the exceptions. a nil Store does nothing
+func (s *Store) Stop() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	s.factory.Shutdown()
+}
+
+// GetActiveExceptions returns the approved exceptions that match the resource and didn't expire. a nil Store has none
+func (s *Store) GetActiveExceptions(resource MatchedResource, now time.Time) []DatreePolicyException {
+	if s == nil || resource.Namespace == "" {
+		return nil
+	}
+
+	objects, err := s.lister.ByNamespace(resource.Namespace).List(labels.Everything())
+	if err != nil {
+		s.logger.LogWarn(fmt.Sprintf("failed to list the policy exceptions of namespace %s, err: %s", resource.Namespace, err))
+		return nil
+	}
+
+	var exceptions []DatreePolicyException
+	for _, object := range objects {
+		exception, err := fromObject(object)
+		if err != nil {
+			s.logger.LogWarn(fmt.Sprintf("invalid policy exception, err: %s", err))
+			continue
+		}
+		if !exception.IsActive(now) {
+			continue
+		}
+		isMatch, err := exception.Spec.Match.Matches(resource)
+		if err != nil {
+			s.logger.LogWarn(fmt.Sprintf("invalid match of policy exception %s/%s, err: %s", exception.Namespace, exception.Name, err))
+			continue
+		}
+		if isMatch {
+			exceptions = append(exceptions, *exception)
+		}
+	}
+	return exceptions
+}
+
+// IsActive returns true when the exception is approved and didn't expire, an expired exception stops applying without
+// being deleted so it is kept as a record
+func (e *DatreePolicyException) IsActive(now time.Time) bool {
+	return e.Spec.ApprovedBy != "" && now.Before(e.Spec.ExpiresAt.Time)
+}
+
+func (m Match) Matches(resource MatchedResource) (bool, error) {
+	if len(m.Kinds) > 0 && !slices.Contains(m.Kinds, resource.Kind) {
+		return false, nil
+	}
+
+	if len(m.Names) > 0 {
+		isNameMatch := false
+		for _, namePattern := range m.Names {
+			match, err := regexp.MatchString(namePattern, resource.Name)
+			if err != nil {
+				return false, err
+			}
+			if match {
+				isNameMatch = true
+				break
+			}
+		}
+		if !isNameMatch {
+			return false, nil
+		}
+	}
+
+	if m.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(m.LabelSelector)
+		if err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(resource.Labels)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func fromObject(object runtime.Object) (*DatreePolicyException, error) {
+	unstructuredObject, ok := object.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", object)
+	}
+	exception := &DatreePolicyException{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredObject.Object, exception); err != nil {
+		return nil, fmt.Errorf("policy exception %s/%s: %s", unstructuredObject.GetNamespace(), unstructuredObject.GetName(), err)
+	}
+	return exception, nil
+}
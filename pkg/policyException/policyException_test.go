@@ -0,0 +1,97 @@
+package policyException
+
+import (
+	"testing"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+)
+
+var now = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func mockPolicyException(name string, namespace string, match map[string]interface{}, approvedBy string, expiresAt string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "datree.io/v1alpha1",
+		"kind":       "DatreePolicyException",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"match":         match,
+			"rules":         []interface{}{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY"},
+			"expiresAt":     expiresAt,
+			"approvedBy":    approvedBy,
+			"justification": "the limits are tuned in JIRA-123",
+		},
+	}}
+}
+
+func mockStore(t *testing.T, objects ...runtime.Object) *Store {
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	dynamicClient := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		Resource: "DatreePolicyExceptionList",
+	}, objects...)
+
+	store := NewStore(dynamicClient, &mockLogger)
+	store.Start()
+	t.Cleanup(store.Stop)
+	return store
+}
+
+func getExceptionNames(exceptions []DatreePolicyException) []string {
+	var names []string
+	for _, exception := range exceptions {
+		names = append(names, exception.Name)
+	}
+	return names
+}
+
+func TestGetActiveExceptions(t *testing.T) {
+	resource := MatchedResource{Namespace: "my-namespace", Kind: "Deployment", Name: "api-server", Labels: map[string]string{"team": "platform"}}
+
+	t.Run("should return the approved exceptions that match the resource and didn't expire", func(t *testing.T) {
+		store := mockStore(t,
+			mockPolicyException("all", "my-namespace", nil, "compliance-team", "2023-07-01T00:00:00Z"),
+			mockPolicyException("by-kind-and-name", "my-namespace", map[string]interface{}{"kinds": []interface{}{"Deployment"}, "names": []interface{}{"^api-"}}, "compliance-team", "2023-07-01T00:00:00Z"),
+			mockPolicyException("by-labels", "my-namespace", map[string]interface{}{"labelSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"team": "platform"}}}, "compliance-team", "2023-07-01T00:00:00Z"),
+			mockPolicyException("other-kind", "my-namespace", map[string]interface{}{"kinds": []interface{}{"StatefulSet"}}, "compliance-team", "2023-07-01T00:00:00Z"),
+			mockPolicyException("other-name", "my-namespace", map[string]interface{}{"names": []interface{}{"^web-"}}, "compliance-team", "2023-07-01T00:00:00Z"),
+			mockPolicyException("other-labels", "my-namespace", map[string]interface{}{"labelSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"team": "web"}}}, "compliance-team", "2023-07-01T00:00:00Z"),
+			mockPolicyException("other-namespace", "other-namespace", nil, "compliance-team", "2023-07-01T00:00:00Z"),
+			mockPolicyException("expired", "my-namespace", nil, "compliance-team", "2023-05-01T00:00:00Z"),
+			mockPolicyException("not-approved", "my-namespace", nil, "", "2023-07-01T00:00:00Z"),
+		)
+
+		exceptions := store.GetActiveExceptions(resource, now)
+
+		assert.ElementsMatch(t, []string{"all", "by-kind-and-name", "by-labels"}, getExceptionNames(exceptions))
+		for _, exception := range exceptions {
+			assert.Equal(t, []string{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY"}, exception.Spec.Rules)
+			assert.Equal(t, "compliance-team", exception.Spec.ApprovedBy)
+			assert.Equal(t, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), exception.Spec.ExpiresAt.UTC())
+		}
+	})
+
+	t.Run("should stop applying an exception once it expired", func(t *testing.T) {
+		store := mockStore(t, mockPolicyException("all", "my-namespace", nil, "compliance-team", "2023-07-01T00:00:00Z"))
+
+		assert.Len(t, store.GetActiveExceptions(resource, now), 1)
+		assert.Empty(t, store.GetActiveExceptions(resource, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("should skip an exception with an invalid match", func(t *testing.T) {
+		store := mockStore(t, mockPolicyException("invalid-name", "my-namespace", map[string]interface{}{"names": []interface{}{"("}}, "compliance-team", "2023-07-01T00:00:00Z"))
+
+		assert.Empty(t, store.GetActiveExceptions(resource, now))
+	})
+
+	t.Run("should have no exceptions when the store is nil", func(t *testing.T) {
+		var store *Store
+		assert.Empty(t, store.GetActiveExceptions(resource, now))
+		store.Stop()
+	})
+}
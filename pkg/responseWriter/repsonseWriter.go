@@ -37,3 +37,7 @@ func (rw ResponseWriter) NotAllowed(content string) {
 func (rw ResponseWriter) BadRequest(content string) {
 	http.Error(rw.httpWriter, content, http.StatusBadRequest)
 }
+
+func (rw ResponseWriter) ServiceUnavailable(content string) {
+	http.Error(rw.httpWriter, content, http.StatusServiceUnavailable)
+}
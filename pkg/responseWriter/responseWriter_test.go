@@ -41,6 +41,17 @@ func TestBadRequest(t *testing.T) {
 	assert.Equal(t, strings.TrimSpace(responseRecorder.Body.String()), responseBodyErr)
 }
 
+func TestServiceUnavailable(t *testing.T) {
+	responseRecorder := httptest.NewRecorder()
+	responseWriter := New(responseRecorder)
+
+	responseBodyErr := "Service unavailable"
+	responseWriter.ServiceUnavailable(responseBodyErr)
+
+	assert.Equal(t, responseRecorder.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, strings.TrimSpace(responseRecorder.Body.String()), responseBodyErr)
+}
+
 func TestWriteBody(t *testing.T) {
 	type TestObject struct {
 		UID string
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateReloader serves the TLS certificate through tls.Config.GetCertificate, so a rotated certificate,
+// e.g. renewed by cert-manager, is used by new connections without restarting the pod
+type CertificateReloader struct {
+	certPath string
+	keyPath  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	notAfter    time.Time
+}
+
+// NewCertificateReloader returns an error when the initial key pair can't be loaded
+func NewCertificateReloader(certPath string, keyPath string) (*CertificateReloader, error) {
+	certificateReloader := &CertificateReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := certificateReloader.Reload(); err != nil {
+		return nil, err
+	}
+	return certificateReloader, nil
+}
+
+// NewCertificateReloaderFromPEM serves a key pair that isn't read from files, it is replaced by calling SetKeyPair
+func NewCertificateReloaderFromPEM(certPEM []byte, keyPEM []byte) (*CertificateReloader, error) {
+	certificateReloader := &CertificateReloader{}
+	if err := certificateReloader.SetKeyPair(certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	return certificateReloader, nil
+}
+
+// Reload loads the key pair from the files, the current certificate keeps being served when it fails
+func (r *CertificateReloader) Reload() error {
+	certPEM, err := os.ReadFile(r.certPath)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(r.keyPath)
+	if err != nil {
+		return err
+	}
+	return r.SetKeyPair(certPEM, keyPEM)
+}
+
+// SetKeyPair the current certificate keeps being served when the key pair is invalid
+func (r *CertificateReloader) SetKeyPair(certPEM []byte, keyPEM []byte) error {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return err
+	}
+	certificate.Leaf = leaf
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificate = &certificate
+	r.notAfter = leaf.NotAfter
+	return nil
+}
+
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.certificate, nil
+}
+
+// NotAfter returns the expiry of the certificate being served
+func (r *CertificateReloader) NotAfter() time.Time {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.notAfter
+}
+
+func (r *CertificateReloader) IsExpired() bool {
+	return time.Now().After(r.NotAfter())
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSelfSignedCertificate(t *testing.T, dir string, notAfter time.Time) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "datree-webhook-server.datree.svc"},
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	certificateDer, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	assert.NoError(t, err)
+	privateKeyDer, err := x509.MarshalECPrivateKey(privateKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDer}), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKeyDer}), 0600))
+}
+
+func TestCertificateReloader(t *testing.T) {
+	tlsDir := t.TempDir()
+	certPath, keyPath := filepath.Join(tlsDir, "tls.crt"), filepath.Join(tlsDir, "tls.key")
+
+	t.Run("should return an error when the key pair doesn't exist", func(t *testing.T) {
+		_, err := NewCertificateReloader(certPath, keyPath)
+		assert.Error(t, err)
+	})
+
+	expiredAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	writeSelfSignedCertificate(t, tlsDir, expiredAt)
+	certificateReloader, err := NewCertificateReloader(certPath, keyPath)
+	assert.NoError(t, err)
+
+	t.Run("should serve the loaded certificate", func(t *testing.T) {
+		certificate, err := certificateReloader.GetCertificate(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expiredAt.UTC(), certificate.Leaf.NotAfter)
+		assert.True(t, certificateReloader.IsExpired())
+	})
+
+	t.Run("should serve the rotated certificate after a reload", func(t *testing.T) {
+		renewedExpiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		writeSelfSignedCertificate(t, tlsDir, renewedExpiry)
+
+		assert.NoError(t, certificateReloader.Reload())
+		assert.Equal(t, renewedExpiry.UTC(), certificateReloader.NotAfter().UTC())
+		assert.False(t, certificateReloader.IsExpired())
+	})
+
+	t.Run("should keep serving the current certificate when the reload fails", func(t *testing.T) {
+		notAfter := certificateReloader.NotAfter()
+		assert.NoError(t, os.WriteFile(keyPath, []byte("invalid"), 0600))
+
+		assert.Error(t, certificateReloader.Reload())
+		certificate, err := certificateReloader.GetCertificate(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, notAfter, certificate.Leaf.NotAfter)
+	})
+}
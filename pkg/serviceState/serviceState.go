@@ -1,11 +1,16 @@
 package servicestate
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"go.uber.org/zap/zapcore"
@@ -13,47 +18,81 @@ import (
 	"github.com/datreeio/admission-webhook-datree/pkg/config"
 	"github.com/datreeio/admission-webhook-datree/pkg/enums"
 	"github.com/lithammer/shortuuid"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/strings/slices"
 )
 
 var DATREE_CONFIG_FILE_DIR = `/config`
 
 type ServiceState struct {
-	clientId          string
-	token             string
-	clusterUuid       types.UID
-	clusterName       string
-	k8sVersion        string
-	configFromHelm    bool
-	policyName        string // strictly represents the policy name from the values.yaml file, we don't actually use it. We use the policies from prerunResponse.activePolicies for evaluation
-	multiplePolicies  *MultiplePolicies
-	isEnforceMode     bool
-	serviceVersion    string
-	noRecord          string
-	output            string
-	verbose           string
-	bypassPermissions *BypassPermissions
-	enabledWarnings   string
-	LogLevel          zapcore.Level
+	clientId           string
+	token              string
+	clusterUuid        types.UID
+	clusterName        string
+	k8sVersion         string
+	configFromHelm     bool
+	policyName         string // strictly represents the policy name from the values.yaml file, we don't actually use it. We use the policies from prerunResponse.activePolicies for evaluation
+	config             atomic.Pointer[Config]
+	serviceVersion     string
+	noRecord           string
+	output             string
+	verbose            string
+	enabledWarnings    string
+	offlinePoliciesDir string
+	offlineResultsFile string
+	prerunCacheTTL     time.Duration
+	kubernetesEvents   bool
+	selfSignedCert     bool
+	validationTimeout  time.Duration
+	resultSpoolDir     string
+	resultSinks        []ResultSinkConfig
+	audit              AuditConfig
+	policyExceptions   bool
+	// prerunSkipList the compiled ignore patterns of the prerun data
+	prerunSkipList atomic.Pointer[prerunSkipList]
+	// prerunDataError why the last fetched prerun data was rejected, nil when it was accepted
+	prerunDataError atomic.Pointer[string]
+	LogLevel        zapcore.Level
 }
 
 func New() *ServiceState {
-	return &ServiceState{
-		clientId:          shortuuid.New(),
-		token:             os.Getenv(enums.Token),
-		clusterName:       os.Getenv(enums.ClusterName),
-		configFromHelm:    os.Getenv(enums.ConfigFromHelm) != "false",
-		policyName:        os.Getenv(enums.Policy),
-		multiplePolicies:  readMultiplePolicies(),
-		isEnforceMode:     os.Getenv(enums.Enforce) == "true",
-		serviceVersion:    config.WebhookVersion,
-		noRecord:          os.Getenv(enums.NoRecord),
-		output:            os.Getenv(enums.Output),
-		verbose:           os.Getenv(enums.Verbose),
-		bypassPermissions: readBypassPermissions(),
-		enabledWarnings:   os.Getenv(enums.EnabledWarnings),
-		LogLevel:          readLogLevel(),
+	s := &ServiceState{
+		clientId:           shortuuid.New(),
+		token:              os.Getenv(enums.Token),
+		clusterName:        os.Getenv(enums.ClusterName),
+		configFromHelm:     os.Getenv(enums.ConfigFromHelm) != "false",
+		policyName:         os.Getenv(enums.Policy),
+		serviceVersion:     config.WebhookVersion,
+		noRecord:           os.Getenv(enums.NoRecord),
+		output:             os.Getenv(enums.Output),
+		verbose:            os.Getenv(enums.Verbose),
+		enabledWarnings:    os.Getenv(enums.EnabledWarnings),
+		offlinePoliciesDir: os.Getenv(enums.OfflinePoliciesDir),
+		offlineResultsFile: os.Getenv(enums.OfflineResultsFile),
+		prerunCacheTTL:     readPrerunCacheTTL(),
+		kubernetesEvents:   os.Getenv(enums.KubernetesEvents) != "false",
+		selfSignedCert:     os.Getenv(enums.SelfSignedCertificate) == "true",
+		validationTimeout:  readValidationTimeout(),
+		resultSpoolDir:     os.Getenv(enums.ResultSpoolDir),
+		audit:              readAudit(),
+		policyExceptions:   os.Getenv(enums.PolicyExceptionsEnabled) == "true",
+		LogLevel:           readLogLevel(),
 	}
+	s.resultSinks = readResultSinks(s.GetIsOfflineMode(), s.offlineResultsFile)
+	s.config.Store(&Config{
+		IsEnforceMode:     os.Getenv(enums.Enforce) == "true",
+		MultiplePolicies:  readMultiplePolicies(),
+		BypassPermissions: readBypassPermissions(),
+		AutoFix:           readAutoFix(),
+		FailurePolicy:     readFailurePolicy(),
+		NewViolationsOnly: readNewViolationsOnly(),
+		RuleExceptions:    readRuleExceptions(),
+		SkipList:          readSkipList(),
+	})
+	return s
 }
 
 func readLogLevel() zapcore.Level {
@@ -75,6 +114,123 @@ func readLogLevel() zapcore.Level {
 	return logLevel
 }
 
+const defaultPrerunCacheTTL = 30 * time.Second
+
+func readPrerunCacheTTL() time.Duration {
+	rawPrerunCacheTTLSeconds := os.Getenv(enums.PrerunCacheTTLSeconds)
+	if rawPrerunCacheTTLSeconds == "" {
+		return defaultPrerunCacheTTL
+	}
+
+	prerunCacheTTLSeconds, err := strconv.Atoi(rawPrerunCacheTTLSeconds)
+	if err != nil || prerunCacheTTLSeconds < 0 {
+		fmt.Println(fmt.Errorf("invalid %s value %q, using the default of %s", enums.PrerunCacheTTLSeconds, rawPrerunCacheTTLSeconds, defaultPrerunCacheTTL))
+		return defaultPrerunCacheTTL
+	}
+
+	return time.Duration(prerunCacheTTLSeconds) * time.Second
+}
+
+// defaultValidationTimeout is kept below the timeoutSeconds of 30 seconds in the webhook configurations
+const defaultValidationTimeout = 25 * time.Second
+
+func readValidationTimeout() time.Duration {
+	rawValidationTimeoutSeconds := os.Getenv(enums.ValidationTimeoutSeconds)
+	if rawValidationTimeoutSeconds == "" {
+		return defaultValidationTimeout
+	}
+
+	validationTimeoutSeconds, err := strconv.Atoi(rawValidationTimeoutSeconds)
+	if err != nil || validationTimeoutSeconds <= 0 {
+		fmt.Println(fmt.Errorf("invalid %s value %q, using the default of %s", enums.ValidationTimeoutSeconds, rawValidationTimeoutSeconds, defaultValidationTimeout))
+		return defaultValidationTimeout
+	}
+
+	return time.Duration(validationTimeoutSeconds) * time.Second
+}
+
+// AuditConfig the audit scan evaluates the resources already in the cluster, see services.AuditScanner
+type AuditConfig struct {
+	Enabled              bool
+	Interval             time.Duration
+	Resources            []schema.GroupVersionResource
+	EvaluationsPerSecond float64
+}
+
+const (
+	defaultAuditInterval             = time.Hour
+	defaultAuditEvaluationsPerSecond = 5
+)
+
+// defaultAuditResources the workloads and the other kinds the default policies have rules for
+var defaultAuditResources = []schema.GroupVersionResource{
+	{Group: "apps", Version: "v1", Resource: "deployments"},
+	{Group: "apps", Version: "v1", Resource: "statefulsets"},
+	{Group: "apps", Version: "v1", Resource: "daemonsets"},
+	{Group: "batch", Version: "v1", Resource: "cronjobs"},
+	{Group: "batch", Version: "v1", Resource: "jobs"},
+	{Version: "v1", Resource: "pods"},
+	{Version: "v1", Resource: "services"},
+	{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+}
+
+func readAudit() AuditConfig {
+	audit := AuditConfig{
+		Enabled:              os.Getenv(enums.AuditEnabled) == "true",
+		Interval:             defaultAuditInterval,
+		Resources:            defaultAuditResources,
+		EvaluationsPerSecond: defaultAuditEvaluationsPerSecond,
+	}
+
+	if rawIntervalHours := os.Getenv(enums.AuditIntervalHours); rawIntervalHours != "" {
+		intervalHours, err := strconv.ParseFloat(rawIntervalHours, 64)
+		if err != nil || intervalHours <= 0 {
+			fmt.Println(fmt.Errorf("invalid %s value %q, using the default of %s", enums.AuditIntervalHours, rawIntervalHours, defaultAuditInterval))
+		} else {
+			audit.Interval = time.Duration(intervalHours * float64(time.Hour))
+		}
+	}
+
+	if rawEvaluationsPerSecond := os.Getenv(enums.AuditEvaluationsPerSecond); rawEvaluationsPerSecond != "" {
+		evaluationsPerSecond, err := strconv.ParseFloat(rawEvaluationsPerSecond, 64)
+		if err != nil || evaluationsPerSecond <= 0 {
+			fmt.Println(fmt.Errorf("invalid %s value %q, using the default of %d", enums.AuditEvaluationsPerSecond, rawEvaluationsPerSecond, defaultAuditEvaluationsPerSecond))
+		} else {
+			audit.EvaluationsPerSecond = evaluationsPerSecond
+		}
+	}
+
+	if rawResources := os.Getenv(enums.AuditResources); rawResources != "" {
+		var resources []schema.GroupVersionResource
+		for _, rawResource := range strings.Split(rawResources, ",") {
+			resource, err := parseAuditResource(strings.TrimSpace(rawResource))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			resources = append(resources, resource)
+		}
+		if len(resources) > 0 {
+			audit.Resources = resources
+		}
+	}
+
+	return audit
+}
+
+// parseAuditResource parses group/version/resource, or version/resource for the core group
+func parseAuditResource(rawResource string) (schema.GroupVersionResource, error) {
+	parts := strings.Split(rawResource, "/")
+	switch {
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}, nil
+	case len(parts) == 3 && parts[0] != "" && parts[1] != "" && parts[2] != "":
+		return schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}, nil
+	default:
+		return schema.GroupVersionResource{}, fmt.Errorf("invalid %s resource %q, expected group/version/resource", enums.AuditResources, rawResource)
+	}
+}
+
 func (s *ServiceState) SetClusterUuid(clusterUuid types.UID) {
 	s.clusterUuid = clusterUuid
 }
@@ -111,19 +267,15 @@ func (s *ServiceState) GetPolicyName() string {
 	return s.policyName
 }
 
+// GetIsEnforceMode requests should use the IsEnforceMode of the config snapshot they read with GetConfig instead
 func (s *ServiceState) GetIsEnforceMode() bool {
-	return s.isEnforceMode
+	return s.GetConfig().IsEnforceMode
 }
 
 func (s *ServiceState) GetLogLevel() zapcore.Level {
 	return s.LogLevel
 }
 
-// SetIsEnforceMode to override when we get cluster config in /prerun
-func (s *ServiceState) SetIsEnforceMode(isEnforceMode bool) {
-	s.isEnforceMode = isEnforceMode
-}
-
 func (s *ServiceState) GetServiceVersion() string {
 	return s.serviceVersion
 }
@@ -140,16 +292,103 @@ func (s *ServiceState) GetVerbose() string {
 	return s.verbose
 }
 
-func (s *ServiceState) GetMultiplePolicies() *MultiplePolicies {
-	return s.multiplePolicies
+// Config is the part of the state that changes while the webhook is running, by a reload of the config files or by the
+// dashboard configuration in the prerun data. a stored Config is never modified, a request reads one snapshot with GetConfig
+// and uses it throughout, so it never sees a mix of two configurations
+type Config struct {
+	IsEnforceMode     bool
+	MultiplePolicies  *MultiplePolicies
+	BypassPermissions *BypassPermissions
+	AutoFix           *AutoFix
+	FailurePolicy     *FailurePolicy
+	NewViolationsOnly *NewViolationsOnly
+	RuleExceptions    *RuleExceptions
+	// SkipList a resource matching any of the rules isn't validated
+	SkipList []*SkipRule
+}
+
+func (s *ServiceState) GetConfig() *Config {
+	return s.config.Load()
+}
+
+// UpdateConfig stores the config returned by update, which receives a copy of the current config and returns the new snapshot.
+// update may be called again when another update was stored in the meantime
+func (s *ServiceState) UpdateConfig(update func(config Config) Config) *Config {
+	for {
+		currentConfig := s.config.Load()
+		updatedConfig := update(*currentConfig)
+		if s.config.CompareAndSwap(currentConfig, &updatedConfig) {
+			return &updatedConfig
+		}
+	}
+}
+
+// GetIsOfflineMode offline mode reads the policies from a local directory and never calls the backend
+func (s *ServiceState) GetIsOfflineMode() bool {
+	return s.offlinePoliciesDir != ""
 }
 
-func (s *ServiceState) GetBypassPermissions() *BypassPermissions {
-	return s.bypassPermissions
+func (s *ServiceState) GetOfflinePoliciesDir() string {
+	return s.offlinePoliciesDir
 }
 
-func (s *ServiceState) SetBypassPermissions(bypassPermissions *BypassPermissions) {
-	s.bypassPermissions = bypassPermissions
+func (s *ServiceState) GetOfflineResultsFile() string {
+	return s.offlineResultsFile
+}
+
+func (s *ServiceState) GetKubernetesEvents() bool {
+	return s.kubernetesEvents
+}
+
+func (s *ServiceState) GetSelfSignedCertificate() bool {
+	return s.selfSignedCert
+}
+
+// GetResultSpoolDir returns the directory evaluation results are spooled to, empty when spooling is disabled
+func (s *ServiceState) GetResultSpoolDir() string {
+	return s.resultSpoolDir
+}
+
+// GetResultSinks returns the destinations of the evaluation results, they are only read on startup
+func (s *ServiceState) GetResultSinks() []ResultSinkConfig {
+	return s.resultSinks
+}
+
+func (s *ServiceState) GetAudit() AuditConfig {
+	return s.audit
+}
+
+// SetPrerunDataError records why the fetched prerun data was rejected, a nil err records that it was accepted
+func (s *ServiceState) SetPrerunDataError(err error) {
+	if err == nil {
+		s.prerunDataError.Store(nil)
+		return
+	}
+	message := err.Error()
+	s.prerunDataError.Store(&message)
+}
+
+// GetPrerunDataError returns why the last fetched prerun data was rejected, or nil
+func (s *ServiceState) GetPrerunDataError() error {
+	if message := s.prerunDataError.Load(); message != nil {
+		return errors.New(*message)
+	}
+	return nil
+}
+
+// GetPolicyExceptions returns true when the DatreePolicyException resources are applied
+func (s *ServiceState) GetPolicyExceptions() bool {
+	return s.policyExceptions
+}
+
+// GetValidationTimeout returns the time budget of a single admission request
+func (s *ServiceState) GetValidationTimeout() time.Duration {
+	return s.validationTimeout
+}
+
+// GetPrerunCacheTTL returns how often the cached prerun data is refreshed, 0 means the prerun data is not cached
+func (s *ServiceState) GetPrerunCacheTTL() time.Duration {
+	return s.prerunCacheTTL
 }
 
 type EnabledWarnings struct {
@@ -186,14 +425,72 @@ func (s *ServiceState) GetEnabledWarnings() EnabledWarnings {
 	return enabledWarnings
 }
 
+// Namespaces the namespaces a policy runs for, a namespace is included when it matches an include pattern or the label
+// selector, unless it matches an exclude pattern
 type Namespaces struct {
 	IncludePatterns []string `yaml:"includePatterns" json:"includePatterns"`
 	ExcludePatterns []string `yaml:"excludePatterns" json:"excludePatterns"`
+	// LabelSelector selects the namespaces by their labels, e.g. the namespaces of a team or an environment
+	LabelSelector *metav1.LabelSelector `yaml:"labelSelector,omitempty" json:"labelSelector,omitempty"`
+
+	labelSelector labels.Selector
+}
+
+// MatchesLabels returns true when the label selector matches the namespace labels. the labels of a namespace that can't
+// be looked up are unknown, they match no selector, not even one that only excludes labels
+func (n *Namespaces) MatchesLabels(namespaceLabels map[string]string, areLabelsKnown bool) bool {
+	if n.labelSelector == nil || !areLabelsKnown {
+		return false
+	}
+	return n.labelSelector.Matches(labels.Set(namespaceLabels))
+}
+
+// PolicyKinds the kinds a policy runs for, a kind is included when Include is empty or lists it, unless Exclude lists it
+type PolicyKinds struct {
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
 }
 
 type PolicyWithNamespaces struct {
 	Policy     string     `yaml:"policy" json:"policy"`
 	Namespaces Namespaces `yaml:"namespaces" json:"namespaces"`
+	// Kinds, ObjectSelector and Operations restrict the policy to the resources that match every one of them that is set
+	Kinds *PolicyKinds `yaml:"kinds,omitempty" json:"kinds,omitempty"`
+	// ObjectSelector selects the resources by their labels
+	ObjectSelector *metav1.LabelSelector `yaml:"objectSelector,omitempty" json:"objectSelector,omitempty"`
+	// Operations the admission operations, e.g. CREATE or UPDATE
+	Operations []string              `yaml:"operations,omitempty" json:"operations,omitempty"`
+	Action     enums.ActionOnFailure `yaml:"action,omitempty" json:"action,omitempty"`
+
+	objectSelector labels.Selector
+}
+
+// Compile compiles the selectors of the policy once, instead of on every request. loadMultiplePolicies compiles the
+// policies it loads
+func (p *PolicyWithNamespaces) Compile() error {
+	if p.Namespaces.LabelSelector != nil {
+		labelSelector, err := metav1.LabelSelectorAsSelector(p.Namespaces.LabelSelector)
+		if err != nil {
+			return fmt.Errorf("namespace label selector of policy %s: %s", p.Policy, err)
+		}
+		p.Namespaces.labelSelector = labelSelector
+	}
+	if p.ObjectSelector != nil {
+		objectSelector, err := metav1.LabelSelectorAsSelector(p.ObjectSelector)
+		if err != nil {
+			return fmt.Errorf("object selector of policy %s: %s", p.Policy, err)
+		}
+		p.objectSelector = objectSelector
+	}
+	return nil
+}
+
+// MatchesObjectLabels returns true when the policy has no object selector, or when it matches the resource labels
+func (p *PolicyWithNamespaces) MatchesObjectLabels(objectLabels map[string]string) bool {
+	if p.ObjectSelector == nil {
+		return true
+	}
+	return p.objectSelector != nil && p.objectSelector.Matches(labels.Set(objectLabels))
 }
 
 type MultiplePolicies = []PolicyWithNamespaces
@@ -204,52 +501,522 @@ type BypassPermissions struct {
 	Groups          []string `yaml:"groups,omitempty" json:"groups,omitempty"`
 }
 
-func readMultiplePolicies() *MultiplePolicies {
-	datreeMultiplePoliciesPath := filepath.Join(DATREE_CONFIG_FILE_DIR, "datreeMultiplePolicies")
+// AutoFix configures which failed rules the /mutate endpoint is allowed to remediate, and the values it uses to do so.
+// auto-fix is opt-in, a rule that isn't listed in Rules is never fixed
+type AutoFix struct {
+	Rules    []string        `yaml:"rules,omitempty" json:"rules,omitempty"`
+	Defaults AutoFixDefaults `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+}
 
-	if _, err := os.Stat(datreeMultiplePoliciesPath); errors.Is(err, os.ErrNotExist) {
-		fmt.Println(fmt.Errorf("multiplePolicies not found on path: %s", datreeMultiplePoliciesPath))
-		return nil
+// FailurePolicy decides whether a resource is allowed when its policy check fails to run: the prerun data can't be fetched,
+// a policy can't be created or evaluated, or the validation panics. it is unrelated to the failurePolicy of the
+// ValidatingWebhookConfiguration, which only applies when the webhook doesn't respond
+type FailurePolicy struct {
+	Mode enums.FailureMode `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// NamespaceOverrides the first override with a pattern matching the namespace replaces Mode
+	NamespaceOverrides []FailurePolicyNamespaceOverride `yaml:"namespaceOverrides,omitempty" json:"namespaceOverrides,omitempty"`
+}
+
+type FailurePolicyNamespaceOverride struct {
+	NamespacePatterns []string          `yaml:"namespacePatterns" json:"namespacePatterns"`
+	Mode              enums.FailureMode `yaml:"mode" json:"mode"`
+}
+
+// GetMode returns the failure mode of the namespace, a nil FailurePolicy fails open
+func (f *FailurePolicy) GetMode(namespace string) enums.FailureMode {
+	if f == nil {
+		return enums.FailOpen
+	}
+	for _, namespaceOverride := range f.NamespaceOverrides {
+		for _, namespacePattern := range namespaceOverride.NamespacePatterns {
+			if match, _ := regexp.MatchString(namespacePattern, namespace); match {
+				return namespaceOverride.Mode
+			}
+		}
+	}
+	if f.Mode == "" {
+		return enums.FailOpen
 	}
+	return f.Mode
+}
 
-	fileContent, readFileError := os.ReadFile(datreeMultiplePoliciesPath)
-	if readFileError != nil {
-		fmt.Println(readFileError)
-		return nil
+// IsFailClosed returns true when resources of the namespace are denied when their policy check fails to run
+func (f *FailurePolicy) IsFailClosed(namespace string) bool {
+	return f.GetMode(namespace) == enums.FailClosed
+}
+
+// NewViolationsOnly on UPDATE, the resource is only denied for the rules that fail on it but passed on the old object,
+// so an unrelated edit of a resource that already violates a rule isn't blocked
+type NewViolationsOnly struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// NamespacePatterns limits it to the matching namespaces, it applies to all the namespaces when empty
+	NamespacePatterns []string `yaml:"namespacePatterns,omitempty" json:"namespacePatterns,omitempty"`
+}
+
+// IsEnabled returns true when the updates of resources in the namespace are only denied for new violations
+func (n *NewViolationsOnly) IsEnabled(namespace string) bool {
+	if n == nil || !n.Enabled {
+		return false
+	}
+	if len(n.NamespacePatterns) == 0 {
+		return true
+	}
+	for _, namespacePattern := range n.NamespacePatterns {
+		if match, _ := regexp.MatchString(namespacePattern, namespace); match {
+			return true
+		}
 	}
+	return false
+}
 
-	result := &MultiplePolicies{}
-	fileUnmarshalError := yaml.Unmarshal(fileContent, &result)
+// RuleExceptions which rules resources may be excepted from with the admission.datree/exception-* annotations, by whom and
+// for how long. an exception that no allowance matches is ignored, so no rule can be excepted when it is nil
+type RuleExceptions struct {
+	Allowances []RuleExceptionAllowance `yaml:"allowances" json:"allowances"`
+}
 
-	if fileUnmarshalError != nil {
-		fmt.Println(fileUnmarshalError)
-		return nil
+type RuleExceptionAllowance struct {
+	// Rules the identifiers of the rules that may be excepted
+	Rules []string `yaml:"rules" json:"rules"`
+	// NamespacePatterns limits the allowance to the matching namespaces, it applies to all the namespaces when empty
+	NamespacePatterns []string `yaml:"namespacePatterns,omitempty" json:"namespacePatterns,omitempty"`
+	// Requesters who may declare the exceptions, anyone may when it is nil
+	Requesters *BypassPermissions `yaml:"requesters,omitempty" json:"requesters,omitempty"`
+	// MaxDurationDays an exception that expires later than this from now isn't applied, unlimited when 0
+	MaxDurationDays int `yaml:"maxDurationDays,omitempty" json:"maxDurationDays,omitempty"`
+}
+
+// AllowsRule returns true when the allowance applies to the rule in the namespace, the requester is checked by the caller
+func (r *RuleExceptionAllowance) AllowsRule(ruleIdentifier string, namespace string) bool {
+	isRuleAllowed := false
+	for _, rule := range r.Rules {
+		if rule == ruleIdentifier {
+			isRuleAllowed = true
+			break
+		}
+	}
+	if !isRuleAllowed {
+		return false
+	}
+	if len(r.NamespacePatterns) == 0 {
+		return true
+	}
+	for _, namespacePattern := range r.NamespacePatterns {
+		if match, _ := regexp.MatchString(namespacePattern, namespace); match {
+			return true
+		}
+	}
+	return false
+}
+
+type AutoFixDefaults struct {
+	CpuRequest    string `yaml:"cpuRequest,omitempty" json:"cpuRequest,omitempty"`
+	CpuLimit      string `yaml:"cpuLimit,omitempty" json:"cpuLimit,omitempty"`
+	MemoryRequest string `yaml:"memoryRequest,omitempty" json:"memoryRequest,omitempty"`
+	MemoryLimit   string `yaml:"memoryLimit,omitempty" json:"memoryLimit,omitempty"`
+}
+
+// ResultSinkConfig a destination of the evaluation results and the request metadata
+type ResultSinkConfig struct {
+	Type enums.ResultSinkType `yaml:"type" json:"type"`
+	// Path the file the file sink appends to
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// URL the endpoint the webhook sink posts to
+	URL     string            `yaml:"url,omitempty" json:"url,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// PayloadTemplate a go template of the webhook sink request body, executed with the record. the record is posted as json when empty
+	PayloadTemplate string `yaml:"payloadTemplate,omitempty" json:"payloadTemplate,omitempty"`
+}
+
+// ReloadConfigFiles re-reads the config files mounted on DATREE_CONFIG_FILE_DIR.
+// the files are all validated before any of them is applied, on error the current config is kept. the skip list is the
+// exception, like on startup its valid items are kept and the invalid ones are reported by /config-check
+func (s *ServiceState) ReloadConfigFiles() error {
+	multiplePolicies, err := loadMultiplePolicies()
+	if err != nil {
+		return err
+	}
+	bypassPermissions, err := loadBypassPermissions()
+	if err != nil {
+		return err
+	}
+	autoFix, err := loadAutoFix()
+	if err != nil {
+		return err
+	}
+	failurePolicy, err := loadFailurePolicy()
+	if err != nil {
+		return err
+	}
+	newViolationsOnly, err := loadNewViolationsOnly()
+	if err != nil {
+		return err
 	}
+	ruleExceptions, err := loadRuleExceptions()
+	if err != nil {
+		return err
+	}
+	skipList := readSkipList()
+
+	s.UpdateConfig(func(config Config) Config {
+		config.MultiplePolicies = multiplePolicies
+		config.BypassPermissions = bypassPermissions
+		config.AutoFix = autoFix
+		config.FailurePolicy = failurePolicy
+		config.NewViolationsOnly = newViolationsOnly
+		config.RuleExceptions = ruleExceptions
+		config.SkipList = skipList
+		return config
+	})
+	return nil
+}
 
+func readMultiplePolicies() *MultiplePolicies {
+	result, err := loadMultiplePolicies()
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
 	return result
 }
 
+// CheckMultiplePolicies returns the error of an invalid datreeMultiplePolicies file. the webhook doesn't start with it,
+// since without the namespace scoping of multiplePolicies every policy would run in every namespace
+func CheckMultiplePolicies() error {
+	_, err := loadMultiplePolicies()
+	return err
+}
+
+// CheckFailurePolicy returns the error of an invalid datreeFailurePolicy file. the webhook doesn't start with it, since
+// without the failure policy every namespace would fail open, including the ones configured to fail closed
+func CheckFailurePolicy() error {
+	_, err := loadFailurePolicy()
+	return err
+}
+
 func readBypassPermissions() *BypassPermissions {
-	datreeBypassPermissionsPath := filepath.Join(DATREE_CONFIG_FILE_DIR, "datreeBypassPermissions")
+	result, err := loadBypassPermissions()
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return result
+}
 
-	if _, err := os.Stat(datreeBypassPermissionsPath); errors.Is(err, os.ErrNotExist) {
-		fmt.Println(fmt.Errorf("bypassPermissions not found on path: %s", datreeBypassPermissionsPath))
+func readAutoFix() *AutoFix {
+	result, err := loadAutoFix()
+	if err != nil {
+		fmt.Println(err)
 		return nil
 	}
+	return result
+}
 
-	fileContent, readFileError := os.ReadFile(datreeBypassPermissionsPath)
-	if readFileError != nil {
-		fmt.Println(readFileError)
+func readFailurePolicy() *FailurePolicy {
+	result, err := loadFailurePolicy()
+	if err != nil {
+		fmt.Println(err)
 		return nil
 	}
+	return result
+}
 
-	result := &BypassPermissions{}
-	fileUnmarshalError := yaml.Unmarshal(fileContent, &result)
+func readNewViolationsOnly() *NewViolationsOnly {
+	result, err := loadNewViolationsOnly()
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return result
+}
 
-	if fileUnmarshalError != nil {
-		fmt.Println(fileUnmarshalError)
+func readRuleExceptions() *RuleExceptions {
+	result, err := loadRuleExceptions()
+	if err != nil {
+		fmt.Println(err)
 		return nil
 	}
+	return result
+}
 
+// readSkipList keeps the valid items of the skip list, an invalid item is reported by /config-check instead of
+// dropping the whole skip list
+func readSkipList() []*SkipRule {
+	result, errs := parseSkipListFiles()
+	for _, err := range errs {
+		fmt.Println(fmt.Errorf("skip list item ignored: %s", err))
+	}
 	return result
 }
+
+// readResultSinks falls back to the default sinks when datreeResultSinks is missing or invalid: the Datree backend,
+// or in offline mode the offline results file, or stdout when no results file is set
+func readResultSinks(isOfflineMode bool, offlineResultsFile string) []ResultSinkConfig {
+	result, err := loadResultSinks(isOfflineMode)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if len(result) > 0 {
+		return result
+	}
+
+	if !isOfflineMode {
+		return []ResultSinkConfig{{Type: enums.DatreeResultSink}}
+	}
+	if offlineResultsFile != "" {
+		return []ResultSinkConfig{{Type: enums.FileResultSink, Path: offlineResultsFile}}
+	}
+	return []ResultSinkConfig{{Type: enums.StdoutResultSink}}
+}
+
+func loadResultSinks(isOfflineMode bool) ([]ResultSinkConfig, error) {
+	fileContent, err := readConfigFile("datreeResultSinks")
+	if fileContent == nil || err != nil {
+		return nil, err
+	}
+
+	var result []ResultSinkConfig
+	if err := yaml.Unmarshal(fileContent, &result); err != nil {
+		return nil, fmt.Errorf("invalid resultSinks: %s", err)
+	}
+
+	for _, resultSink := range result {
+		switch resultSink.Type {
+		case enums.DatreeResultSink:
+			if isOfflineMode {
+				return nil, errors.New("invalid resultSinks: the datree sink can't be used in offline mode")
+			}
+		case enums.FileResultSink:
+			if resultSink.Path == "" {
+				return nil, errors.New("invalid resultSinks: path is required by the file sink")
+			}
+		case enums.WebhookResultSink:
+			if resultSink.URL == "" {
+				return nil, errors.New("invalid resultSinks: url is required by the webhook sink")
+			}
+		case enums.StdoutResultSink, enums.PolicyReportResultSink:
+		default:
+			return nil, fmt.Errorf("invalid resultSinks: unknown type %q", resultSink.Type)
+		}
+	}
+
+	return result, nil
+}
+
+func loadMultiplePolicies() (*MultiplePolicies, error) {
+	fileContent, err := readConfigFile("datreeMultiplePolicies")
+	if fileContent == nil || err != nil {
+		return nil, err
+	}
+
+	result := &MultiplePolicies{}
+	if err := yaml.Unmarshal(fileContent, &result); err != nil {
+		return nil, fmt.Errorf("invalid multiplePolicies: %s", err)
+	}
+
+	for i, policyWithNamespaces := range *result {
+		if policyWithNamespaces.Policy == "" {
+			return nil, errors.New("invalid multiplePolicies: policy name is required")
+		}
+		if policyWithNamespaces.Action != "" && !policyWithNamespaces.Action.IsValid() {
+			return nil, fmt.Errorf("invalid multiplePolicies: unknown action %q for policy %s", policyWithNamespaces.Action, policyWithNamespaces.Policy)
+		}
+		for _, patterns := range [][]string{policyWithNamespaces.Namespaces.IncludePatterns, policyWithNamespaces.Namespaces.ExcludePatterns} {
+			for _, pattern := range patterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return nil, fmt.Errorf("invalid multiplePolicies: namespace pattern %q of policy %s: %s", pattern, policyWithNamespaces.Policy, err)
+				}
+			}
+		}
+		if err := (*result)[i].Compile(); err != nil {
+			return nil, fmt.Errorf("invalid multiplePolicies: %s", err)
+		}
+		for _, operation := range policyWithNamespaces.Operations {
+			if !slices.Contains(admissionOperations, operation) {
+				return nil, fmt.Errorf("invalid multiplePolicies: unknown operation %q of policy %s, expected one of %s", operation, policyWithNamespaces.Policy, strings.Join(admissionOperations, ", "))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func loadBypassPermissions() (*BypassPermissions, error) {
+	fileContent, err := readConfigFile("datreeBypassPermissions")
+	if fileContent == nil || err != nil {
+		return nil, err
+	}
+
+	result := &BypassPermissions{}
+	if err := yaml.Unmarshal(fileContent, &result); err != nil {
+		return nil, fmt.Errorf("invalid bypassPermissions: %s", err)
+	}
+
+	return result, nil
+}
+
+func loadAutoFix() (*AutoFix, error) {
+	fileContent, err := readConfigFile("datreeAutoFix")
+	if fileContent == nil || err != nil {
+		return nil, err
+	}
+
+	result := &AutoFix{}
+	if err := yaml.Unmarshal(fileContent, &result); err != nil {
+		return nil, fmt.Errorf("invalid autoFix: %s", err)
+	}
+
+	return result, nil
+}
+
+func loadFailurePolicy() (*FailurePolicy, error) {
+	fileContent, err := readConfigFile("datreeFailurePolicy")
+	if fileContent == nil || err != nil {
+		return nil, err
+	}
+
+	result := &FailurePolicy{}
+	if err := yaml.Unmarshal(fileContent, &result); err != nil {
+		return nil, fmt.Errorf("invalid failurePolicy: %s", err)
+	}
+
+	modes := []enums.FailureMode{result.Mode}
+	for _, namespaceOverride := range result.NamespaceOverrides {
+		if namespaceOverride.Mode == "" {
+			return nil, errors.New("invalid failurePolicy: namespace override mode is required")
+		}
+		modes = append(modes, namespaceOverride.Mode)
+		for _, namespacePattern := range namespaceOverride.NamespacePatterns {
+			if _, err := regexp.Compile(namespacePattern); err != nil {
+				return nil, fmt.Errorf("invalid failurePolicy: namespace pattern %q: %s", namespacePattern, err)
+			}
+		}
+	}
+	for _, mode := range modes {
+		switch mode {
+		case "", enums.FailOpen, enums.FailClosed:
+		default:
+			return nil, fmt.Errorf("invalid failurePolicy: unknown mode %q", mode)
+		}
+	}
+
+	return result, nil
+}
+
+func loadNewViolationsOnly() (*NewViolationsOnly, error) {
+	fileContent, err := readConfigFile("datreeNewViolationsOnly")
+	if fileContent == nil || err != nil {
+		return nil, err
+	}
+
+	result := &NewViolationsOnly{}
+	if err := yaml.Unmarshal(fileContent, &result); err != nil {
+		return nil, fmt.Errorf("invalid newViolationsOnly: %s", err)
+	}
+	for _, namespacePattern := range result.NamespacePatterns {
+		if _, err := regexp.Compile(namespacePattern); err != nil {
+			return nil, fmt.Errorf("invalid newViolationsOnly: namespace pattern %q: %s", namespacePattern, err)
+		}
+	}
+
+	return result, nil
+}
+
+func loadRuleExceptions() (*RuleExceptions, error) {
+	fileContent, err := readConfigFile("datreeRuleExceptions")
+	if fileContent == nil || err != nil {
+		return nil, err
+	}
+
+	result := &RuleExceptions{}
+	if err := yaml.Unmarshal(fileContent, &result); err != nil {
+		return nil, fmt.Errorf("invalid ruleExceptions: %s", err)
+	}
+	for _, allowance := range result.Allowances {
+		if len(allowance.Rules) == 0 {
+			return nil, errors.New("invalid ruleExceptions: allowance rules are required")
+		}
+		if allowance.MaxDurationDays < 0 {
+			return nil, fmt.Errorf("invalid ruleExceptions: maxDurationDays %d is negative", allowance.MaxDurationDays)
+		}
+		for _, namespacePattern := range allowance.NamespacePatterns {
+			if _, err := regexp.Compile(namespacePattern); err != nil {
+				return nil, fmt.Errorf("invalid ruleExceptions: namespace pattern %q: %s", namespacePattern, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// parseSkipListFiles merges the skip list from the helm values (datreeSkipList) with the user managed skip list (skiplist).
+// it returns an error for each invalid item, so they are all reported at once
+func parseSkipListFiles() ([]*SkipRule, []error) {
+	skipList := []*SkipRule{}
+	var errs []error
+	for _, fileName := range []string{"datreeSkipList", "skiplist"} {
+		fileContent, err := readConfigFile(fileName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if fileContent == nil {
+			continue
+		}
+
+		var fileSkipList []json.RawMessage
+		if err := yaml.Unmarshal(fileContent, &fileSkipList); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %s", fileName, err))
+			continue
+		}
+		for i, item := range fileSkipList {
+			skipRule, err := ParseSkipRule(item)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s item %d: %s", fileName, i, err))
+				continue
+			}
+			skipList = append(skipList, skipRule)
+		}
+	}
+	return skipList, errs
+}
+
+// CheckConfigFiles validates the config files mounted on DATREE_CONFIG_FILE_DIR without applying them, and returns all
+// their errors
+func CheckConfigFiles() []error {
+	var errs []error
+	for _, load := range []func() error{
+		func() error { _, err := loadMultiplePolicies(); return err },
+		func() error { _, err := loadBypassPermissions(); return err },
+		func() error { _, err := loadAutoFix(); return err },
+		func() error { _, err := loadFailurePolicy(); return err },
+		func() error { _, err := loadNewViolationsOnly(); return err },
+		func() error { _, err := loadRuleExceptions(); return err },
+	} {
+		if err := load(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	_, skipListErrs := parseSkipListFiles()
+	return append(errs, skipListErrs...)
+}
+
+func joinErrors(errs []error) error {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// readConfigFile returns nil without an error when the file doesn't exist, config files are optional
+func readConfigFile(fileName string) ([]byte, error) {
+	filePath := filepath.Join(DATREE_CONFIG_FILE_DIR, fileName)
+
+	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+		fmt.Println(fmt.Errorf("%s not found on path: %s", fileName, filePath))
+		return nil, nil
+	}
+
+	return os.ReadFile(filePath)
+}
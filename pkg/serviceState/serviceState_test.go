@@ -0,0 +1,117 @@
+package servicestate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setConfigFileDir points DATREE_CONFIG_FILE_DIR to a temporary directory until the end of the test
+func setConfigFileDir(t *testing.T) {
+	configFileDir := DATREE_CONFIG_FILE_DIR
+	t.Cleanup(func() { DATREE_CONFIG_FILE_DIR = configFileDir })
+	DATREE_CONFIG_FILE_DIR = t.TempDir()
+}
+
+func writeMultiplePoliciesFile(t *testing.T, content string) {
+	setConfigFileDir(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(DATREE_CONFIG_FILE_DIR, "datreeMultiplePolicies"), []byte(content), 0644))
+}
+
+func TestLoadMultiplePolicies(t *testing.T) {
+	t.Run("should load the namespace and the resource restrictions of a policy", func(t *testing.T) {
+		writeMultiplePoliciesFile(t, `
+- policy: ingress-hardening
+  namespaces:
+    excludePatterns:
+      - "^sandbox-"
+    labelSelector:
+      matchLabels:
+        env: prod
+  kinds:
+    include:
+      - Ingress
+      - Service
+  objectSelector:
+    matchExpressions:
+      - key: tier
+        operator: In
+        values:
+          - frontend
+  operations:
+    - CREATE
+  action: enforce
+`)
+
+		multiplePolicies, err := loadMultiplePolicies()
+
+		assert.NoError(t, err)
+		expectedMultiplePolicies := &MultiplePolicies{{
+			Policy: "ingress-hardening",
+			Namespaces: Namespaces{
+				ExcludePatterns: []string{"^sandbox-"},
+				LabelSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			},
+			Kinds: &PolicyKinds{Include: []string{"Ingress", "Service"}},
+			ObjectSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"frontend"}},
+			}},
+			Operations: []string{"CREATE"},
+			Action:     "enforce",
+		}}
+		// the selectors are compiled when the policies are loaded
+		assert.NoError(t, (*expectedMultiplePolicies)[0].Compile())
+		assert.Equal(t, expectedMultiplePolicies, multiplePolicies)
+		assert.True(t, (*multiplePolicies)[0].Namespaces.MatchesLabels(map[string]string{"env": "prod"}, true))
+		assert.True(t, (*multiplePolicies)[0].MatchesObjectLabels(map[string]string{"tier": "frontend"}))
+	})
+
+	t.Run("should return an error for an invalid restriction", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"namespace pattern":        "- policy: p\n  namespaces:\n    includePatterns: [\"(\"]",
+			"namespace label selector": "- policy: p\n  namespaces:\n    labelSelector:\n      matchExpressions: [{key: env, operator: Equals}]",
+			"object selector":          "- policy: p\n  objectSelector:\n    matchLabels: {\"invalid key!\": x}",
+			"operation":                "- policy: p\n  operations: [PATCH]",
+		} {
+			writeMultiplePoliciesFile(t, content)
+
+			_, err := loadMultiplePolicies()
+
+			assert.ErrorContains(t, err, "invalid multiplePolicies", name)
+			assert.Error(t, CheckMultiplePolicies(), name)
+		}
+	})
+}
+
+func TestCheckFailurePolicy(t *testing.T) {
+	writeFailurePolicyFile := func(t *testing.T, content string) {
+		setConfigFileDir(t)
+		assert.NoError(t, os.WriteFile(filepath.Join(DATREE_CONFIG_FILE_DIR, "datreeFailurePolicy"), []byte(content), 0644))
+	}
+
+	t.Run("should accept a valid failure policy", func(t *testing.T) {
+		writeFailurePolicyFile(t, "mode: closed\nnamespaceOverrides:\n  - namespacePatterns: [\"^dev-\"]\n    mode: open")
+
+		assert.NoError(t, CheckFailurePolicy())
+		assert.Empty(t, CheckConfigFiles())
+	})
+
+	t.Run("should return an error for an invalid failure policy", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"mode":              "mode: clozed",
+			"override mode":     "namespaceOverrides:\n  - namespacePatterns: [\"^dev-\"]",
+			"namespace pattern": "namespaceOverrides:\n  - namespacePatterns: [\"(\"]\n    mode: open",
+		} {
+			writeFailurePolicyFile(t, content)
+
+			assert.ErrorContains(t, CheckFailurePolicy(), "invalid failurePolicy", name)
+			configErrors := CheckConfigFiles()
+			if assert.Len(t, configErrors, 1, name) {
+				assert.ErrorContains(t, configErrors[0], "invalid failurePolicy", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package servicestate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/strings/slices"
+)
+
+// admissionOperations the operations a skip rule or a policy can be restricted to
+var admissionOperations = []string{"CREATE", "UPDATE", "DELETE", "CONNECT"}
+
+// SkipRule a resource that matches every set field of a skip rule isn't validated. a skip list item is either a structured
+// rule, or the legacy "namespace;kind;name" string. the rule is compiled once when it is parsed
+type SkipRule struct {
+	// Namespace, Kind and Name are regexes
+	Namespace     string                `json:"namespace,omitempty"`
+	Kind          string                `json:"kind,omitempty"`
+	Name          string                `json:"name,omitempty"`
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	// Operations the admission operations, e.g. CREATE or UPDATE
+	Operations []string `json:"operations,omitempty"`
+	// Users regexes of the requester usernames
+	Users []string `json:"users,omitempty"`
+	// ExpiresAt the rule stops applying at this time, it never expires when nil
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// legacy the "namespace;kind;name" item the rule was parsed from
+	legacy        string
+	namespace     *regexp.Regexp
+	kind          *regexp.Regexp
+	name          *regexp.Regexp
+	users         []*regexp.Regexp
+	labelSelector labels.Selector
+}
+
+// SkipListResource the admission request a skip rule is matched against
+type SkipListResource struct {
+	Namespace string
+	Kind      string
+	Name      string
+	Labels    map[string]string
+	Operation string
+	Username  string
+}
+
+// ParseSkipRule parses and compiles a skip list item, a json string in the legacy format or a json object
+func ParseSkipRule(item json.RawMessage) (*SkipRule, error) {
+	item = bytes.TrimSpace(item)
+	if len(item) > 0 && item[0] == '"' {
+		var legacyItem string
+		if err := json.Unmarshal(item, &legacyItem); err != nil {
+			return nil, err
+		}
+		return ParseLegacySkipRule(legacyItem)
+	}
+
+	rule := &SkipRule{}
+	decoder := json.NewDecoder(bytes.NewReader(item))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rule); err != nil {
+		return nil, err
+	}
+	if rule.Namespace == "" && rule.Kind == "" && rule.Name == "" && rule.LabelSelector == nil && len(rule.Operations) == 0 && len(rule.Users) == 0 {
+		return nil, errors.New("a skip rule must set at least one of namespace, kind, name, labelSelector, operations or users")
+	}
+	if err := rule.compile(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// ParseLegacySkipRule parses and compiles a "namespace;kind;name" item, each part is a regex
+func ParseLegacySkipRule(item string) (*SkipRule, error) {
+	parts := strings.Split(item, ";")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid skip list item %q, expected \"namespace;kind;name\"", item)
+	}
+
+	rule := &SkipRule{Namespace: parts[0], Kind: parts[1], Name: parts[2], legacy: item}
+	if err := rule.compile(); err != nil {
+		return nil, fmt.Errorf("invalid skip list item %q: %s", item, err)
+	}
+	return rule, nil
+}
+
+// ParseLegacySkipList parses the items that are valid, and returns an error for each of the others
+func ParseLegacySkipList(items []string) ([]*SkipRule, []error) {
+	var rules []*SkipRule
+	var errs []error
+	for _, item := range items {
+		rule, err := ParseLegacySkipRule(item)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+func (r *SkipRule) compile() error {
+	var err error
+	if r.namespace, err = compileSkipRulePattern("namespace", r.Namespace); err != nil {
+		return err
+	}
+	if r.kind, err = compileSkipRulePattern("kind", r.Kind); err != nil {
+		return err
+	}
+	if r.name, err = compileSkipRulePattern("name", r.Name); err != nil {
+		return err
+	}
+	for _, user := range r.Users {
+		userRegex, err := compileSkipRulePattern("user", user)
+		if err != nil {
+			return err
+		}
+		r.users = append(r.users, userRegex)
+	}
+	for _, operation := range r.Operations {
+		if !slices.Contains(admissionOperations, operation) {
+			return fmt.Errorf("unknown operation %q, expected one of %s", operation, strings.Join(admissionOperations, ", "))
+		}
+	}
+	if r.LabelSelector != nil {
+		if r.labelSelector, err = metav1.LabelSelectorAsSelector(r.LabelSelector); err != nil {
+			return fmt.Errorf("label selector: %s", err)
+		}
+	}
+	return nil
+}
+
+func compileSkipRulePattern(field string, pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s pattern %q: %s", field, pattern, err)
+	}
+	return compiled, nil
+}
+
+// Matches returns true when the resource matches every set field of the rule, and the rule didn't expire
+func (r *SkipRule) Matches(resource SkipListResource, now time.Time) bool {
+	if r.ExpiresAt != nil && !now.Before(r.ExpiresAt.Time) {
+		return false
+	}
+	if !matchesSkipRulePattern(r.namespace, resource.Namespace) || !matchesSkipRulePattern(r.kind, resource.Kind) || !matchesSkipRulePattern(r.name, resource.Name) {
+		return false
+	}
+	if len(r.Operations) > 0 && !slices.Contains(r.Operations, resource.Operation) {
+		return false
+	}
+	if len(r.users) > 0 {
+		isUserMatch := false
+		for _, user := range r.users {
+			if user.MatchString(resource.Username) {
+				isUserMatch = true
+				break
+			}
+		}
+		if !isUserMatch {
+			return false
+		}
+	}
+	if r.labelSelector != nil && !r.labelSelector.Matches(labels.Set(resource.Labels)) {
+		return false
+	}
+	return true
+}
+
+func matchesSkipRulePattern(pattern *regexp.Regexp, value string) bool {
+	return pattern == nil || pattern.MatchString(value)
+}
+
+// String the legacy item the rule was parsed from, or the structured rule as json
+func (r *SkipRule) String() string {
+	if r.legacy != "" {
+		return r.legacy
+	}
+	ruleJson, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf("%+v", *r)
+	}
+	return string(ruleJson)
+}
+
+type prerunSkipList struct {
+	ignorePatterns []string
+	skipList       []*SkipRule
+}
+
+// CompilePrerunSkipList compiles the ignore patterns of the prerun data, they are only compiled again when they change.
+// the errors of the invalid patterns are only returned when the patterns are compiled
+func (s *ServiceState) CompilePrerunSkipList(ignorePatterns []string) ([]*SkipRule, []error) {
+	if compiled := s.prerunSkipList.Load(); compiled != nil && slices.Equal(compiled.ignorePatterns, ignorePatterns) {
+		return compiled.skipList, nil
+	}
+
+	skipList, errs := ParseLegacySkipList(ignorePatterns)
+	s.prerunSkipList.Store(&prerunSkipList{ignorePatterns: ignorePatterns, skipList: skipList})
+	return skipList, errs
+}
+
+// GetSkipListStrings the skip list as it is reported with the request metadata
+func GetSkipListStrings(skipList []*SkipRule) []string {
+	skipListStrings := []string{}
+	for _, rule := range skipList {
+		skipListStrings = append(skipListStrings, rule.String())
+	}
+	return skipListStrings
+}
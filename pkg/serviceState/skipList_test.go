@@ -0,0 +1,131 @@
+package servicestate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var skipListNow = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func writeSkipListFile(t *testing.T, content string) {
+	setConfigFileDir(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(DATREE_CONFIG_FILE_DIR, "datreeSkipList"), []byte(content), 0644))
+}
+
+func TestLoadSkipList(t *testing.T) {
+	t.Run("should load both the legacy and the structured items", func(t *testing.T) {
+		writeSkipListFile(t, `
+- "(^openshift.*);(.*);(.*)"
+- namespace: "^dev-"
+  labelSelector:
+    matchLabels:
+      app.kubernetes.io/managed-by: my-operator
+  operations:
+    - UPDATE
+  users:
+    - "^system:serviceaccount:dev:my-operator$"
+  expiresAt: "2023-07-01T00:00:00Z"
+`)
+
+		skipList, errs := parseSkipListFiles()
+
+		assert.Empty(t, errs)
+		assert.Len(t, skipList, 2)
+		assert.Equal(t, "(^openshift.*);(.*);(.*)", skipList[0].String())
+		assert.True(t, skipList[0].Matches(SkipListResource{Namespace: "openshift-monitoring", Kind: "Deployment", Name: "prometheus"}, skipListNow))
+
+		operatorUpdate := SkipListResource{
+			Namespace: "dev-1",
+			Kind:      "Deployment",
+			Name:      "api",
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "my-operator"},
+			Operation: "UPDATE",
+			Username:  "system:serviceaccount:dev:my-operator",
+		}
+		assert.True(t, skipList[1].Matches(operatorUpdate, skipListNow))
+
+		otherNamespace := operatorUpdate
+		otherNamespace.Namespace = "prod-1"
+		otherLabels := operatorUpdate
+		otherLabels.Labels = map[string]string{"app.kubernetes.io/managed-by": "helm"}
+		otherOperation := operatorUpdate
+		otherOperation.Operation = "CREATE"
+		otherUser := operatorUpdate
+		otherUser.Username = "my-user"
+		for name, resource := range map[string]SkipListResource{"namespace": otherNamespace, "labels": otherLabels, "operation": otherOperation, "user": otherUser} {
+			assert.False(t, skipList[1].Matches(resource, skipListNow), name)
+		}
+		assert.False(t, skipList[1].Matches(operatorUpdate, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)), "expired")
+	})
+
+	t.Run("should return an error for each invalid item", func(t *testing.T) {
+		writeSkipListFile(t, `
+- "(.*);(.*)"
+- "(;(.*);(.*)"
+- namespace: "^dev-"
+- name: "("
+- operations:
+    - PATCH
+- namespaces: "^dev-"
+- {}
+`)
+
+		_, errs := parseSkipListFiles()
+		assert.Len(t, errs, 6)
+		assert.Contains(t, errs[0].Error(), "invalid datreeSkipList item 0")
+		assert.Contains(t, errs[2].Error(), "invalid datreeSkipList item 3: name pattern")
+		assert.Contains(t, errs[3].Error(), "unknown operation \"PATCH\"")
+		assert.Len(t, CheckConfigFiles(), 6)
+	})
+
+	t.Run("should keep the valid items on startup", func(t *testing.T) {
+		writeSkipListFile(t, `
+- "(^openshift.*);(.*);(.*)"
+- "(;(.*);(.*)"
+- namespace: "^dev-"
+`)
+
+		skipList := readSkipList()
+
+		assert.Len(t, skipList, 2)
+		assert.Equal(t, "(^openshift.*);(.*);(.*)", skipList[0].String())
+		assert.Len(t, CheckConfigFiles(), 1)
+	})
+
+	t.Run("should keep the same valid items on startup and on reload", func(t *testing.T) {
+		writeSkipListFile(t, "[]")
+		state := New()
+		assert.NoError(t, os.WriteFile(filepath.Join(DATREE_CONFIG_FILE_DIR, "datreeSkipList"), []byte(`
+- "(^openshift.*);(.*);(.*)"
+- "(;(.*);(.*)"
+- namespace: "^dev-"
+`), 0644))
+
+		startupSkipList := New().GetConfig().SkipList
+		assert.NoError(t, state.ReloadConfigFiles())
+
+		assert.Len(t, startupSkipList, 2)
+		assert.Equal(t, GetSkipListStrings(startupSkipList), GetSkipListStrings(state.GetConfig().SkipList))
+		assert.Len(t, CheckConfigFiles(), 1)
+	})
+}
+
+func TestCompilePrerunSkipList(t *testing.T) {
+	state := &ServiceState{}
+
+	skipList, errs := state.CompilePrerunSkipList([]string{"(.*);(.*);(^aws-node.*)", "invalid"})
+	assert.Len(t, skipList, 1)
+	assert.Len(t, errs, 1)
+
+	// the same patterns aren't compiled again, so their errors are only reported once
+	cachedSkipList, errs := state.CompilePrerunSkipList([]string{"(.*);(.*);(^aws-node.*)", "invalid"})
+	assert.Same(t, skipList[0], cachedSkipList[0])
+	assert.Empty(t, errs)
+
+	changedSkipList, _ := state.CompilePrerunSkipList([]string{"(.*);(.*);(^kube-proxy.*)"})
+	assert.Equal(t, []string{"(.*);(.*);(^kube-proxy.*)"}, GetSkipListStrings(changedSkipList))
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+	admission "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// auditUsername the requester of the audited resources, it isn't a system user so the resources aren't skipped as
+	// unchanged system updates
+	auditUsername = "datree:audit"
+	// auditListPageSize bounds the resources held in memory while a kind is scanned
+	auditListPageSize = 100
+)
+
+// AuditScanner evaluates the resources that are already in the cluster, e.g. the ones created before the webhook was
+// installed or while it was down. the scan runs on the leader only, the resources are evaluated through ValidationService.Audit
+// at a limited rate, and the results are sent to the result sinks
+type AuditScanner struct {
+	validationService *ValidationService
+	dynamicClient     dynamic.Interface
+	leaderElection    *leaderElection.LeaderElection
+	resources         []schema.GroupVersionResource
+	limiter           *rate.Limiter
+	logger            *logger.Logger
+
+	// scanContext is cancelled by Stop, it interrupts a running scan
+	scanContext context.Context
+	stopScan    context.CancelFunc
+	scanMutex   sync.Mutex
+}
+
+// NewAuditScanner leaderElection may be nil, the scan then runs on every replica
+func NewAuditScanner(validationService *ValidationService, dynamicClient dynamic.Interface, leaderElection *leaderElection.LeaderElection, auditConfig servicestate.AuditConfig, logger *logger.Logger) *AuditScanner {
+	scanContext, stopScan := context.WithCancel(context.Background())
+	return &AuditScanner{
+		validationService: validationService,
+		dynamicClient:     dynamicClient,
+		leaderElection:    leaderElection,
+		resources:         auditConfig.Resources,
+		limiter:           rate.NewLimiter(rate.Limit(auditConfig.EvaluationsPerSecond), 1),
+		logger:            logger,
+		scanContext:       scanContext,
+		stopScan:          stopScan,
+	}
+}
+
+// Scan evaluates all the resources of the configured kinds, a scan that is already running is not started again
+func (a *AuditScanner) Scan() {
+	if !a.scanMutex.TryLock() {
+		a.logger.LogInfo("audit: the previous scan is still running, skipping this one")
+		return
+	}
+	defer a.scanMutex.Unlock()
+
+	evaluatedCount := 0
+	for _, resource := range a.resources {
+		// the leader may change during a long scan
+		if !a.isLeader() || a.scanContext.Err() != nil {
+			return
+		}
+		count, err := a.scanResource(resource)
+		evaluatedCount += count
+		if err != nil {
+			a.logger.LogWarn(fmt.Sprintf("audit: failed to scan %s, err: %s", resource, err))
+		}
+	}
+	a.logger.LogInfo(fmt.Sprintf("audit: the scan evaluated %d resources", evaluatedCount))
+}
+
+// Stop interrupts the running scan, no scan runs after it
+func (a *AuditScanner) Stop() {
+	if a == nil {
+		return
+	}
+	a.stopScan()
+}
+
+func (a *AuditScanner) isLeader() bool {
+	return a.leaderElection == nil || a.leaderElection.IsLeader()
+}
+
+func (a *AuditScanner) scanResource(resource schema.GroupVersionResource) (evaluatedCount int, err error) {
+	listOptions := metav1.ListOptions{Limit: auditListPageSize}
+	for {
+		list, err := a.dynamicClient.Resource(resource).List(a.scanContext, listOptions)
+		if err != nil {
+			return evaluatedCount, err
+		}
+
+		for i := range list.Items {
+			if err := a.limiter.Wait(a.scanContext); err != nil {
+				return evaluatedCount, err
+			}
+			a.evaluate(resource, &list.Items[i])
+			evaluatedCount++
+		}
+
+		if list.GetContinue() == "" {
+			return evaluatedCount, nil
+		}
+		listOptions.Continue = list.GetContinue()
+	}
+}
+
+func (a *AuditScanner) evaluate(resource schema.GroupVersionResource, object *unstructured.Unstructured) {
+	admissionReviewReq, err := newAuditAdmissionReview(resource, object)
+	if err != nil {
+		a.logger.LogWarn(fmt.Sprintf("audit: failed to evaluate %s %s/%s, err: %s", object.GetKind(), object.GetNamespace(), object.GetName(), err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(a.scanContext, a.validationService.State.GetValidationTimeout())
+	defer cancel()
+	requestLogger := a.logger.WithRequestId(string(admissionReviewReq.Request.UID))
+	a.validationService.Audit(ctx, admissionReviewReq, requestLogger)
+}
+
+// newAuditAdmissionReview the admission request the resource would be updated with, by a user without bypass permissions
+func newAuditAdmissionReview(resource schema.GroupVersionResource, object *unstructured.Unstructured) (*admission.AdmissionReview, error) {
+	objectJson, err := object.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	gvk := object.GroupVersionKind()
+	return &admission.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admission.AdmissionRequest{
+			UID:       k8sTypes.UID(uuid.NewString()),
+			Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+			Resource:  metav1.GroupVersionResource{Group: resource.Group, Version: resource.Version, Resource: resource.Resource},
+			Name:      object.GetName(),
+			Namespace: object.GetNamespace(),
+			Operation: admission.Update,
+			UserInfo:  authenticationv1.UserInfo{Username: auditUsername},
+			Object:    runtime.RawExtension{Raw: objectJson},
+			OldObject: runtime.RawExtension{Raw: objectJson},
+		},
+	}, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+)
+
+var auditDeploymentsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+
+func mockAuditDeployment(name string, namespace string) *unstructured.Unstructured {
+	container := map[string]interface{}{"name": "app", "image": "nginx:1.25"}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace, "uid": name + "-uid"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{"spec": map[string]interface{}{"containers": []interface{}{container}}},
+		},
+	}}
+}
+
+func mockAuditScanner(t *testing.T, resultsFilePath string, objects ...runtime.Object) *AuditScanner {
+	// the built-in policies are used
+	policiesDir := t.TempDir()
+	t.Setenv(enums.OfflinePoliciesDir, policiesDir)
+
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	validationService := &ValidationService{
+		CliServiceClient:   cliClient.NewCustomCliServiceClient("", nil, nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string)),
+		State:              servicestate.New(),
+		PrerunDataProvider: NewLocalPrerunDataProvider(policiesDir),
+		ResultSinks:        NewResultSinks([]servicestate.ResultSinkConfig{{Type: enums.FileResultSink, Path: resultsFilePath}}, ResultSinkDependencies{Logger: &mockLogger}),
+		Logger:             &mockLogger,
+	}
+	dynamicClient := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		auditDeploymentsResource: "DeploymentList",
+	}, objects...)
+
+	return NewAuditScanner(validationService, dynamicClient, nil, servicestate.AuditConfig{
+		Resources:            []schema.GroupVersionResource{auditDeploymentsResource},
+		EvaluationsPerSecond: 1000,
+	}, &mockLogger)
+}
+
+func TestAuditScanner(t *testing.T) {
+	t.Run("should send the result of every resource in the cluster to the result sinks", func(t *testing.T) {
+		resultsFilePath := filepath.Join(t.TempDir(), "results.jsonl")
+		auditScanner := mockAuditScanner(t, resultsFilePath,
+			mockAuditDeployment("first", "my-namespace"),
+			mockAuditDeployment("second", "my-namespace"),
+		)
+
+		auditScanner.Scan()
+
+		records := readSinkRecords(t, resultsFilePath)
+		assert.NotEmpty(t, records)
+		resourceUidsByName := map[string]k8sTypes.UID{}
+		for _, record := range records {
+			assert.True(t, record.EvaluationResult.IsAudit)
+			assert.Equal(t, "my-namespace", record.EvaluationResult.Namespace)
+			assert.Equal(t, "Deployment", record.EvaluationResult.Kind)
+			// the container has no limits
+			assert.False(t, record.EvaluationResult.Passed)
+			resourceUidsByName[record.EvaluationResult.MetadataName] = record.EvaluationResult.ResourceUid
+		}
+		assert.Equal(t, map[string]k8sTypes.UID{"first": "first-uid", "second": "second-uid"}, resourceUidsByName)
+	})
+
+	t.Run("should skip the resources the webhook doesn't validate", func(t *testing.T) {
+		resultsFilePath := filepath.Join(t.TempDir(), "results.jsonl")
+		ownedDeployment := mockAuditDeployment("owned", "my-namespace")
+		ownedDeployment.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "v1", Kind: "Owner", Name: "owner", UID: "owner-uid"}})
+		auditScanner := mockAuditScanner(t, resultsFilePath,
+			ownedDeployment,
+			mockAuditDeployment("in-skipped-namespace", "kube-public"),
+		)
+
+		auditScanner.Scan()
+
+		_, err := os.Stat(resultsFilePath)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("should not scan after it was stopped", func(t *testing.T) {
+		resultsFilePath := filepath.Join(t.TempDir(), "results.jsonl")
+		auditScanner := mockAuditScanner(t, resultsFilePath, mockAuditDeployment("failing", "my-namespace"))
+
+		auditScanner.Stop()
+		auditScanner.Scan()
+
+		_, err := os.Stat(resultsFilePath)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"fmt"
+
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"k8s.io/utils/strings/slices"
+)
+
+// autoFixRule remediates a single failed rule on a container, returns true if the container was changed
+type autoFixRule func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool
+
+var defaultAutoFixDefaults = servicestate.AutoFixDefaults{
+	CpuRequest:    "100m",
+	CpuLimit:      "500m",
+	MemoryRequest: "128Mi",
+	MemoryLimit:   "512Mi",
+}
+
+// autoFixRules maps a rule identifier to the function that fixes it.
+// only rules with a safe, deterministic fix belong here
+var autoFixRules = map[string]autoFixRule{
+	"CONTAINERS_MISSING_CPU_REQUEST_KEY": func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool {
+		return setNestedFieldIfMissing(container, defaults.CpuRequest, "resources", "requests", "cpu")
+	},
+	"CONTAINERS_MISSING_CPU_LIMIT_KEY": func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool {
+		return setNestedFieldIfMissing(container, defaults.CpuLimit, "resources", "limits", "cpu")
+	},
+	"CONTAINERS_MISSING_MEMORY_REQUEST_KEY": func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool {
+		return setNestedFieldIfMissing(container, defaults.MemoryRequest, "resources", "requests", "memory")
+	},
+	"CONTAINERS_MISSING_MEMORY_LIMIT_KEY": func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool {
+		return setNestedFieldIfMissing(container, defaults.MemoryLimit, "resources", "limits", "memory")
+	},
+	"CONTAINERS_INCORRECT_READONLYROOTFILESYSTEM_VALUE": func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool {
+		return setNestedField(container, true, "securityContext", "readOnlyRootFilesystem")
+	},
+	"CONTAINERS_MISSING_KEY_ALLOWPRIVILEGEESCALATION": func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool {
+		return setNestedField(container, false, "securityContext", "allowPrivilegeEscalation")
+	},
+	"CONTAINERS_INCORRECT_PRIVILEGED_VALUE_TRUE": func(container map[string]interface{}, defaults servicestate.AutoFixDefaults) bool {
+		return setNestedField(container, false, "securityContext", "privileged")
+	},
+}
+
+func isAutoFixableRule(ruleIdentifier string, autoFix *servicestate.AutoFix) bool {
+	if _, ok := autoFixRules[ruleIdentifier]; !ok {
+		return false
+	}
+	// auto-fix is opt-in, only the configured rules are fixed
+	if autoFix == nil {
+		return false
+	}
+	return slices.Contains(autoFix.Rules, ruleIdentifier)
+}
+
+func getAutoFixDefaults(autoFix *servicestate.AutoFix) servicestate.AutoFixDefaults {
+	defaults := defaultAutoFixDefaults
+	if autoFix == nil {
+		return defaults
+	}
+	if autoFix.Defaults.CpuRequest != "" {
+		defaults.CpuRequest = autoFix.Defaults.CpuRequest
+	}
+	if autoFix.Defaults.CpuLimit != "" {
+		defaults.CpuLimit = autoFix.Defaults.CpuLimit
+	}
+	if autoFix.Defaults.MemoryRequest != "" {
+		defaults.MemoryRequest = autoFix.Defaults.MemoryRequest
+	}
+	if autoFix.Defaults.MemoryLimit != "" {
+		defaults.MemoryLimit = autoFix.Defaults.MemoryLimit
+	}
+	return defaults
+}
+
+// getPodSpecPath returns the JSON pointer of the pod spec inside a resource of the given kind
+func getPodSpecPath(resourceKind string) ([]string, bool) {
+	switch resourceKind {
+	case "Pod":
+		return []string{"spec"}, true
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		return []string{"spec", "template", "spec"}, true
+	case "CronJob":
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}, true
+	}
+	return nil, false
+}
+
+func getNestedMap(object map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	current := object
+	for _, key := range path {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+func setNestedField(object map[string]interface{}, value interface{}, path ...string) bool {
+	parent := object
+	for _, key := range path[:len(path)-1] {
+		next, ok := parent[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			parent[key] = next
+		}
+		parent = next
+	}
+
+	lastKey := path[len(path)-1]
+	if currentValue, ok := parent[lastKey]; ok && currentValue == value {
+		return false
+	}
+	parent[lastKey] = value
+	return true
+}
+
+func setNestedFieldIfMissing(object map[string]interface{}, value interface{}, path ...string) bool {
+	if parent, ok := getNestedMap(object, path[:len(path)-1]); ok {
+		if _, exists := parent[path[len(path)-1]]; exists {
+			return false
+		}
+	}
+	return setNestedField(object, value, path...)
+}
+
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// applyAutoFixRules fixes the given rules on every container of the resource, and returns a JSONPatch operation per changed container
+// and the rules that actually changed something
+func applyAutoFixRules(object map[string]interface{}, resourceKind string, ruleIdentifiers []string, defaults servicestate.AutoFixDefaults) ([]jsonPatchOperation, []string) {
+	podSpecPath, ok := getPodSpecPath(resourceKind)
+	if !ok {
+		return nil, nil
+	}
+	podSpec, ok := getNestedMap(object, podSpecPath)
+	if !ok {
+		return nil, nil
+	}
+
+	var patchOperations []jsonPatchOperation
+	var fixedRules []string
+
+	for _, containersKey := range []string{"initContainers", "containers"} {
+		containers, ok := podSpec[containersKey].([]interface{})
+		if !ok {
+			continue
+		}
+		for containerIndex, rawContainer := range containers {
+			container, ok := rawContainer.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			isContainerChanged := false
+			for _, ruleIdentifier := range ruleIdentifiers {
+				if autoFixRules[ruleIdentifier](container, defaults) {
+					isContainerChanged = true
+					if !slices.Contains(fixedRules, ruleIdentifier) {
+						fixedRules = append(fixedRules, ruleIdentifier)
+					}
+				}
+			}
+
+			if isContainerChanged {
+				patchOperations = append(patchOperations, jsonPatchOperation{
+					Op:    "replace",
+					Path:  fmt.Sprintf("%s/%s/%d", toJsonPointer(podSpecPath), containersKey, containerIndex),
+					Value: container,
+				})
+			}
+		}
+	}
+
+	return patchOperations, fixedRules
+}
+
+func toJsonPointer(path []string) string {
+	jsonPointer := ""
+	for _, key := range path {
+		jsonPointer += "/" + key
+	}
+	return jsonPointer
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"testing"
+
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/stretchr/testify/assert"
+)
+
+func getDeploymentObject() map[string]interface{} {
+	return map[string]interface{}{
+		"kind": "Deployment",
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name": "app",
+							"resources": map[string]interface{}{
+								"limits": map[string]interface{}{"cpu": "1"},
+							},
+						},
+						map[string]interface{}{
+							"name":            "sidecar",
+							"securityContext": map[string]interface{}{"readOnlyRootFilesystem": true},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestApplyAutoFixRules(t *testing.T) {
+	t.Run("should only patch containers that fail the rule", func(t *testing.T) {
+		patchOperations, fixedRules := applyAutoFixRules(getDeploymentObject(), "Deployment", []string{"CONTAINERS_MISSING_CPU_LIMIT_KEY"}, defaultAutoFixDefaults)
+
+		assert.Equal(t, []string{"CONTAINERS_MISSING_CPU_LIMIT_KEY"}, fixedRules)
+		assert.Len(t, patchOperations, 1)
+		assert.Equal(t, "/spec/template/spec/containers/1", patchOperations[0].Path)
+		container := patchOperations[0].Value.(map[string]interface{})
+		assert.Equal(t, "500m", container["resources"].(map[string]interface{})["limits"].(map[string]interface{})["cpu"])
+	})
+
+	t.Run("should keep existing values and combine multiple fixes into one operation per container", func(t *testing.T) {
+		patchOperations, fixedRules := applyAutoFixRules(getDeploymentObject(), "Deployment", []string{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "CONTAINERS_INCORRECT_READONLYROOTFILESYSTEM_VALUE"}, defaultAutoFixDefaults)
+
+		assert.ElementsMatch(t, []string{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "CONTAINERS_INCORRECT_READONLYROOTFILESYSTEM_VALUE"}, fixedRules)
+		assert.Len(t, patchOperations, 2)
+		appContainer := patchOperations[0].Value.(map[string]interface{})
+		appLimits := appContainer["resources"].(map[string]interface{})["limits"].(map[string]interface{})
+		assert.Equal(t, "1", appLimits["cpu"])
+		assert.Equal(t, "512Mi", appLimits["memory"])
+		assert.Equal(t, true, appContainer["securityContext"].(map[string]interface{})["readOnlyRootFilesystem"])
+	})
+
+	t.Run("should not patch kinds without a pod spec", func(t *testing.T) {
+		patchOperations, fixedRules := applyAutoFixRules(getDeploymentObject(), "Service", []string{"CONTAINERS_MISSING_CPU_LIMIT_KEY"}, defaultAutoFixDefaults)
+
+		assert.Empty(t, patchOperations)
+		assert.Empty(t, fixedRules)
+	})
+}
+
+func TestIsAutoFixableRule(t *testing.T) {
+	assert.Equal(t, true, isAutoFixableRule("CONTAINERS_MISSING_CPU_LIMIT_KEY", &servicestate.AutoFix{Rules: []string{"CONTAINERS_MISSING_CPU_LIMIT_KEY"}}))
+	assert.Equal(t, false, isAutoFixableRule("CONTAINERS_MISSING_CPU_LIMIT_KEY", nil))
+	assert.Equal(t, false, isAutoFixableRule("CONTAINERS_MISSING_CPU_LIMIT_KEY", &servicestate.AutoFix{}))
+	assert.Equal(t, false, isAutoFixableRule("CONTAINERS_MISSING_LIVENESSPROBE_KEY", &servicestate.AutoFix{Rules: []string{"CONTAINERS_MISSING_LIVENESSPROBE_KEY"}}))
+	assert.Equal(t, false, isAutoFixableRule("CONTAINERS_MISSING_CPU_LIMIT_KEY", &servicestate.AutoFix{Rules: []string{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY"}}))
+	assert.Equal(t, "1Gi", getAutoFixDefaults(&servicestate.AutoFix{Defaults: servicestate.AutoFixDefaults{MemoryLimit: "1Gi"}}).MemoryLimit)
+}
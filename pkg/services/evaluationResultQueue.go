@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/google/uuid"
+)
+
+const (
+	evaluationResultQueueSize    = 1000
+	evaluationResultQueueWorkers = 4
+	maxEvaluationResultAttempts  = 5
+	initialUploadBackoff         = time.Second
+	maxUploadBackoff             = time.Minute
+	// spoolDrainInterval how often the spooled results are moved back to the queue when it has room for them
+	spoolDrainInterval = time.Minute
+	// maxSpooledResults bounds the spool directory, results are dropped once it is full
+	maxSpooledResults  = 10000
+	spoolFileExtension = ".json"
+)
+
+type queuedEvaluationResult struct {
+	// Id names the spool file of the result
+	Id       string                             `json:"id"`
+	Request  *cliClient.EvaluationResultRequest `json:"request"`
+	Attempts int                                `json:"attempts"`
+}
+
+// EvaluationResultQueue uploads the evaluation results in the background, so the admission latency doesn't include the upload.
+// failed uploads are retried with an exponential backoff. when a spool directory is set, the results that can't be uploaded
+// or queued right now are written to it, and moved back to the queue once there is room, also after a restart
+type EvaluationResultQueue struct {
+	cliServiceClient *cliClient.CliClient
+	// spoolDir results are dropped instead of spooled when it is empty
+	spoolDir string
+	logger   *logger.Logger
+
+	results chan *queuedEvaluationResult
+	// spoolChannel hands the results that don't fit in the queue to the spooler, so the admission request doesn't wait
+	// for the spool to be written
+	spoolChannel chan *queuedEvaluationResult
+	stopChannel  chan struct{}
+	stopOnce     sync.Once
+	workers      sync.WaitGroup
+	spoolMutex   sync.Mutex
+	// spooledCount the amount of spooled results, so the spool directory isn't read on every write. guarded by spoolMutex
+	spooledCount int
+	// backoff is replaced in tests
+	backoff func(attempts int) time.Duration
+}
+
+func NewEvaluationResultQueue(cliServiceClient *cliClient.CliClient, spoolDir string, logger *logger.Logger) *EvaluationResultQueue {
+	return &EvaluationResultQueue{
+		cliServiceClient: cliServiceClient,
+		spoolDir:         spoolDir,
+		logger:           logger,
+		results:          make(chan *queuedEvaluationResult, evaluationResultQueueSize),
+		spoolChannel:     make(chan *queuedEvaluationResult, evaluationResultQueueSize),
+		stopChannel:      make(chan struct{}),
+		backoff:          exponentialUploadBackoff,
+	}
+}
+
+// Start starts the workers, and queues the results spooled before the last restart
+func (q *EvaluationResultQueue) Start() {
+	if q.spoolDir != "" {
+		if err := q.initSpool(); err != nil {
+			q.logger.LogWarn(fmt.Sprintf("evaluation result queue: spooling is disabled, err: %s", err))
+			q.spoolDir = ""
+		}
+	}
+
+	for i := 0; i < evaluationResultQueueWorkers; i++ {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			q.work()
+		}()
+	}
+
+	if q.spoolDir != "" {
+		q.workers.Add(2)
+		go func() {
+			defer q.workers.Done()
+			q.drainSpoolPeriodically()
+		}()
+		go func() {
+			defer q.workers.Done()
+			q.spoolInBackground()
+		}()
+	}
+}
+
+// initSpool the spooled results contain the token, the spool is only readable by the webhook
+func (q *EvaluationResultQueue) initSpool() error {
+	if err := os.MkdirAll(q.spoolDir, 0o700); err != nil {
+		return err
+	}
+
+	q.spoolMutex.Lock()
+	defer q.spoolMutex.Unlock()
+	spooledFileNames, err := q.getSpooledFileNames()
+	if err != nil {
+		return err
+	}
+	q.spooledCount = len(spooledFileNames)
+	return nil
+}
+
+// Enqueue never blocks, the result is spooled, or dropped, when the queue is full
+func (q *EvaluationResultQueue) Enqueue(request *cliClient.EvaluationResultRequest) {
+	q.enqueue(&queuedEvaluationResult{Id: uuid.NewString(), Request: request})
+}
+
+// Len returns the amount of results waiting to be uploaded, not including the spooled results
+func (q *EvaluationResultQueue) Len() int {
+	return len(q.results)
+}
+
+// Shutdown stops the workers once their current upload is done, or ctx is done, and spools the results that are still queued
+func (q *EvaluationResultQueue) Shutdown(ctx context.Context) {
+	q.stopOnce.Do(func() {
+		close(q.stopChannel)
+	})
+
+	workersDone := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+	}
+
+	for {
+		select {
+		case result := <-q.spoolChannel:
+			q.spool(result)
+		case result := <-q.results:
+			q.spool(result)
+		default:
+			return
+		}
+	}
+}
+
+func (q *EvaluationResultQueue) enqueue(result *queuedEvaluationResult) {
+	select {
+	case q.results <- result:
+		return
+	default:
+	}
+
+	if q.spoolDir == "" {
+		q.spool(result)
+		return
+	}
+	select {
+	case q.spoolChannel <- result:
+	default:
+		q.logger.LogWarn(fmt.Sprintf("evaluation result queue: the result of policy %s was dropped, the spooler is full", result.Request.PolicyName))
+	}
+}
+
+func (q *EvaluationResultQueue) spoolInBackground() {
+	for {
+		select {
+		case <-q.stopChannel:
+			return
+		case result := <-q.spoolChannel:
+			q.spool(result)
+		}
+	}
+}
+
+func (q *EvaluationResultQueue) work() {
+	for {
+		select {
+		case <-q.stopChannel:
+			return
+		case result := <-q.results:
+			q.upload(result)
+		}
+	}
+}
+
+// upload retries until the result is uploaded or runs out of attempts, it gives up early and spools the result on shutdown
+func (q *EvaluationResultQueue) upload(result *queuedEvaluationResult) {
+	for {
+		err := q.send(result.Request)
+		if err == nil {
+			return
+		}
+		result.Attempts++
+		if result.Attempts >= maxEvaluationResultAttempts {
+			q.logger.LogWarn(fmt.Sprintf("evaluation result queue: uploading the result of policy %s failed %d times, err: %s", result.Request.PolicyName, result.Attempts, err))
+			// a spooled result gets another round of attempts once it is moved back to the queue
+			result.Attempts = 0
+			q.spool(result)
+			return
+		}
+
+		select {
+		case <-time.After(q.backoff(result.Attempts)):
+		case <-q.stopChannel:
+			q.spool(result)
+			return
+		}
+	}
+}
+
+// send every attempt is bounded by the timeout of the client, a hanging backend counts as a failure of the backend
+func (q *EvaluationResultQueue) send(request *cliClient.EvaluationResultRequest) error {
+	_, err := q.cliServiceClient.SendWebhookEvaluationResult(request)
+	return err
+}
+
+func exponentialUploadBackoff(attempts int) time.Duration {
+	backoff := initialUploadBackoff << (attempts - 1)
+	if backoff <= 0 || backoff > maxUploadBackoff {
+		return maxUploadBackoff
+	}
+	return backoff
+}
+
+func (q *EvaluationResultQueue) spool(result *queuedEvaluationResult) {
+	if err := q.writeToSpool(result); err != nil {
+		q.logger.LogWarn(fmt.Sprintf("evaluation result queue: the result of policy %s was dropped, err: %s", result.Request.PolicyName, err))
+	}
+}
+
+func (q *EvaluationResultQueue) writeToSpool(result *queuedEvaluationResult) error {
+	if q.spoolDir == "" {
+		return errors.New("spooling is disabled")
+	}
+
+	q.spoolMutex.Lock()
+	defer q.spoolMutex.Unlock()
+
+	if q.spooledCount >= maxSpooledResults {
+		return fmt.Errorf("the spool already holds %d results", q.spooledCount)
+	}
+
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	// written to a temporary file first, a partially written result is never read back
+	spoolFilePath := filepath.Join(q.spoolDir, result.Id+spoolFileExtension)
+	if err := os.WriteFile(spoolFilePath+".tmp", resultJson, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(spoolFilePath+".tmp", spoolFilePath); err != nil {
+		return err
+	}
+	q.spooledCount++
+	return nil
+}
+
+func (q *EvaluationResultQueue) drainSpoolPeriodically() {
+	q.drainSpool()
+
+	ticker := time.NewTicker(spoolDrainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			q.drainSpool()
+		case <-q.stopChannel:
+			return
+		}
+	}
+}
+
+// drainSpool moves spooled results back to the queue while it is less than half full,
+// so the spool doesn't take the room of the results of new requests
+func (q *EvaluationResultQueue) drainSpool() {
+	q.spoolMutex.Lock()
+	defer q.spoolMutex.Unlock()
+
+	spooledFileNames, err := q.getSpooledFileNames()
+	if err != nil {
+		q.logger.LogWarn(fmt.Sprintf("evaluation result queue: failed to read the spool, err: %s", err))
+		return
+	}
+
+	for _, spooledFileName := range spooledFileNames {
+		if len(q.results) >= cap(q.results)/2 {
+			return
+		}
+
+		spoolFilePath := filepath.Join(q.spoolDir, spooledFileName)
+		resultJson, err := os.ReadFile(spoolFilePath)
+		if err != nil {
+			q.logger.LogWarn(fmt.Sprintf("evaluation result queue: failed to read %s, err: %s", spooledFileName, err))
+			continue
+		}
+
+		result := &queuedEvaluationResult{}
+		if err := json.Unmarshal(resultJson, result); err != nil || result.Id == "" || result.Request == nil {
+			q.logger.LogWarn(fmt.Sprintf("evaluation result queue: dropped the invalid spooled result %s", spooledFileName))
+			q.removeSpoolFile(spoolFilePath)
+			continue
+		}
+		select {
+		case q.results <- result:
+			q.removeSpoolFile(spoolFilePath)
+		default:
+			// filled by new requests in the meantime, the result stays spooled until the next drain
+			return
+		}
+	}
+}
+
+// removeSpoolFile is called with spoolMutex held
+func (q *EvaluationResultQueue) removeSpoolFile(spoolFilePath string) {
+	if err := os.Remove(spoolFilePath); err != nil {
+		q.logger.LogWarn(fmt.Sprintf("evaluation result queue: failed to remove %s, err: %s", filepath.Base(spoolFilePath), err))
+		return
+	}
+	q.spooledCount--
+}
+
+func (q *EvaluationResultQueue) getSpooledFileNames() ([]string, error) {
+	entries, err := os.ReadDir(q.spoolDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var spooledFileNames []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), spoolFileExtension) {
+			spooledFileNames = append(spooledFileNames, entry.Name())
+		}
+	}
+	return spooledFileNames, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/datree/pkg/httpClient"
+	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zapcore"
+)
+
+func mockEvaluationResultQueue(mockedHttpClient *mockHttpClient, spoolDir string) *EvaluationResultQueue {
+	mockedCliServiceClient := cliClient.NewCustomCliServiceClient("", mockedHttpClient, nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string))
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	evaluationResultQueue := NewEvaluationResultQueue(mockedCliServiceClient, spoolDir, &mockLogger)
+	evaluationResultQueue.backoff = func(int) time.Duration { return 0 }
+	return evaluationResultQueue
+}
+
+func mockEvaluationResultRequest(metadataName string) *cliClient.EvaluationResultRequest {
+	return &cliClient.EvaluationResultRequest{PolicyName: "Default", MetadataName: metadataName}
+}
+
+// countRequests the mock calls can't be read while the workers are sending
+func countRequests(requestsCount *atomic.Int32) func(mock.Arguments) {
+	return func(mock.Arguments) {
+		requestsCount.Add(1)
+	}
+}
+
+func getSpoolFileNames(t *testing.T, spoolDir string) []string {
+	entries, err := os.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	var fileNames []string
+	for _, entry := range entries {
+		fileNames = append(fileNames, entry.Name())
+	}
+	return fileNames
+}
+
+func TestEvaluationResultQueue(t *testing.T) {
+	uploadedBody := []byte(`{"evaluationId": 1}`)
+
+	t.Run("should retry a failed upload", func(t *testing.T) {
+		var requestsCount atomic.Int32
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusInternalServerError}, errors.New("backend unavailable")).Run(countRequests(&requestsCount)).Twice()
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: uploadedBody}, nil).Run(countRequests(&requestsCount)).Once()
+		evaluationResultQueue := mockEvaluationResultQueue(mockedHttpClient, "")
+		evaluationResultQueue.Start()
+		defer evaluationResultQueue.Shutdown(context.Background())
+
+		evaluationResultQueue.Enqueue(mockEvaluationResultRequest("my-deployment"))
+
+		assert.Eventually(t, func() bool {
+			return requestsCount.Load() == 3
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should spool a result that failed every attempt, and upload it after a restart", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		var failedRequestsCount atomic.Int32
+		failingHttpClient := &mockHttpClient{}
+		failingHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusInternalServerError}, errors.New("backend unavailable")).Run(countRequests(&failedRequestsCount))
+		evaluationResultQueue := mockEvaluationResultQueue(failingHttpClient, spoolDir)
+		evaluationResultQueue.Start()
+
+		evaluationResultQueue.Enqueue(mockEvaluationResultRequest("my-deployment"))
+		assert.Eventually(t, func() bool {
+			return len(getSpoolFileNames(t, spoolDir)) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Regexp(t, `^[0-9a-f-]{36}\.json$`, getSpoolFileNames(t, spoolDir)[0])
+		assert.Equal(t, int32(maxEvaluationResultAttempts), failedRequestsCount.Load())
+		evaluationResultQueue.Shutdown(context.Background())
+
+		var requestsCount atomic.Int32
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: uploadedBody}, nil).Run(countRequests(&requestsCount)).Once()
+		restartedEvaluationResultQueue := mockEvaluationResultQueue(mockedHttpClient, spoolDir)
+		restartedEvaluationResultQueue.Start()
+		defer restartedEvaluationResultQueue.Shutdown(context.Background())
+
+		assert.Eventually(t, func() bool {
+			return requestsCount.Load() == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Empty(t, getSpoolFileNames(t, spoolDir))
+	})
+
+	t.Run("should spool the queued results on shutdown", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		evaluationResultQueue := mockEvaluationResultQueue(&mockHttpClient{}, spoolDir)
+
+		evaluationResultQueue.Enqueue(mockEvaluationResultRequest("first-deployment"))
+		evaluationResultQueue.Enqueue(mockEvaluationResultRequest("second-deployment"))
+		evaluationResultQueue.Shutdown(context.Background())
+
+		spoolFileNames := getSpoolFileNames(t, spoolDir)
+		assert.Len(t, spoolFileNames, 2)
+		for _, spoolFileName := range spoolFileNames {
+			assert.Equal(t, spoolFileExtension, filepath.Ext(spoolFileName))
+		}
+	})
+
+	t.Run("should spool the results that don't fit in the queue in the background, readable only by the webhook", func(t *testing.T) {
+		spoolDir := filepath.Join(t.TempDir(), "spool")
+		releaseUploads := make(chan struct{})
+		hangingHttpClient := &mockHttpClient{}
+		hangingHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: uploadedBody}, nil).Run(func(mock.Arguments) {
+			<-releaseUploads
+		})
+		evaluationResultQueue := mockEvaluationResultQueue(hangingHttpClient, spoolDir)
+		evaluationResultQueue.Start()
+		defer evaluationResultQueue.Shutdown(context.Background())
+		defer close(releaseUploads)
+
+		for i := 0; i < evaluationResultQueueSize+evaluationResultQueueWorkers+2; i++ {
+			evaluationResultQueue.Enqueue(mockEvaluationResultRequest("my-deployment"))
+		}
+
+		assert.Eventually(t, func() bool {
+			return len(getSpoolFileNames(t, spoolDir)) >= 2
+		}, 5*time.Second, 10*time.Millisecond)
+		spoolDirInfo, err := os.Stat(spoolDir)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o700), spoolDirInfo.Mode().Perm())
+		for _, spoolFileName := range getSpoolFileNames(t, spoolDir) {
+			spoolFileInfo, err := os.Stat(filepath.Join(spoolDir, spoolFileName))
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o600), spoolFileInfo.Mode().Perm())
+		}
+	})
+
+	t.Run("should drop a result once the spool is full", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		evaluationResultQueue := mockEvaluationResultQueue(&mockHttpClient{}, spoolDir)
+		evaluationResultQueue.spooledCount = maxSpooledResults
+
+		err := evaluationResultQueue.writeToSpool(&queuedEvaluationResult{Id: "my-result", Request: mockEvaluationResultRequest("my-deployment")})
+
+		assert.ErrorContains(t, err, "the spool already holds")
+		assert.Empty(t, getSpoolFileNames(t, spoolDir))
+	})
+}
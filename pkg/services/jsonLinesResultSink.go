@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+)
+
+// jsonLinesResultSink writes every record as a json line, used by the file and stdout sinks.
+// the writes are small and local, so they are made on the admission path
+type jsonLinesResultSink struct {
+	mutex sync.Mutex
+	// openWriter opens the writer of every write, so a rotated file is recreated
+	openWriter func() (io.WriteCloser, error)
+}
+
+func newFileResultSink(path string) *jsonLinesResultSink {
+	return &jsonLinesResultSink{
+		openWriter: func() (io.WriteCloser, error) {
+			return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		},
+	}
+}
+
+func newStdoutResultSink() *jsonLinesResultSink {
+	return &jsonLinesResultSink{
+		openWriter: func() (io.WriteCloser, error) {
+			return nopWriteCloser{Writer: os.Stdout}, nil
+		},
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (s *jsonLinesResultSink) SendEvaluationResult(result *EvaluationResult) error {
+	return s.write([]SinkRecord{newEvaluationResultRecord(result)})
+}
+
+func (s *jsonLinesResultSink) SendRequestMetadataBatch(requestMetadataBatch []*cliClient.ClusterRequestMetadata) error {
+	records := make([]SinkRecord, 0, len(requestMetadataBatch))
+	for _, requestMetadata := range requestMetadataBatch {
+		records = append(records, newRequestMetadataRecord(requestMetadata))
+	}
+	return s.write(records)
+}
+
+func (s *jsonLinesResultSink) Shutdown(context.Context) {}
+
+func (s *jsonLinesResultSink) write(records []SinkRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var jsonLines []byte
+	for _, record := range records {
+		recordJson, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		jsonLines = append(append(jsonLines, recordJson...), '\n')
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	writer, err := s.openWriter()
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	_, err = writer.Write(jsonLines)
+	return err
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/errorReporter"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/admission-webhook-datree/pkg/namespaceLabels"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+
+	policyFactory "github.com/datreeio/datree/bl/policy"
+	baseCliClient "github.com/datreeio/datree/pkg/cliClient"
+	"github.com/datreeio/datree/pkg/evaluation"
+	admission "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/strings/slices"
+)
+
+type MutationService struct {
+	CliServiceClient   *cliClient.CliClient
+	ErrorReporter      *errorReporter.ErrorReporter
+	State              *servicestate.ServiceState
+	PrerunDataProvider PrerunDataProvider
+	// NamespaceLabels is nil when the namespaces can't be watched
+	NamespaceLabels *namespaceLabels.Cache
+	// ValidationService resolves the exceptions and the bypass permissions of the resource the way it is validated,
+	// a resource isn't fixed for the rules it may fail
+	ValidationService *ValidationService
+	Logger            *logger.Logger
+}
+
+// Mutate evaluates the resource against the active policies and returns a JSONPatch that fixes the failed rules that can be auto-fixed.
+// the results are not sent to the backend here, the validating webhook evaluates the patched resource right after and records it
+func (ms *MutationService) Mutate(ctx context.Context, admissionReviewReq *admission.AdmissionReview, warningMessages *[]string, requestLogger *logger.Logger) (admissionReview *admission.AdmissionReview, isSkipped bool) {
+	rootObject := getResourceRootObject(admissionReviewReq)
+	namespace, resourceKind, resourceName, _ := getResourceMetadata(admissionReviewReq, rootObject)
+
+	if _, isKindWithPodSpec := getPodSpecPath(resourceKind); !isKindWithPodSpec {
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), true
+	}
+	// only created resources are fixed, the pod templates of pods and jobs are immutable so an update that patches their
+	// containers would be rejected by the API server
+	if admissionReviewReq.Request.Operation != admission.Create {
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), true
+	}
+
+	shouldValidatedResourceData := ShouldResourceBeValidated(admissionReviewReq, rootObject, requestLogger)
+	if !shouldValidatedResourceData.ShouldValidate {
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), true
+	}
+
+	prerunData, err := ms.PrerunDataProvider.GetPrerunData(ctx)
+	if err != nil {
+		requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("Getting prerun data err: %s", err.Error()))
+		*warningMessages = append(*warningMessages, "Datree failed to auto-fix the resource - an error occurred when pulling your policy")
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), true
+	}
+
+	config := applyPrerunDataConfig(ms.State, ms.State.GetConfig(), prerunData, requestLogger)
+
+	if ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject, config.SkipList, requestLogger) {
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), true
+	}
+
+	if ms.ValidationService.shouldBypassByPermissions(requestLogger, config.BypassPermissions, admissionReviewReq.Request.UserInfo, shouldValidatedResourceData.OpenShiftRequester) {
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), true
+	}
+
+	defaultRules := getDefaultRules(prerunData)
+	filesConfigurations := getFileConfiguration(admissionReviewReq.Request, admissionReviewReq.Request.Object.Raw)
+	// the warnings of the exceptions are returned by the validating webhook
+	ruleExceptions, _ := ms.ValidationService.getResourceExceptions(requestLogger, config, admissionReviewReq, rootObject, shouldValidatedResourceData.OpenShiftRequester, false)
+	applyRuleExceptions(filesConfigurations, ruleExceptions)
+	evaluator := getEvaluator()
+	autoFix := config.AutoFix
+
+	var rulesToFix []string
+	policyScope := policyScopeResource{Kind: resourceKind, Labels: rootObject.Metadata.Labels, Operation: string(admissionReviewReq.Request.Operation)}
+	getNamespaceLabels := namespaceLabelsGetter(ctx, ms.NamespaceLabels, namespace, requestLogger)
+	for _, policyName := range prerunData.ActivePolicies {
+		if !shouldPolicyRunForResource(config.MultiplePolicies, policyName, policyScope) || !shouldPolicyRunForNamespace(config.MultiplePolicies, policyName, namespace, getNamespaceLabels) {
+			continue
+		}
+
+		policy, err := policyFactory.CreatePolicy(prerunData.PoliciesJson, policyName, prerunData.RegistrationURL, defaultRules, false)
+		if err != nil {
+			continue
+		}
+
+		policyCheckResults, err := evaluator.Evaluate(evaluation.PolicyCheckData{
+			FilesConfigurations: filesConfigurations,
+			IsInteractiveMode:   false,
+			PolicyName:          policy.Name,
+			Policy:              policy,
+		})
+		if err != nil {
+			requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("Evaluate err: %s", err.Error()))
+			continue
+		}
+
+		for _, ruleIdentifier := range getFailedRuleIdentifiers(policyCheckResults.RawResults) {
+			if isAutoFixableRule(ruleIdentifier, autoFix) && !slices.Contains(rulesToFix, ruleIdentifier) {
+				rulesToFix = append(rulesToFix, ruleIdentifier)
+			}
+		}
+	}
+
+	if len(rulesToFix) == 0 {
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), false
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(admissionReviewReq.Request.Object.Raw, &object); err != nil {
+		panic(err)
+	}
+
+	patchOperations, fixedRules := applyAutoFixRules(object, resourceKind, rulesToFix, getAutoFixDefaults(autoFix))
+	if len(patchOperations) == 0 {
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), false
+	}
+
+	patch, err := json.Marshal(patchOperations)
+	if err != nil {
+		requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("JSONPatch marshal err: %s", err.Error()))
+		return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, nil, *warningMessages), false
+	}
+
+	*warningMessages = append([]string{
+		fmt.Sprintf("🔧 Object with name \"%s\" and kind \"%s\" was auto-fixed by Datree for rules: %s", resourceName, resourceKind, strings.Join(fixedRules, ", ")),
+	}, *warningMessages...)
+
+	return ParseMutationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, patch, *warningMessages), false
+}
+
+// getFailedRuleIdentifiers returns the identifiers of the rules that failed and were not skipped by a skip annotation
+func getFailedRuleIdentifiers(failedRulesByFiles evaluation.FailedRulesByFiles) []string {
+	var failedRuleIdentifiers []string
+	for _, failedRulesByIdentifier := range failedRulesByFiles {
+		for ruleIdentifier, failedRule := range failedRulesByIdentifier {
+			if isFailedRuleSkipped(failedRule) || slices.Contains(failedRuleIdentifiers, ruleIdentifier) {
+				continue
+			}
+			failedRuleIdentifiers = append(failedRuleIdentifiers, ruleIdentifier)
+		}
+	}
+	return failedRuleIdentifiers
+}
+
+// getFailedRuleNames returns the names of the rules that failed and were not skipped by a skip annotation
+func getFailedRuleNames(failedRulesByFiles evaluation.FailedRulesByFiles) []string {
+	var failedRuleNames []string
+	for _, failedRulesByIdentifier := range failedRulesByFiles {
+		for _, failedRule := range failedRulesByIdentifier {
+			if isFailedRuleSkipped(failedRule) || slices.Contains(failedRuleNames, failedRule.Name) {
+				continue
+			}
+			failedRuleNames = append(failedRuleNames, failedRule.Name)
+		}
+	}
+	sort.Strings(failedRuleNames)
+	return failedRuleNames
+}
+
+func isFailedRuleSkipped(failedRule *baseCliClient.FailedRule) bool {
+	for _, configuration := range failedRule.Configurations {
+		if !configuration.IsSkipped {
+			return false
+		}
+	}
+	return true
+}
+
+func ParseMutationResponseIntoAdmissionReview(requestUID k8sTypes.UID, patch []byte, warningMessages []string) *admission.AdmissionReview {
+	admissionResponse := &admission.AdmissionResponse{
+		UID:      requestUID,
+		Allowed:  true,
+		Warnings: warningMessages,
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+
+	if patch != nil {
+		patchType := admission.PatchTypeJSONPatch
+		admissionResponse.Patch = patch
+		admissionResponse.PatchType = &patchType
+	}
+
+	return &admission.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1",
+		},
+		Response: admissionResponse,
+	}
+}
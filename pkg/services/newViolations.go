@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/datreeio/datree/pkg/evaluation"
+	"github.com/datreeio/datree/pkg/extractor"
+	"k8s.io/utils/strings/slices"
+)
+
+// violations the failed rules of an update, split by whether they already failed on the old object
+type violations struct {
+	newRuleNames         []string
+	preExistingRuleNames []string
+}
+
+// hasOnlyPreExisting returns true when the update doesn't introduce any violation
+func (v *violations) hasOnlyPreExisting() bool {
+	return v != nil && len(v.newRuleNames) == 0 && len(v.preExistingRuleNames) > 0
+}
+
+// getViolations evaluates the old object against the policy, and splits the failed rules of the new object by whether they
+// already failed on the old one. nil when the old object failed to be evaluated, every violation is then treated as new
+func getViolations(evaluator *evaluation.Evaluator, policyCheckData evaluation.PolicyCheckData, oldFilesConfigurations []*extractor.FileConfigurations,
+	failedRulesByFiles evaluation.FailedRulesByFiles) (*violations, error) {
+	policyCheckData.FilesConfigurations = oldFilesConfigurations
+	oldPolicyCheckResults, err := evaluator.Evaluate(policyCheckData)
+	if err != nil {
+		return nil, err
+	}
+	oldFailedRuleIdentifiers := getFailedRuleIdentifiers(oldPolicyCheckResults.RawResults)
+
+	result := &violations{}
+	for _, failedRulesByIdentifier := range failedRulesByFiles {
+		for ruleIdentifier, failedRule := range failedRulesByIdentifier {
+			if isFailedRuleSkipped(failedRule) {
+				continue
+			}
+			if slices.Contains(oldFailedRuleIdentifiers, ruleIdentifier) {
+				result.preExistingRuleNames = appendUnique(result.preExistingRuleNames, failedRule.Name)
+			} else {
+				result.newRuleNames = appendUnique(result.newRuleNames, failedRule.Name)
+			}
+		}
+	}
+	sort.Strings(result.newRuleNames)
+	sort.Strings(result.preExistingRuleNames)
+	return result, nil
+}
+
+func appendUnique(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+func getNewViolationsMessage(policyName string, newRuleNames []string) string {
+	return fmt.Sprintf("🆕 This update introduces new violations of policy \"%s\": %s", policyName, strings.Join(newRuleNames, ", "))
+}
+
+func getPreExistingViolationsWarning(resourceName string, policyName string, preExistingRuleNames []string) string {
+	return fmt.Sprintf("⚠️ Object with name \"%s\" already violated policy \"%s\" before this update, these violations don't block it: %s",
+		resourceName, policyName, strings.Join(preExistingRuleNames, ", "))
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	admission "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func mockNewViolationsValidationService(t *testing.T, newViolationsOnly *servicestate.NewViolationsOnly) *ValidationService {
+	// the built-in policies are used
+	policiesDir := t.TempDir()
+	t.Setenv(enums.OfflinePoliciesDir, policiesDir)
+	t.Setenv(enums.Enforce, "true")
+	t.Setenv(enums.NoRecord, "true")
+
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	state := servicestate.New()
+	state.UpdateConfig(func(config servicestate.Config) servicestate.Config {
+		config.NewViolationsOnly = newViolationsOnly
+		return config
+	})
+	return &ValidationService{
+		CliServiceClient:   cliClient.NewCustomCliServiceClient("", nil, nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string)),
+		State:              state,
+		PrerunDataProvider: NewLocalPrerunDataProvider(policiesDir),
+		Logger:             &mockLogger,
+	}
+}
+
+func mockUpdateAdmissionReview(t *testing.T, oldImage string, newImage string) *admission.AdmissionReview {
+	toJson := func(image string) []byte {
+		deployment := mockAuditDeployment("my-deployment", "my-namespace")
+		deployment.Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"] =
+			[]interface{}{map[string]interface{}{"name": "app", "image": image}}
+		objectJson, err := json.Marshal(deployment.Object)
+		assert.NoError(t, err)
+		return objectJson
+	}
+
+	return &admission.AdmissionReview{
+		Request: &admission.AdmissionRequest{
+			UID:       "request-uid",
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			Name:      "my-deployment",
+			Namespace: "my-namespace",
+			Operation: admission.Update,
+			UserInfo:  authenticationv1.UserInfo{Username: "my-user"},
+			Object:    runtime.RawExtension{Raw: toJson(newImage)},
+			OldObject: runtime.RawExtension{Raw: toJson(oldImage)},
+		},
+	}
+}
+
+func TestNewViolationsOnly(t *testing.T) {
+	t.Run("should allow an update that only has the violations of the old object, and warn about them", func(t *testing.T) {
+		validationService := mockNewViolationsValidationService(t, &servicestate.NewViolationsOnly{Enabled: true})
+		var warningMessages []string
+
+		admissionReview, _ := validationService.Validate(context.Background(), mockUpdateAdmissionReview(t, "nginx:1.25", "nginx:1.26"), &warningMessages, validationService.Logger)
+
+		assert.True(t, admissionReview.Response.Allowed)
+		assert.Contains(t, warningMessages[0], "already violated policy")
+	})
+
+	t.Run("should deny an update that introduces a violation, and say which violations are new", func(t *testing.T) {
+		validationService := mockNewViolationsValidationService(t, &servicestate.NewViolationsOnly{Enabled: true})
+		var warningMessages []string
+
+		admissionReview, _ := validationService.Validate(context.Background(), mockUpdateAdmissionReview(t, "nginx:1.25", "nginx"), &warningMessages, validationService.Logger)
+
+		assert.False(t, admissionReview.Response.Allowed)
+		assert.Contains(t, admissionReview.Response.Result.Message, "This update introduces new violations")
+		assert.Contains(t, warningMessages[0], "already violated policy")
+	})
+
+	t.Run("should deny an update with only pre-existing violations when the namespace doesn't match", func(t *testing.T) {
+		validationService := mockNewViolationsValidationService(t, &servicestate.NewViolationsOnly{Enabled: true, NamespacePatterns: []string{"^prod-"}})
+		var warningMessages []string
+
+		admissionReview, _ := validationService.Validate(context.Background(), mockUpdateAdmissionReview(t, "nginx:1.25", "nginx:1.26"), &warningMessages, validationService.Logger)
+
+		assert.False(t, admissionReview.Response.Allowed)
+		assert.NotContains(t, admissionReview.Response.Result.Message, "This update introduces new violations")
+	})
+}
+
+func TestNewViolationsOnlyIsEnabled(t *testing.T) {
+	var disabled *servicestate.NewViolationsOnly
+	assert.False(t, disabled.IsEnabled("my-namespace"))
+	assert.False(t, (&servicestate.NewViolationsOnly{NamespacePatterns: []string{".*"}}).IsEnabled("my-namespace"))
+	assert.True(t, (&servicestate.NewViolationsOnly{Enabled: true}).IsEnabled("my-namespace"))
+	assert.True(t, (&servicestate.NewViolationsOnly{Enabled: true, NamespacePatterns: []string{"^prod-"}}).IsEnabled("prod-1"))
+	assert.False(t, (&servicestate.NewViolationsOnly{Enabled: true, NamespacePatterns: []string{"^prod-"}}).IsEnabled("dev-1"))
+}
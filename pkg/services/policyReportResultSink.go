@@ -0,0 +1,528 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	baseCliClient "github.com/datreeio/datree/pkg/cliClient"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	policyReportApiVersion = "wgpolicyk8s.io/v1alpha2"
+	policyReportSource     = "datree"
+	// policyReportName the name of the PolicyReport of every namespace, and of the ClusterPolicyReport
+	policyReportName = "datree"
+	// policyReportTimeout bounds the update of a single report, including its retries on conflicts
+	policyReportTimeout       = 30 * time.Second
+	policyReportFlushInterval = 10 * time.Second
+	policyReportPruneInterval = 10 * time.Minute
+	// policyReportPruneGracePeriod a resource is evaluated before it's created, so its results have no uid yet. they are only
+	// pruned when the resource still doesn't exist after this period, which keeps the denied resources visible for a while
+	policyReportPruneGracePeriod = time.Hour
+	// maxPendingPolicyReportResults bounds the evaluations waiting for the next flush, the newer ones are dropped
+	maxPendingPolicyReportResults = 1000
+	// maxPolicyReportResults keeps a report well below the object size limit, the oldest results are dropped first
+	maxPolicyReportResults = 2000
+)
+
+var (
+	policyReportResource        = schema.GroupVersionResource{Group: "wgpolicyk8s.io", Version: "v1alpha2", Resource: "policyreports"}
+	clusterPolicyReportResource = schema.GroupVersionResource{Group: "wgpolicyk8s.io", Version: "v1alpha2", Resource: "clusterpolicyreports"}
+	policyReportLabels          = map[string]string{"app.kubernetes.io/managed-by": "datree"}
+)
+
+// policyReportResultSink keeps a PolicyReport per namespace, and a ClusterPolicyReport for the cluster scoped resources,
+// so the results are visible to the tools that read the wg-policy PolicyReport CRDs.
+// a report holds the latest results of every evaluated resource per policy, keyed by the resource uid. the results are
+// written in batches by every replica, and the leader prunes the results of the resources that were deleted or recreated
+type policyReportResultSink struct {
+	dynamicClient dynamic.Interface
+	// metadataClient and restMapper are nil when the results aren't pruned
+	metadataClient metadata.Interface
+	restMapper     *restmapper.DeferredDiscoveryRESTMapper
+	leaderElection *leaderElection.LeaderElection
+	logger         *logger.Logger
+
+	mutex sync.Mutex
+	// pending the results since the last flush by namespace, the cluster scoped resources are under ""
+	pending      map[string][]*pendingPolicyReportResults
+	pendingCount int
+
+	stopChannel chan struct{}
+	stopOnce    sync.Once
+	done        chan struct{}
+}
+
+// policyReportResourceRef the resource of a result, the uid is empty when the resource was evaluated on its creation
+type policyReportResourceRef struct {
+	ApiVersion string
+	Kind       string
+	Name       string
+	Namespace  string
+	Uid        k8sTypes.UID
+}
+
+// key identifies the resource regardless of its uid, the latest evaluation of a name replaces the previous ones
+func (r policyReportResourceRef) key() string {
+	gv, _ := schema.ParseGroupVersion(r.ApiVersion)
+	return strings.Join([]string{gv.Group, r.Kind, r.Namespace, r.Name}, "/")
+}
+
+type pendingPolicyReportResults struct {
+	resource   policyReportResourceRef
+	policyName string
+	results    []interface{}
+}
+
+// newPolicyReportResultSink discoveryClient and metadataClient may be nil, the results of the deleted resources are then never pruned
+func newPolicyReportResultSink(dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface, metadataClient metadata.Interface, leaderElection *leaderElection.LeaderElection, logger *logger.Logger) *policyReportResultSink {
+	sink := &policyReportResultSink{
+		dynamicClient:  dynamicClient,
+		leaderElection: leaderElection,
+		logger:         logger,
+		pending:        map[string][]*pendingPolicyReportResults{},
+		stopChannel:    make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	if discoveryClient != nil && metadataClient != nil {
+		sink.metadataClient = metadataClient
+		sink.restMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	}
+	go sink.work()
+	return sink
+}
+
+func (s *policyReportResultSink) SendEvaluationResult(result *EvaluationResult) error {
+	// an object created with generateName has no name yet, it can't be reported
+	if result.MetadataName == "" {
+		return nil
+	}
+	// a dry-run request doesn't change the resource, its results would replace the results of the persisted resource
+	// and linger until they are pruned
+	if result.DryRun {
+		return nil
+	}
+
+	pendingResults := &pendingPolicyReportResults{
+		resource: policyReportResourceRef{
+			ApiVersion: result.ApiVersion,
+			Kind:       result.Kind,
+			Name:       result.MetadataName,
+			Namespace:  result.Namespace,
+			Uid:        result.ResourceUid,
+		},
+		policyName: result.PolicyName,
+	}
+	pendingResults.results = getPolicyReportResults(result, pendingResults.resource)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	namespacePending := s.pending[result.Namespace]
+	for i, existing := range namespacePending {
+		if existing.policyName == pendingResults.policyName && existing.resource.key() == pendingResults.resource.key() {
+			namespacePending[i] = pendingResults
+			return nil
+		}
+	}
+	if s.pendingCount >= maxPendingPolicyReportResults {
+		s.logger.LogWarn(fmt.Sprintf("result sinks: too many results are waiting for the policy reports, the results of %s %s were dropped", result.Kind, result.MetadataName))
+		return nil
+	}
+	s.pending[result.Namespace] = append(namespacePending, pendingResults)
+	s.pendingCount++
+	return nil
+}
+
+// SendRequestMetadataBatch the request metadata isn't reported
+func (s *policyReportResultSink) SendRequestMetadataBatch([]*cliClient.ClusterRequestMetadata) error {
+	return nil
+}
+
+// Shutdown flushes the pending results, the ones that weren't written by the time ctx is done are dropped
+func (s *policyReportResultSink) Shutdown(ctx context.Context) {
+	s.stopOnce.Do(func() {
+		close(s.stopChannel)
+	})
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+func (s *policyReportResultSink) work() {
+	defer close(s.done)
+	flushTicker := time.NewTicker(policyReportFlushInterval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(policyReportPruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-flushTicker.C:
+			s.flush()
+		case <-pruneTicker.C:
+			s.prune()
+		case <-s.stopChannel:
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *policyReportResultSink) flush() {
+	s.mutex.Lock()
+	pending := s.pending
+	s.pending = map[string][]*pendingPolicyReportResults{}
+	s.pendingCount = 0
+	s.mutex.Unlock()
+
+	for namespace, namespacePending := range pending {
+		err := s.updateReport(namespace, func(results []interface{}) ([]interface{}, bool) {
+			return mergePolicyReportResults(results, namespacePending), true
+		})
+		if err != nil {
+			s.logger.LogWarn(fmt.Sprintf("result sinks: failed to update the policy report of namespace %q, err: %s", namespace, err))
+		}
+	}
+}
+
+// prune is only done by the leader, it removes the results of the resources that no longer exist
+func (s *policyReportResultSink) prune() {
+	if s.metadataClient == nil || (s.leaderElection != nil && !s.leaderElection.IsLeader()) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyReportPruneInterval/2)
+	defer cancel()
+
+	// the kinds served by the cluster change, e.g. when a CRD is deleted
+	s.restMapper.Reset()
+
+	reports, err := s.dynamicClient.Resource(policyReportResource).Namespace("").List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(policyReportLabels).String()})
+	if err != nil {
+		s.logger.LogWarn(fmt.Sprintf("result sinks: failed to list the policy reports, err: %s", err))
+		return
+	}
+	namespaces := []string{""}
+	for _, report := range reports.Items {
+		if report.GetName() == policyReportName {
+			namespaces = append(namespaces, report.GetNamespace())
+		}
+	}
+
+	lookupResource := s.newResourceLookup(ctx)
+	now := time.Now()
+	for _, namespace := range namespaces {
+		err := s.updateReport(namespace, func(results []interface{}) ([]interface{}, bool) {
+			return prunePolicyReportResults(results, lookupResource, now)
+		})
+		if err != nil {
+			s.logger.LogWarn(fmt.Sprintf("result sinks: failed to prune the policy report of namespace %q, err: %s", namespace, err))
+		}
+	}
+}
+
+// updateReport applies update to the results of the report of the namespace, the report is created when it doesn't exist.
+// update returns false when the results weren't changed
+func (s *policyReportResultSink) updateReport(namespace string, update func(results []interface{}) ([]interface{}, bool)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), policyReportTimeout)
+	defer cancel()
+
+	reportClient := s.dynamicClient.Resource(clusterPolicyReportResource).Namespace("")
+	if namespace != "" {
+		reportClient = s.dynamicClient.Resource(policyReportResource).Namespace(namespace)
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		report, err := reportClient.Get(ctx, policyReportName, metav1.GetOptions{})
+		isNotFound := k8serrors.IsNotFound(err)
+		if isNotFound {
+			report = newPolicyReport(namespace)
+		} else if err != nil {
+			return err
+		}
+
+		existingResults, _, _ := unstructured.NestedSlice(report.Object, "results")
+		results, isChanged := update(existingResults)
+		if !isChanged || (isNotFound && len(results) == 0) {
+			return nil
+		}
+		setPolicyReportResults(report, results)
+
+		if isNotFound {
+			_, err = reportClient.Create(ctx, report, metav1.CreateOptions{})
+			return err
+		}
+		_, err = reportClient.Update(ctx, report, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// newResourceLookup returns whether the resource exists and its uid. the resources are listed once per kind and namespace
+func (s *policyReportResultSink) newResourceLookup(ctx context.Context) func(resource policyReportResourceRef) (k8sTypes.UID, bool, error) {
+	listedUids := map[string]map[string]k8sTypes.UID{}
+	listErrors := map[string]error{}
+
+	return func(resource policyReportResourceRef) (k8sTypes.UID, bool, error) {
+		gv, err := schema.ParseGroupVersion(resource.ApiVersion)
+		if err != nil {
+			return "", false, err
+		}
+		groupKind := schema.GroupKind{Group: gv.Group, Kind: resource.Kind}
+		mapping, err := s.restMapper.RESTMapping(groupKind, gv.Version)
+		if meta.IsNoMatchError(err) {
+			// the version may no longer be served, while the kind still is
+			mapping, err = s.restMapper.RESTMapping(groupKind)
+		}
+		if meta.IsNoMatchError(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+
+		namespace := ""
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			namespace = resource.Namespace
+		}
+		listKey := mapping.Resource.String() + "/" + namespace
+		if _, isListed := listedUids[listKey]; !isListed && listErrors[listKey] == nil {
+			list, err := s.metadataClient.Resource(mapping.Resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				listErrors[listKey] = err
+			} else {
+				uids := map[string]k8sTypes.UID{}
+				for _, item := range list.Items {
+					uids[item.Name] = item.UID
+				}
+				listedUids[listKey] = uids
+			}
+		}
+		if err := listErrors[listKey]; err != nil {
+			return "", false, err
+		}
+
+		uid, exists := listedUids[listKey][resource.Name]
+		return uid, exists, nil
+	}
+}
+
+func newPolicyReport(namespace string) *unstructured.Unstructured {
+	kind := "ClusterPolicyReport"
+	if namespace != "" {
+		kind = "PolicyReport"
+	}
+	report := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": policyReportApiVersion,
+		"kind":       kind,
+	}}
+	report.SetName(policyReportName)
+	report.SetNamespace(namespace)
+	report.SetLabels(policyReportLabels)
+	return report
+}
+
+// getPolicyReportResults a result per failed rule, or a single pass result when the resource passed the policy, so a report
+// of a namespace with many resources stays small
+func getPolicyReportResults(result *EvaluationResult, resource policyReportResourceRef) []interface{} {
+	timestamp := map[string]interface{}{"seconds": result.EvaluatedAt.Unix(), "nanos": int64(result.EvaluatedAt.Nanosecond())}
+	newPolicyResult := func(resultName string, message string) map[string]interface{} {
+		return map[string]interface{}{
+			"policy":    result.PolicyName,
+			"result":    resultName,
+			"message":   message,
+			"source":    policyReportSource,
+			"scored":    true,
+			"timestamp": timestamp,
+			"resources": []interface{}{newPolicyReportResourceMap(resource)},
+		}
+	}
+
+	var policyResults []interface{}
+	for _, rule := range result.AllExecutedRules {
+		failedRule := getFailedRule(result.PolicyCheckResults, rule.Identifier)
+		if failedRule == nil {
+			continue
+		}
+
+		message := failedRule.MessageOnFailure
+		if message == "" {
+			message = rule.Name
+		}
+		policyResult := newPolicyResult("fail", message)
+		if len(failedRule.Configurations) > 0 && isFailedRuleSkipped(failedRule) {
+			policyResult["result"] = "skip"
+		}
+		policyResult["rule"] = rule.Identifier
+		if failedRule.DocumentationUrl != "" {
+			policyResult["properties"] = map[string]interface{}{"documentationUrl": failedRule.DocumentationUrl}
+		}
+		policyResults = append(policyResults, policyResult)
+	}
+
+	if len(policyResults) == 0 {
+		policyResults = append(policyResults, newPolicyResult("pass", fmt.Sprintf("passed all the %d rules of the policy", len(result.AllExecutedRules))))
+	}
+	return policyResults
+}
+
+func getFailedRule(policyCheckResults map[string]map[string]*baseCliClient.FailedRule, ruleIdentifier string) *baseCliClient.FailedRule {
+	for _, failedRulesByIdentifier := range policyCheckResults {
+		if failedRule, isFailed := failedRulesByIdentifier[ruleIdentifier]; isFailed {
+			return failedRule
+		}
+	}
+	return nil
+}
+
+func newPolicyReportResourceMap(resource policyReportResourceRef) map[string]interface{} {
+	resourceMap := map[string]interface{}{
+		"apiVersion": resource.ApiVersion,
+		"kind":       resource.Kind,
+		"name":       resource.Name,
+	}
+	if resource.Namespace != "" {
+		resourceMap["namespace"] = resource.Namespace
+	}
+	if resource.Uid != "" {
+		resourceMap["uid"] = string(resource.Uid)
+	}
+	return resourceMap
+}
+
+// getPolicyReportResultResource the resource of a result written by this sink, false for any other result
+func getPolicyReportResultResource(result interface{}) (map[string]interface{}, policyReportResourceRef, bool) {
+	resultMap, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, policyReportResourceRef{}, false
+	}
+	resources, ok := resultMap["resources"].([]interface{})
+	if !ok || len(resources) != 1 {
+		return nil, policyReportResourceRef{}, false
+	}
+	resourceMap, ok := resources[0].(map[string]interface{})
+	if !ok {
+		return nil, policyReportResourceRef{}, false
+	}
+
+	getString := func(key string) string {
+		value, _ := resourceMap[key].(string)
+		return value
+	}
+	return resourceMap, policyReportResourceRef{
+		ApiVersion: getString("apiVersion"),
+		Kind:       getString("kind"),
+		Name:       getString("name"),
+		Namespace:  getString("namespace"),
+		Uid:        k8sTypes.UID(getString("uid")),
+	}, true
+}
+
+// mergePolicyReportResults replaces the results of every pending resource and policy, the other results are kept
+func mergePolicyReportResults(results []interface{}, pending []*pendingPolicyReportResults) []interface{} {
+	isReplaced := map[string]bool{}
+	for _, pendingResults := range pending {
+		isReplaced[pendingResults.resource.key()+"/"+pendingResults.policyName] = true
+	}
+
+	mergedResults := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		_, resource, ok := getPolicyReportResultResource(result)
+		if ok && isReplaced[resource.key()+"/"+fmt.Sprint(result.(map[string]interface{})["policy"])] {
+			continue
+		}
+		mergedResults = append(mergedResults, result)
+	}
+	for _, pendingResults := range pending {
+		mergedResults = append(mergedResults, pendingResults.results...)
+	}
+	return mergedResults
+}
+
+// prunePolicyReportResults removes the results of the resources that were deleted, or recreated without being evaluated,
+// and sets the uid of the results of the resources that were evaluated on their creation
+func prunePolicyReportResults(results []interface{}, lookupResource func(resource policyReportResourceRef) (k8sTypes.UID, bool, error), now time.Time) ([]interface{}, bool) {
+	isChanged := false
+	prunedResults := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		resourceMap, resource, ok := getPolicyReportResultResource(result)
+		if !ok {
+			prunedResults = append(prunedResults, result)
+			continue
+		}
+		uid, exists, err := lookupResource(resource)
+		if err != nil {
+			prunedResults = append(prunedResults, result)
+			continue
+		}
+
+		switch {
+		case exists && resource.Uid == "":
+			resourceMap["uid"] = string(uid)
+			isChanged = true
+		case exists && resource.Uid == uid, !exists && resource.Uid == "" && now.Sub(getPolicyReportResultTime(result)) < policyReportPruneGracePeriod:
+		default:
+			// deleted, or recreated without being evaluated
+			isChanged = true
+			continue
+		}
+		prunedResults = append(prunedResults, result)
+	}
+	return prunedResults, isChanged
+}
+
+func getPolicyReportResultTime(result interface{}) time.Time {
+	seconds, _, _ := unstructured.NestedInt64(result.(map[string]interface{}), "timestamp", "seconds")
+	return time.Unix(seconds, 0)
+}
+
+// setPolicyReportResults sorts the results by resource, keeps the newest maxPolicyReportResults and recomputes the summary
+func setPolicyReportResults(report *unstructured.Unstructured, results []interface{}) {
+	if len(results) > maxPolicyReportResults {
+		sort.SliceStable(results, func(i, j int) bool {
+			return getPolicyReportResultTime(results[i]).After(getPolicyReportResultTime(results[j]))
+		})
+		results = results[:maxPolicyReportResults]
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		_, firstResource, _ := getPolicyReportResultResource(results[i])
+		_, secondResource, _ := getPolicyReportResultResource(results[j])
+		if firstResource.key() != secondResource.key() {
+			return firstResource.key() < secondResource.key()
+		}
+		return fmt.Sprint(results[i].(map[string]interface{})["policy"]) < fmt.Sprint(results[j].(map[string]interface{})["policy"])
+	})
+
+	summary := map[string]interface{}{"pass": int64(0), "fail": int64(0), "warn": int64(0), "error": int64(0), "skip": int64(0)}
+	for _, result := range results {
+		if resultName, ok := result.(map[string]interface{})["result"].(string); ok {
+			if count, ok := summary[resultName].(int64); ok {
+				summary[resultName] = count + 1
+			}
+		}
+	}
+
+	report.Object["results"] = results
+	report.Object["summary"] = summary
+}
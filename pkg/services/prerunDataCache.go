@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	prerunDataConfigMapName = "datree-prerun-data-cache"
+	// a follower stops trusting the shared prerun data when the leader didn't update it for this many refresh intervals
+	maxMissedSharedRefreshes = 3
+)
+
+// PrerunDataCache keeps the prerun data in memory so admission requests don't wait on the backend.
+// the data is refreshed in the background, by the leader when the replicas share a store, and the last known good
+// prerun data keeps being served while the backend is unavailable
+type PrerunDataCache struct {
+	cliServiceClient *cliClient.CliClient
+	state            *servicestate.ServiceState
+	store            prerunDataStore
+	leaderElection   *leaderElection.LeaderElection
+	logger           *logger.Logger
+
+	refreshMutex sync.Mutex
+	mutex        sync.RWMutex
+	cached       *cachedPrerunData
+}
+
+type cachedPrerunData struct {
+	PrerunData *cliClient.ClusterEvaluationPrerunDataResponse `json:"prerunData"`
+	Version    string                                         `json:"version"`
+	UpdatedAt  time.Time                                      `json:"updatedAt"`
+}
+
+// NewPrerunDataCache clientSet may be nil, every replica then fetches the prerun data from the backend by itself
+func NewPrerunDataCache(cliServiceClient *cliClient.CliClient, state *servicestate.ServiceState, clientSet kubernetes.Interface, leaderElection *leaderElection.LeaderElection, logger *logger.Logger) *PrerunDataCache {
+	cache := &PrerunDataCache{
+		cliServiceClient: cliServiceClient,
+		state:            state,
+		leaderElection:   leaderElection,
+		logger:           logger,
+	}
+	namespace := os.Getenv(enums.Namespace)
+	if clientSet != nil && namespace != "" {
+		cache.store = &configMapPrerunDataStore{clientSet: clientSet, namespace: namespace}
+	}
+	return cache
+}
+
+// GetPrerunData returns the cached prerun data, it is only fetched synchronously when nothing was cached yet.
+// a fetch that outlives ctx keeps running in the background and fills the cache for the next requests
+func (c *PrerunDataCache) GetPrerunData(ctx context.Context) (*cliClient.ClusterEvaluationPrerunDataResponse, error) {
+	if cached := c.getCached(); cached != nil {
+		return cached.PrerunData, nil
+	}
+
+	refreshErrors := make(chan error, 1)
+	go func() {
+		refreshErrors <- c.refreshIfNotCached()
+	}()
+
+	select {
+	case err := <-refreshErrors:
+		if err != nil {
+			return &cliClient.ClusterEvaluationPrerunDataResponse{}, err
+		}
+		return c.getCached().PrerunData, nil
+	case <-ctx.Done():
+		return &cliClient.ClusterEvaluationPrerunDataResponse{}, ctx.Err()
+	}
+}
+
+// Refresh is called periodically, every state.GetPrerunCacheTTL()
+func (c *PrerunDataCache) Refresh() error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	return c.refresh()
+}
+
+// refreshIfNotCached refreshes the cold cache for the requests that found it empty. the requests that waited on the
+// refresh of another request use what it fetched, instead of each fetching the prerun data again
+func (c *PrerunDataCache) refreshIfNotCached() error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	if c.getCached() != nil {
+		return nil
+	}
+	return c.refresh()
+}
+
+func (c *PrerunDataCache) refresh() error {
+	if c.store != nil && !c.isLeader() {
+		err := c.refreshFromStore()
+		if err == nil {
+			return nil
+		}
+		c.logger.LogWarn(fmt.Sprintf("prerun data cache: %s, fetching the prerun data from the backend", err))
+	}
+
+	return c.refreshFromBackend()
+}
+
+func (c *PrerunDataCache) refreshFromStore() error {
+	stored, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return errors.New("the leader didn't share the prerun data yet")
+	}
+	if time.Since(stored.UpdatedAt) > maxMissedSharedRefreshes*c.state.GetPrerunCacheTTL() {
+		return fmt.Errorf("the shared prerun data was last updated at %s", stored.UpdatedAt.Format(time.RFC3339))
+	}
+
+	c.setCached(stored)
+	return nil
+}
+
+func (c *PrerunDataCache) refreshFromBackend() error {
+	current := c.getCached()
+	currentVersion := ""
+	if current != nil {
+		currentVersion = current.Version
+	}
+
+	prerunData, version, err := c.cliServiceClient.RequestClusterEvaluationPrerunDataIfModified(c.state.GetToken(), c.state.GetClusterUuid(), currentVersion)
+	// the local mode fallback has no policies, it is only served while nothing was fetched from the backend yet
+	isLocalModeFallback := err == nil && c.cliServiceClient.IsLocalMode()
+	if isLocalModeFallback && current != nil {
+		err = errors.New("the backend is unavailable")
+	}
+	if err == nil && prerunData != nil {
+		err = validatePrerunData(c.state, prerunData)
+	}
+	if err != nil {
+		if current != nil {
+			c.logger.LogWarn(fmt.Sprintf("prerun data cache: refresh failed, using the last known good prerun data from %s, err: %s", current.UpdatedAt.Format(time.RFC3339), err))
+		}
+		return err
+	}
+
+	updated := &cachedPrerunData{PrerunData: prerunData, Version: version, UpdatedAt: time.Now()}
+	if prerunData == nil {
+		// not modified since currentVersion
+		updated.PrerunData = current.PrerunData
+	} else if current != nil {
+		c.logger.LogInfo(fmt.Sprintf("prerun data cache: prerun data changed to version %s", version))
+	}
+	c.setCached(updated)
+
+	if c.store != nil && !isLocalModeFallback {
+		// the leader saves the prerun data on every refresh, so the followers know it is still being refreshed
+		if err := c.store.Save(updated); err != nil {
+			c.logger.LogWarn(fmt.Sprintf("prerun data cache: failed to share the prerun data, err: %s", err))
+		}
+	}
+	return nil
+}
+
+func (c *PrerunDataCache) isLeader() bool {
+	return c.leaderElection == nil || c.leaderElection.IsLeader()
+}
+
+func (c *PrerunDataCache) getCached() *cachedPrerunData {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cached
+}
+
+func (c *PrerunDataCache) setCached(cached *cachedPrerunData) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cached = cached
+}
+
+// prerunDataStore shares the prerun data fetched by the leader with the other replicas
+type prerunDataStore interface {
+	// Load returns nil when nothing was saved yet
+	Load() (*cachedPrerunData, error)
+	Save(cached *cachedPrerunData) error
+}
+
+type configMapPrerunDataStore struct {
+	clientSet kubernetes.Interface
+	namespace string
+}
+
+const configMapPrerunDataKey = "prerunData"
+
+func (s *configMapPrerunDataStore) Load() (*cachedPrerunData, error) {
+	configMap, err := s.clientSet.CoreV1().ConfigMaps(s.namespace).Get(context.Background(), prerunDataConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rawCached, ok := configMap.Data[configMapPrerunDataKey]
+	if !ok {
+		return nil, nil
+	}
+	cached := &cachedPrerunData{}
+	if err := json.Unmarshal([]byte(rawCached), cached); err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func (s *configMapPrerunDataStore) Save(cached *cachedPrerunData) error {
+	rawCached, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+
+	configMaps := s.clientSet.CoreV1().ConfigMaps(s.namespace)
+	configMap, err := configMaps.Get(context.Background(), prerunDataConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = configMaps.Create(context.Background(), &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      prerunDataConfigMapName,
+				Namespace: s.namespace,
+				Labels:    map[string]string{"app": "datree-webhook-server", "owner": "datree"},
+			},
+			Data: map[string]string{configMapPrerunDataKey: string(rawCached)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	configMap.Data = map[string]string{configMapPrerunDataKey: string(rawCached)}
+	_, err = configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/datree/pkg/httpClient"
+	"github.com/datreeio/datree/pkg/networkValidator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type mockHttpClient struct {
+	mock.Mock
+}
+
+func (m *mockHttpClient) Request(method string, resourceURI string, body interface{}, headers map[string]string) (httpClient.Response, error) {
+	args := m.Called(headers["If-None-Match"])
+	return args.Get(0).(httpClient.Response), args.Error(1)
+}
+
+func mockPrerunDataCache(t *testing.T, mockedHttpClient *mockHttpClient) *PrerunDataCache {
+	t.Setenv("DATREE_NAMESPACE", "datree")
+	mockedCliServiceClient := cliClient.NewCustomCliServiceClient("", mockedHttpClient, nil, []string{}, networkValidator.NewNetworkValidator(), make(map[string]string))
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	return NewPrerunDataCache(mockedCliServiceClient, servicestate.New(), fake.NewSimpleClientset(), nil, &mockLogger)
+}
+
+func TestPrerunDataCache(t *testing.T) {
+	prerunDataBody := []byte(`{"activePolicies": ["Default"], "registrationURL": "https://app.datree.io"}`)
+
+	t.Run("should fetch once and serve the cached prerun data", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: prerunDataBody}, nil).Once()
+		prerunDataCache := mockPrerunDataCache(t, mockedHttpClient)
+
+		for i := 0; i < 3; i++ {
+			prerunData, err := prerunDataCache.GetPrerunData(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"Default"}, prerunData.ActivePolicies)
+		}
+		mockedHttpClient.AssertNumberOfCalls(t, "Request", 1)
+	})
+
+	t.Run("should fetch once for the concurrent requests of a cold cache", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", mock.AnythingOfType("string")).Return(httpClient.Response{StatusCode: http.StatusOK, Body: prerunDataBody}, nil).After(20 * time.Millisecond)
+		prerunDataCache := mockPrerunDataCache(t, mockedHttpClient)
+
+		var waitGroup sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				prerunData, err := prerunDataCache.GetPrerunData(context.Background())
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"Default"}, prerunData.ActivePolicies)
+			}()
+		}
+		waitGroup.Wait()
+		mockedHttpClient.AssertNumberOfCalls(t, "Request", 1)
+	})
+
+	t.Run("should send the cached version and keep the last known good prerun data when the backend fails", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: prerunDataBody}, nil).Once()
+		mockedHttpClient.On("Request", mock.AnythingOfType("string")).Return(httpClient.Response{StatusCode: http.StatusServiceUnavailable}, errors.New("http error: unavailable"))
+		prerunDataCache := mockPrerunDataCache(t, mockedHttpClient)
+
+		_, err := prerunDataCache.GetPrerunData(context.Background())
+		assert.NoError(t, err)
+		cachedVersion := prerunDataCache.getCached().Version
+		assert.NotEmpty(t, cachedVersion)
+
+		assert.Error(t, prerunDataCache.Refresh())
+		mockedHttpClient.AssertCalled(t, "Request", cachedVersion)
+
+		prerunData, err := prerunDataCache.GetPrerunData(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Default"}, prerunData.ActivePolicies)
+	})
+
+	t.Run("should reject prerun data with an unknown policy action and keep the last known good prerun data", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: prerunDataBody}, nil).Once()
+		mockedHttpClient.On("Request", mock.AnythingOfType("string")).Return(httpClient.Response{StatusCode: http.StatusOK,
+			Body: []byte(`{"activePolicies": ["Typo"], "activePoliciesActions": {"Typo": "Enforc"}}`)}, nil)
+		prerunDataCache := mockPrerunDataCache(t, mockedHttpClient)
+
+		assert.NoError(t, prerunDataCache.Refresh())
+		assert.NoError(t, prerunDataCache.state.GetPrerunDataError())
+
+		err := prerunDataCache.Refresh()
+		assert.EqualError(t, err, `invalid prerun data: unknown action "Enforc" for policy Typo`)
+		assert.Equal(t, err, prerunDataCache.state.GetPrerunDataError())
+
+		prerunData, err := prerunDataCache.GetPrerunData(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Default"}, prerunData.ActivePolicies)
+	})
+
+	t.Run("should not replace or share the last known good prerun data with the local mode fallback", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: prerunDataBody}, nil).Once()
+		mockedNetworkValidator := networkValidator.NewNetworkValidator()
+		prerunDataCache := mockPrerunDataCache(t, mockedHttpClient)
+		prerunDataCache.cliServiceClient = cliClient.NewCustomCliServiceClient("", mockedHttpClient, nil, []string{}, mockedNetworkValidator, make(map[string]string))
+		assert.NoError(t, prerunDataCache.Refresh())
+
+		mockedNetworkValidator.SetOfflineMode("local")
+		assert.Error(t, prerunDataCache.Refresh())
+
+		prerunData, err := prerunDataCache.GetPrerunData(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Default"}, prerunData.ActivePolicies)
+		stored, err := prerunDataCache.store.Load()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Default"}, stored.PrerunData.ActivePolicies)
+	})
+
+	t.Run("should share the prerun data fetched by the leader with the followers", func(t *testing.T) {
+		mockedHttpClient := &mockHttpClient{}
+		mockedHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: prerunDataBody}, nil).Once()
+		leaderCache := mockPrerunDataCache(t, mockedHttpClient)
+		assert.NoError(t, leaderCache.Refresh())
+
+		followerHttpClient := &mockHttpClient{}
+		followerCache := mockPrerunDataCache(t, followerHttpClient)
+		followerCache.store = leaderCache.store
+		followerCache.leaderElection = &leaderElection.LeaderElection{}
+
+		prerunData, err := followerCache.GetPrerunData(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Default"}, prerunData.ActivePolicies)
+		followerHttpClient.AssertNotCalled(t, "Request", mock.Anything)
+	})
+
+	t.Run("followers should fetch from the backend when the shared prerun data is stale", func(t *testing.T) {
+		followerHttpClient := &mockHttpClient{}
+		followerHttpClient.On("Request", "").Return(httpClient.Response{StatusCode: http.StatusOK, Body: prerunDataBody}, nil).Once()
+		followerCache := mockPrerunDataCache(t, followerHttpClient)
+		followerCache.leaderElection = &leaderElection.LeaderElection{}
+		assert.NoError(t, followerCache.store.Save(&cachedPrerunData{
+			PrerunData: &cliClient.ClusterEvaluationPrerunDataResponse{ActivePolicies: []string{"Stale"}},
+			UpdatedAt:  time.Now().Add(-time.Hour),
+		}))
+
+		prerunData, err := followerCache.GetPrerunData(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Default"}, prerunData.ActivePolicies)
+	})
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	baseCliClient "github.com/datreeio/datree/pkg/cliClient"
+	"github.com/datreeio/datree/pkg/defaultPolicies"
+	"github.com/ghodss/yaml"
+)
+
+// PrerunDataProvider supplies the policies, default rules and active policies used to evaluate a resource.
+// GetPrerunData returns the ctx error when the prerun data isn't available before ctx is done
+type PrerunDataProvider interface {
+	GetPrerunData(ctx context.Context) (*cliClient.ClusterEvaluationPrerunDataResponse, error)
+}
+
+func NewPrerunDataProvider(cliServiceClient *cliClient.CliClient, state *servicestate.ServiceState) PrerunDataProvider {
+	if state.GetIsOfflineMode() {
+		return NewLocalPrerunDataProvider(state.GetOfflinePoliciesDir())
+	}
+	return &backendPrerunDataProvider{
+		cliServiceClient: cliServiceClient,
+		state:            state,
+	}
+}
+
+type backendPrerunDataProvider struct {
+	cliServiceClient *cliClient.CliClient
+	state            *servicestate.ServiceState
+}
+
+func (p *backendPrerunDataProvider) GetPrerunData(ctx context.Context) (*cliClient.ClusterEvaluationPrerunDataResponse, error) {
+	prerunData, err := p.cliServiceClient.WithContext(ctx).RequestClusterEvaluationPrerunData(p.state.GetToken(), p.state.GetClusterUuid())
+	if err != nil {
+		return prerunData, err
+	}
+	if err := validatePrerunData(p.state, prerunData); err != nil {
+		return &cliClient.ClusterEvaluationPrerunDataResponse{}, err
+	}
+	return prerunData, nil
+}
+
+// validatePrerunData rejects prerun data with unknown policy actions, rather than evaluating those policies with the
+// default action. the reason is recorded on the state so /config-check reports it
+func validatePrerunData(state *servicestate.ServiceState, prerunData *cliClient.ClusterEvaluationPrerunDataResponse) error {
+	err := prerunData.ValidateActions()
+	state.SetPrerunDataError(err)
+	return err
+}
+
+const (
+	localPoliciesFileName       = "policies.yaml"
+	localDefaultRulesFileName   = "defaultRules.yaml"
+	localActivePoliciesFileName = "activePolicies"
+)
+
+// localPrerunDataProvider reads the prerun data from a mounted directory, used in offline mode where the backend is never called.
+// the directory may contain:
+// policies.yaml - same schema as the policiesJson in the prerun response (the datree policy-as-code file), defaults to the built-in policies
+// defaultRules.yaml - same schema as the defaultRulesYaml in the prerun response, defaults to the built-in rules
+// activePolicies - a yaml list of the policy names to evaluate, defaults to all the policies in policies.yaml
+type localPrerunDataProvider struct {
+	prerunData *cliClient.ClusterEvaluationPrerunDataResponse
+	loadErr    error
+}
+
+func NewLocalPrerunDataProvider(policiesDir string) PrerunDataProvider {
+	prerunData, err := readLocalPrerunData(policiesDir)
+	if err != nil {
+		fmt.Println(fmt.Errorf("failed to read offline policies from %s: %s", policiesDir, err))
+	}
+	return &localPrerunDataProvider{
+		prerunData: prerunData,
+		loadErr:    err,
+	}
+}
+
+func (p *localPrerunDataProvider) GetPrerunData(context.Context) (*cliClient.ClusterEvaluationPrerunDataResponse, error) {
+	if p.loadErr != nil {
+		return &cliClient.ClusterEvaluationPrerunDataResponse{}, p.loadErr
+	}
+	return p.prerunData, nil
+}
+
+func readLocalPrerunData(policiesDir string) (*cliClient.ClusterEvaluationPrerunDataResponse, error) {
+	policies := defaultPolicies.GetDefaultPoliciesStruct()
+	policiesFileContent, err := readOptionalFile(filepath.Join(policiesDir, localPoliciesFileName))
+	if err != nil {
+		return nil, err
+	}
+	if policiesFileContent != nil {
+		policies = &defaultPolicies.EvaluationPrerunPolicies{}
+		if err := yaml.Unmarshal(policiesFileContent, policies); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", localPoliciesFileName, err)
+		}
+	}
+
+	defaultRulesFileContent, err := readOptionalFile(filepath.Join(policiesDir, localDefaultRulesFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var activePolicies []string
+	activePoliciesFileContent, err := readOptionalFile(filepath.Join(policiesDir, localActivePoliciesFileName))
+	if err != nil {
+		return nil, err
+	}
+	if activePoliciesFileContent != nil {
+		if err := yaml.Unmarshal(activePoliciesFileContent, &activePolicies); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", localActivePoliciesFileName, err)
+		}
+	} else {
+		for _, policy := range policies.Policies {
+			activePolicies = append(activePolicies, policy.Name)
+		}
+	}
+
+	return &cliClient.ClusterEvaluationPrerunDataResponse{
+		EvaluationPrerunDataResponse: baseCliClient.EvaluationPrerunDataResponse{
+			PoliciesJson:       policies,
+			DefaultRulesYaml:   string(defaultRulesFileContent),
+			IsPolicyAsCodeMode: true,
+		},
+		ActivePolicies: activePolicies,
+	}, nil
+}
+
+func readOptionalFile(filePath string) ([]byte, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return fileContent, err
+}
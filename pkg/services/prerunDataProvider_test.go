@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const localPoliciesYaml = `apiVersion: v1
+policies:
+  - name: Starter
+    isDefault: true
+    rules:
+      - identifier: CONTAINERS_MISSING_MEMORY_LIMIT_KEY
+        messageOnFailure: Missing property object 'limits.memory'
+  - name: Strict
+    rules:
+      - identifier: CONTAINERS_MISSING_CPU_LIMIT_KEY
+        messageOnFailure: Missing property object 'limits.cpu'
+`
+
+func TestReadLocalPrerunData(t *testing.T) {
+	t.Run("should activate all the policies in policies.yaml by default", func(t *testing.T) {
+		policiesDir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(policiesDir, localPoliciesFileName), []byte(localPoliciesYaml), 0644))
+
+		prerunData, err := NewLocalPrerunDataProvider(policiesDir).GetPrerunData(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, true, prerunData.IsPolicyAsCodeMode)
+		assert.Equal(t, []string{"Starter", "Strict"}, prerunData.ActivePolicies)
+		assert.Len(t, prerunData.PoliciesJson.Policies, 2)
+	})
+
+	t.Run("should only activate the policies listed in activePolicies", func(t *testing.T) {
+		policiesDir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(policiesDir, localPoliciesFileName), []byte(localPoliciesYaml), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(policiesDir, localActivePoliciesFileName), []byte("- Strict\n"), 0644))
+
+		prerunData, err := NewLocalPrerunDataProvider(policiesDir).GetPrerunData(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Strict"}, prerunData.ActivePolicies)
+	})
+
+	t.Run("should fall back to the built-in policies when the directory is empty", func(t *testing.T) {
+		prerunData, err := NewLocalPrerunDataProvider(t.TempDir()).GetPrerunData(context.Background())
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, prerunData.PoliciesJson.Policies)
+		assert.Len(t, prerunData.ActivePolicies, len(prerunData.PoliciesJson.Policies))
+	})
+
+	t.Run("should return an error for an invalid policies.yaml", func(t *testing.T) {
+		policiesDir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(policiesDir, localPoliciesFileName), []byte("policies: not-a-list"), 0644))
+
+		_, err := NewLocalPrerunDataProvider(policiesDir).GetPrerunData(context.Background())
+
+		assert.Error(t, err)
+	})
+}
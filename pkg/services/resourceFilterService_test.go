@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"testing"
 
-	"github.com/datreeio/admission-webhook-datree/pkg/server"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
 	admission "k8s.io/api/admission/v1"
 )
 
@@ -15,8 +17,11 @@ import (
 //go:embed resourceFilterService_testFixtures/templateResource.json
 var templateResource string
 
+var mockRequestLogger = logger.New(zapcore.InfoLevel, nil)
+
 func TestConfigMapScanningFiltersValidation(t *testing.T) {
-	server.ConfigMapScanningFilters.SkipList = []string{"test-namespace+;CronJob+;test-name+", "namespace;kind;name"}
+	skipList, errs := servicestate.ParseLegacySkipList([]string{"test-namespace+;CronJob+;test-name+", "namespace;kind;name"})
+	assert.Empty(t, errs)
 
 	t.Run("resource should be skipped because properties match the skip list", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
@@ -25,7 +30,7 @@ func TestConfigMapScanningFiltersValidation(t *testing.T) {
 		admissionReviewReq.Request.Kind.Kind = "CronJob"
 		admissionReviewReq.Request.Namespace = "test-namespace"
 		rootObject.Metadata.Name = "test-name"
-		assert.Equal(t, true, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject))
+		assert.Equal(t, true, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject, skipList, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because properties match the regexes in the skip list", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
@@ -34,7 +39,7 @@ func TestConfigMapScanningFiltersValidation(t *testing.T) {
 		admissionReviewReq.Request.Kind.Kind = "CronJobbb"
 		admissionReviewReq.Request.Namespace = "test-namespaceee"
 		rootObject.Metadata.Name = "test-nameee"
-		assert.Equal(t, true, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject))
+		assert.Equal(t, true, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject, skipList, &mockRequestLogger))
 	})
 	t.Run("resource should be validated because kind non-skipped is not in the skip list", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
@@ -43,54 +48,73 @@ func TestConfigMapScanningFiltersValidation(t *testing.T) {
 		admissionReviewReq.Request.Kind.Kind = "non-skipped"
 		admissionReviewReq.Request.Namespace = "test-namespace"
 		rootObject.Metadata.Name = "test-name"
-		assert.Equal(t, false, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject))
+		assert.Equal(t, false, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject, skipList, &mockRequestLogger))
 	})
 }
 
-func TestPrerequisitesFilters(t *testing.T) {
-	server.ConfigMapScanningFilters.SkipList = []string{}
+func TestStructuredSkipListFilters(t *testing.T) {
+	skipRule, err := servicestate.ParseSkipRule([]byte(`{"kind": "^CronJob$", "operations": ["UPDATE"], "users": ["^kubectl-user$"]}`))
+	assert.NoError(t, err)
+	skipList := []*servicestate.SkipRule{skipRule}
+
+	t.Run("resource should be skipped because the request matches the skip rule", func(t *testing.T) {
+		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
+		admissionReviewReq.Request.Kind.Kind = "CronJob"
+		admissionReviewReq.Request.Operation = admission.Update
+		admissionReviewReq.Request.UserInfo.Username = "kubectl-user"
+		assert.Equal(t, true, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject, skipList, &mockRequestLogger))
+	})
+	t.Run("resource should be validated because the operation doesn't match the skip rule", func(t *testing.T) {
+		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
+		admissionReviewReq.Request.Kind.Kind = "CronJob"
+		admissionReviewReq.Request.Operation = admission.Create
+		admissionReviewReq.Request.UserInfo.Username = "kubectl-user"
+		assert.Equal(t, false, ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject, skipList, &mockRequestLogger))
+	})
+}
 
+func TestPrerequisitesFilters(t *testing.T) {
 	t.Run("resource should be skipped because resource is deleted", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		rootObject.Metadata.DeletionTimestamp = "2021-01-01T00:00:00Z"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because metadata name is missing", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		rootObject.Metadata.Name = ""
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because kind is Event", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		admissionReviewReq.Request.Kind.Kind = "Event"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because kind is GitRepository", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		admissionReviewReq.Request.Kind.Kind = "GitRepository"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because kind is SubjectAccessReview", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		admissionReviewReq.Request.Kind.Kind = "SubjectAccessReview"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because kind is SelfSubjectAccessReview", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		admissionReviewReq.Request.Kind.Kind = "SelfSubjectAccessReview"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because it has Secret kind and name related to Helm release metadata", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
@@ -99,55 +123,53 @@ func TestPrerequisitesFilters(t *testing.T) {
 		rootObject.Metadata.Labels["owner"] = "helm"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because namespace is kube-public", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		admissionReviewReq.Request.Namespace = "kube-public"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 	t.Run("resource should be skipped because namespace is kube-node-lease", func(t *testing.T) {
 		admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 		admissionReviewReq.Request.Namespace = "kube-node-lease"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 }
 
 func TestWhiteListFilters(t *testing.T) {
-	server.ConfigMapScanningFilters.SkipList = []string{}
-
 	t.Run("resource should be validated because it is managed by kubectl", func(t *testing.T) {
 		t.Run("kubectl-client-side-apply", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "kubectl-client-side-apply"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("kubectl-create", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "kubectl-create"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("kubectl-edit", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "kubectl-edit"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("kubectl-patch", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "kubectl-patch"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 	})
 
@@ -156,7 +178,7 @@ func TestWhiteListFilters(t *testing.T) {
 		rootObject.Metadata.ManagedFields[0].Manager = "helm"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: true,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 
 	t.Run("resource should be validated because it is managed by terraform", func(t *testing.T) {
@@ -165,21 +187,21 @@ func TestWhiteListFilters(t *testing.T) {
 			rootObject.Metadata.ManagedFields[0].Manager = "Terraform"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("HashiCorp", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "HashiCorp"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("some-prefix-terraform-provider-kubernetes", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "some-prefix-terraform-provider-kubernetes"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 	})
 
@@ -189,35 +211,35 @@ func TestWhiteListFilters(t *testing.T) {
 			rootObject.Metadata.ManagedFields[0].Manager = "oc-postfix"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: false,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("prefix-openshift-controller-manager-some-postfix", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "prefix-openshift-controller-manager-some-postfix"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: false,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("prefix-openshift-apiserver-some-postfix", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "prefix-openshift-apiserver-some-postfix"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: false,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("Mozilla-postfix", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "Mozilla-postfix"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: false,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 		t.Run("mozilla", func(t *testing.T) {
 			admissionReviewReq, rootObject := extractAdmissionReviewReqAndRootObject(templateResource)
 			rootObject.Metadata.ManagedFields[0].Manager = "Mozilla-postfix"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: false,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 	})
 
@@ -227,7 +249,7 @@ func TestWhiteListFilters(t *testing.T) {
 		rootObject.Metadata.ManagedFields[0].Manager = "kubectl-client-side-apply"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: true,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 
 	t.Run("resource should not be validated because it has a system:serviceaccount:openshift username and no annotations openshift.io/requester", func(t *testing.T) {
@@ -237,7 +259,7 @@ func TestWhiteListFilters(t *testing.T) {
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate:     false,
 			OpenShiftRequester: "",
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 
 	t.Run("resource should be not validated because it is not openshift serviceaccount and has a system: username and has annotations openshift.io/requester", func(t *testing.T) {
@@ -247,7 +269,7 @@ func TestWhiteListFilters(t *testing.T) {
 		rootObject.Metadata.Annotations["openshift.io/requester"] = "system:serviceaccount"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 
 	t.Run("resource should be not validated because it has a system: username and there is no annotations openshift.io/requester key", func(t *testing.T) {
@@ -256,7 +278,7 @@ func TestWhiteListFilters(t *testing.T) {
 		rootObject.Metadata.ManagedFields[0].Manager = "openshift"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: false,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 
 	t.Run("resource should be validated because username prefix is not system: ", func(t *testing.T) {
@@ -264,7 +286,7 @@ func TestWhiteListFilters(t *testing.T) {
 		admissionReviewReq.Request.UserInfo.Username = "kube-admin"
 		assert.Equal(t, ShouldValidatedResourceData{
 			ShouldValidate: true,
-		}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+		}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 	})
 
 	t.Run("special cases", func(t *testing.T) {
@@ -274,7 +296,7 @@ func TestWhiteListFilters(t *testing.T) {
 			rootObject.Metadata.ManagedFields = append(rootObject.Metadata.ManagedFields, ManagedFields{Manager: "kubectl-client-side-apply"})
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: true,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 
 		t.Run("resource should be skipped because it is managed by non-allowed manager", func(t *testing.T) {
@@ -282,7 +304,7 @@ func TestWhiteListFilters(t *testing.T) {
 			rootObject.Metadata.ManagedFields[0].Manager = "non-matching-manager"
 			assert.Equal(t, ShouldValidatedResourceData{
 				ShouldValidate: false,
-			}, ShouldResourceBeValidated(admissionReviewReq, rootObject))
+			}, ShouldResourceBeValidated(admissionReviewReq, rootObject, &mockRequestLogger))
 		})
 	})
 
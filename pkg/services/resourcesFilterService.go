@@ -2,10 +2,12 @@ package services
 
 import (
 	"encoding/json"
-	"regexp"
+	"fmt"
 	"strings"
+	"time"
 
-	"github.com/datreeio/admission-webhook-datree/pkg/server"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 	"github.com/google/go-cmp/cmp"
 	admission "k8s.io/api/admission/v1"
 	"k8s.io/utils/strings/slices"
@@ -29,7 +31,7 @@ type ShouldValidatedResourceData struct {
 	OpenShiftRequester string
 }
 
-func ShouldResourceBeValidated(admissionReviewReq *admission.AdmissionReview, rootObject RootObject) ShouldValidatedResourceData {
+func ShouldResourceBeValidated(admissionReviewReq *admission.AdmissionReview, rootObject RootObject, requestLogger *logger.Logger) ShouldValidatedResourceData {
 
 	if admissionReviewReq == nil {
 		panic("admissionReviewReq is nil")
@@ -48,6 +50,12 @@ func ShouldResourceBeValidated(admissionReviewReq *admission.AdmissionReview, ro
 	isResourceDeleted := isResourceDeleted(rootObject)
 	isNamespaceThatShouldBeSkipped := isNamespaceThatShouldBeSkipped(admissionReviewReq)
 	arePrerequisitesMet := isMetadataNameExists && !isUnsupportedKind && !isResourceDeleted && !isNamespaceThatShouldBeSkipped
+	requestLogger.LogDebug("resource prerequisites", map[string]bool{
+		"isMetadataNameExists":           isMetadataNameExists,
+		"isUnsupportedKind":              isUnsupportedKind,
+		"isResourceDeleted":              isResourceDeleted,
+		"isNamespaceThatShouldBeSkipped": isNamespaceThatShouldBeSkipped,
+	})
 
 	// if the resource is a helm release metadata, we don't want to validate it
 	// https://stackoverflow.com/questions/66244697/where-does-helm-store-installation-state
@@ -100,6 +108,13 @@ func ShouldResourceBeValidated(admissionReviewReq *admission.AdmissionReview, ro
 	isFluxResourceThatShouldBeEvaluated := isFluxResourceThatShouldBeEvaluated(admissionReviewReq, rootObject, managedFields)
 	isArgoResourceThatShouldBeEvaluated := isArgoResourceThatShouldBeEvaluated(admissionReviewReq, resourceKind, managedFields)
 	isResourceWhiteListed := isKubectl || isHelm || isTerraform || isFluxResourceThatShouldBeEvaluated || isArgoResourceThatShouldBeEvaluated
+	requestLogger.LogDebug("resource field managers", map[string]bool{
+		"isKubectl":                           isKubectl,
+		"isHelm":                              isHelm,
+		"isTerraform":                         isTerraform,
+		"isFluxResourceThatShouldBeEvaluated": isFluxResourceThatShouldBeEvaluated,
+		"isArgoResourceThatShouldBeEvaluated": isArgoResourceThatShouldBeEvaluated,
+	})
 
 	return ShouldValidatedResourceData{
 		ShouldValidate: isResourceWhiteListed,
@@ -107,21 +122,20 @@ func ShouldResourceBeValidated(admissionReviewReq *admission.AdmissionReview, ro
 
 }
 
-func ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq *admission.AdmissionReview, rootObject RootObject) bool {
-	namespace := admissionReviewReq.Request.Namespace
-	resourceKind := admissionReviewReq.Request.Kind.Kind
-	resourceName := rootObject.Metadata.Name
-
-	for _, skipListItem := range server.ConfigMapScanningFilters.SkipList {
-		skipRuleItem := strings.Split(skipListItem, ";")
-
-		if len(skipRuleItem) != 3 {
-			continue
-		}
+func ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq *admission.AdmissionReview, rootObject RootObject, skipList []*servicestate.SkipRule, requestLogger *logger.Logger) bool {
+	resource := servicestate.SkipListResource{
+		Namespace: admissionReviewReq.Request.Namespace,
+		Kind:      admissionReviewReq.Request.Kind.Kind,
+		Name:      rootObject.Metadata.Name,
+		Labels:    rootObject.Metadata.Labels,
+		Operation: string(admissionReviewReq.Request.Operation),
+		Username:  admissionReviewReq.Request.UserInfo.Username,
+	}
 
-		if doesRegexMatchString(skipRuleItem[0], namespace) &&
-			doesRegexMatchString(skipRuleItem[1], resourceKind) &&
-			doesRegexMatchString(skipRuleItem[2], resourceName) {
+	now := time.Now()
+	for _, skipRule := range skipList {
+		if skipRule.Matches(resource, now) {
+			requestLogger.LogDebug(fmt.Sprintf("resource matched the skip list item %s", skipRule))
 			return true
 		}
 	}
@@ -294,14 +308,6 @@ func isAtLeastOneFieldManagerEqualToOneOfTheExpectedFieldManagers(fields []Manag
 	return false
 }
 
-func doesRegexMatchString(regex string, str string) bool {
-	r, err := regexp.Compile(regex)
-	if err != nil {
-		return false
-	}
-	return r.MatchString(str)
-}
-
 func isOpenshiftRequesterExists(annotations map[string]string) (bool, string) {
 	if val, ok := annotations["openshift.io/requester"]; ok {
 		return true, val
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	"github.com/datreeio/admission-webhook-datree/pkg/leaderElection"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+)
+
+// ResultSink is a destination of the evaluation output: the result of every evaluated policy, and the aggregated metadata
+// of the admission requests, including the skipped ones. SendEvaluationResult is called on the admission path and must not
+// wait for a remote destination
+type ResultSink interface {
+	SendEvaluationResult(result *EvaluationResult) error
+	SendRequestMetadataBatch(requestMetadataBatch []*cliClient.ClusterRequestMetadata) error
+	// Shutdown delivers what is still pending until ctx is done
+	Shutdown(ctx context.Context)
+}
+
+// EvaluationResult the result of evaluating a resource against a single policy, along with the admission decision
+type EvaluationResult struct {
+	*cliClient.EvaluationResultRequest
+	// ApiVersion the group version of the evaluated resource
+	ApiVersion string `json:"apiVersion"`
+	// ResourceUid is empty when the resource is evaluated on its creation
+	ResourceUid k8sTypes.UID          `json:"resourceUid,omitempty"`
+	Action      enums.ActionOnFailure `json:"action"`
+	Passed      bool                  `json:"passed"`
+	// Allowed false when the resource was denied by this policy
+	Allowed bool `json:"allowed"`
+	// IsAudit true when the resource was evaluated by the audit scan, Allowed is then whether it would have been admitted
+	IsAudit bool `json:"isAudit,omitempty"`
+	// DryRun true when the resource was evaluated by a dry-run request, it is never persisted
+	DryRun bool `json:"dryRun,omitempty"`
+	// Exceptions the rules the resource was excepted from, they are skipped in the policy check results
+	Exceptions  []RuleException `json:"exceptions,omitempty"`
+	EvaluatedAt time.Time       `json:"evaluatedAt"`
+}
+
+const (
+	SinkRecordTypeEvaluationResult = "evaluationResult"
+	SinkRecordTypeRequestMetadata  = "requestMetadata"
+)
+
+// SinkRecord is what the file and stdout sinks write as a json line, and what the webhook sink posts.
+// the token is never part of a record
+type SinkRecord struct {
+	Type             string                            `json:"type"`
+	EvaluationResult *EvaluationResult                 `json:"evaluationResult,omitempty"`
+	RequestMetadata  *cliClient.ClusterRequestMetadata `json:"requestMetadata,omitempty"`
+}
+
+func newEvaluationResultRecord(result *EvaluationResult) SinkRecord {
+	resultWithoutToken := *result
+	request := *result.EvaluationResultRequest
+	request.Token = ""
+	resultWithoutToken.EvaluationResultRequest = &request
+	return SinkRecord{Type: SinkRecordTypeEvaluationResult, EvaluationResult: &resultWithoutToken}
+}
+
+func newRequestMetadataRecord(requestMetadata *cliClient.ClusterRequestMetadata) SinkRecord {
+	requestMetadataWithoutToken := *requestMetadata
+	requestMetadataWithoutToken.Token = ""
+	return SinkRecord{Type: SinkRecordTypeRequestMetadata, RequestMetadata: &requestMetadataWithoutToken}
+}
+
+type ResultSinkDependencies struct {
+	CliServiceClient *cliClient.CliClient
+	// SpoolDir where the datree sink spools the results it can't upload right now, see EvaluationResultQueue
+	SpoolDir string
+	// DynamicClient is required by the policyReport sink, nil when not running in a cluster
+	DynamicClient dynamic.Interface
+	// DiscoveryClient and MetadataClient are used by the policyReport sink to prune the results of the deleted resources
+	DiscoveryClient discovery.DiscoveryInterface
+	MetadataClient  metadata.Interface
+	// LeaderElection the policyReport sink only prunes on the leader, nil when there is a single replica
+	LeaderElection *leaderElection.LeaderElection
+	Logger         *logger.Logger
+}
+
+// ResultSinks fans the evaluation output out to all the configured sinks
+type ResultSinks struct {
+	sinks []ResultSink
+	// evaluationResultQueue is nil when the datree sink isn't configured
+	evaluationResultQueue *EvaluationResultQueue
+	logger                *logger.Logger
+}
+
+// NewResultSinks a sink that can't be created is skipped, the other sinks are still used
+func NewResultSinks(resultSinkConfigs []servicestate.ResultSinkConfig, dependencies ResultSinkDependencies) *ResultSinks {
+	resultSinks := &ResultSinks{logger: dependencies.Logger}
+	for _, resultSinkConfig := range resultSinkConfigs {
+		sink, err := resultSinks.newResultSink(resultSinkConfig, dependencies)
+		if err != nil {
+			dependencies.Logger.LogError(fmt.Sprintf("result sinks: the %s sink is disabled, err: %s", resultSinkConfig.Type, err))
+			continue
+		}
+		resultSinks.sinks = append(resultSinks.sinks, sink)
+	}
+	return resultSinks
+}
+
+func (s *ResultSinks) newResultSink(resultSinkConfig servicestate.ResultSinkConfig, dependencies ResultSinkDependencies) (ResultSink, error) {
+	switch resultSinkConfig.Type {
+	case enums.DatreeResultSink:
+		if s.evaluationResultQueue != nil {
+			return nil, errors.New("the datree sink is configured more than once")
+		}
+		s.evaluationResultQueue = NewEvaluationResultQueue(dependencies.CliServiceClient, dependencies.SpoolDir, dependencies.Logger)
+		s.evaluationResultQueue.Start()
+		return &datreeResultSink{cliServiceClient: dependencies.CliServiceClient, evaluationResultQueue: s.evaluationResultQueue}, nil
+	case enums.FileResultSink:
+		return newFileResultSink(resultSinkConfig.Path), nil
+	case enums.StdoutResultSink:
+		return newStdoutResultSink(), nil
+	case enums.WebhookResultSink:
+		return newWebhookResultSink(resultSinkConfig, dependencies.Logger)
+	case enums.PolicyReportResultSink:
+		if dependencies.DynamicClient == nil {
+			return nil, errors.New("a kubernetes client is required")
+		}
+		return newPolicyReportResultSink(dependencies.DynamicClient, dependencies.DiscoveryClient, dependencies.MetadataClient, dependencies.LeaderElection, dependencies.Logger), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", resultSinkConfig.Type)
+	}
+}
+
+// SendEvaluationResult returns an error when any of the sinks failed, the result is still sent to the others
+func (s *ResultSinks) SendEvaluationResult(result *EvaluationResult) error {
+	var errorMessages []string
+	for _, sink := range s.sinks {
+		if err := sink.SendEvaluationResult(result); err != nil {
+			errorMessages = append(errorMessages, err.Error())
+		}
+	}
+	if len(errorMessages) > 0 {
+		return errors.New(strings.Join(errorMessages, ", "))
+	}
+	return nil
+}
+
+func (s *ResultSinks) SendRequestMetadataBatch(requestMetadataBatch []*cliClient.ClusterRequestMetadata) {
+	for _, sink := range s.sinks {
+		if err := sink.SendRequestMetadataBatch(requestMetadataBatch); err != nil {
+			s.logger.LogWarn(fmt.Sprintf("result sinks: sending the request metadata failed, err: %s", err))
+		}
+	}
+}
+
+// GetEvaluationResultQueue returns the queue of the datree sink, nil when it isn't configured
+func (s *ResultSinks) GetEvaluationResultQueue() *EvaluationResultQueue {
+	return s.evaluationResultQueue
+}
+
+// IsSendingToDatree returns true when the results are uploaded to the Datree backend, where the full report is available
+func (s *ResultSinks) IsSendingToDatree() bool {
+	return s.evaluationResultQueue != nil
+}
+
+func (s *ResultSinks) Shutdown(ctx context.Context) {
+	var shutdowns sync.WaitGroup
+	for _, sink := range s.sinks {
+		shutdowns.Add(1)
+		go func(sink ResultSink) {
+			defer shutdowns.Done()
+			sink.Shutdown(ctx)
+		}(sink)
+	}
+	shutdowns.Wait()
+}
+
+// datreeResultSink uploads the results from the EvaluationResultQueue, the request metadata is sent in a single batch
+type datreeResultSink struct {
+	cliServiceClient      *cliClient.CliClient
+	evaluationResultQueue *EvaluationResultQueue
+}
+
+func (s *datreeResultSink) SendEvaluationResult(result *EvaluationResult) error {
+	s.evaluationResultQueue.Enqueue(result.EvaluationResultRequest)
+	return nil
+}
+
+func (s *datreeResultSink) SendRequestMetadataBatch(requestMetadataBatch []*cliClient.ClusterRequestMetadata) error {
+	s.cliServiceClient.SendRequestMetadataBatch(cliClient.ClusterRequestMetadataBatchReqBody{MetadataLogs: requestMetadataBatch})
+	return nil
+}
+
+func (s *datreeResultSink) Shutdown(ctx context.Context) {
+	s.evaluationResultQueue.Shutdown(ctx)
+}
+
+const backgroundResultSinkQueueSize = 1000
+
+// backgroundResultSink delivers the records of a remote sink from a bounded queue, so the admission requests never wait
+// for it. records are dropped when the queue is full
+type backgroundResultSink struct {
+	name    string
+	deliver func(record SinkRecord) error
+	logger  *logger.Logger
+
+	records     chan SinkRecord
+	stopChannel chan struct{}
+	stopOnce    sync.Once
+	done        chan struct{}
+}
+
+func newBackgroundResultSink(name string, deliver func(record SinkRecord) error, logger *logger.Logger) *backgroundResultSink {
+	sink := &backgroundResultSink{
+		name:        name,
+		deliver:     deliver,
+		logger:      logger,
+		records:     make(chan SinkRecord, backgroundResultSinkQueueSize),
+		stopChannel: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go sink.work()
+	return sink
+}
+
+func (s *backgroundResultSink) SendEvaluationResult(result *EvaluationResult) error {
+	s.enqueue(newEvaluationResultRecord(result))
+	return nil
+}
+
+func (s *backgroundResultSink) SendRequestMetadataBatch(requestMetadataBatch []*cliClient.ClusterRequestMetadata) error {
+	for _, requestMetadata := range requestMetadataBatch {
+		s.enqueue(newRequestMetadataRecord(requestMetadata))
+	}
+	return nil
+}
+
+func (s *backgroundResultSink) enqueue(record SinkRecord) {
+	select {
+	case s.records <- record:
+	default:
+		s.logger.LogWarn(fmt.Sprintf("result sinks: the %s sink queue is full, a %s record was dropped", s.name, record.Type))
+	}
+}
+
+func (s *backgroundResultSink) work() {
+	defer close(s.done)
+	for {
+		select {
+		case record := <-s.records:
+			s.deliverRecord(record)
+		case <-s.stopChannel:
+			// deliver what was queued before the shutdown
+			for {
+				select {
+				case record := <-s.records:
+					s.deliverRecord(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *backgroundResultSink) deliverRecord(record SinkRecord) {
+	if err := s.deliver(record); err != nil {
+		s.logger.LogWarn(fmt.Sprintf("result sinks: the %s sink failed to deliver a %s record, err: %s", s.name, record.Type, err))
+	}
+}
+
+// Shutdown delivers the queued records, the ones that weren't delivered by the time ctx is done are dropped
+func (s *backgroundResultSink) Shutdown(ctx context.Context) {
+	s.stopOnce.Do(func() {
+		close(s.stopChannel)
+	})
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
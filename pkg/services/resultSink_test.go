@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
+	"github.com/datreeio/admission-webhook-datree/pkg/enums"
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	baseCliClient "github.com/datreeio/datree/pkg/cliClient"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	discoveryFake "k8s.io/client-go/discovery/fake"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	metadataFake "k8s.io/client-go/metadata/fake"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+func mockEvaluationResult(policyName string, failedRuleIdentifiers ...string) *EvaluationResult {
+	policyCheckResults := map[string]map[string]*baseCliClient.FailedRule{}
+	for _, failedRuleIdentifier := range failedRuleIdentifiers {
+		policyCheckResults["webhook-my-deployment-Deployment.tmp.yaml"] = map[string]*baseCliClient.FailedRule{
+			failedRuleIdentifier: {
+				Name:             "Ensure each container has a configured memory limit",
+				MessageOnFailure: "Missing property object `limits.memory`",
+				Configurations:   []baseCliClient.Configuration{{Name: "my-deployment", Kind: "Deployment", Occurrences: 1}},
+			},
+		}
+	}
+
+	return &EvaluationResult{
+		EvaluationResultRequest: &cliClient.EvaluationResultRequest{
+			Token:      "secret-token",
+			PolicyName: policyName,
+			AllExecutedRules: []baseCliClient.RuleData{
+				{Identifier: "CONTAINERS_MISSING_MEMORY_LIMIT_KEY", Name: "Ensure each container has a configured memory limit"},
+				{Identifier: "CONTAINERS_MISSING_CPU_LIMIT_KEY", Name: "Ensure each container has a configured CPU limit"},
+			},
+			PolicyCheckResults: policyCheckResults,
+			Namespace:          "my-namespace",
+			Kind:               "Deployment",
+			MetadataName:       "my-deployment",
+		},
+		ApiVersion:  "apps/v1",
+		Action:      enums.EnforceActionOnFailure,
+		Passed:      len(failedRuleIdentifiers) == 0,
+		Allowed:     len(failedRuleIdentifiers) == 0,
+		EvaluatedAt: time.Now(),
+	}
+}
+
+func mockResultSinks(resultSinkConfigs []servicestate.ResultSinkConfig, dependencies ResultSinkDependencies) *ResultSinks {
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	dependencies.Logger = &mockLogger
+	return NewResultSinks(resultSinkConfigs, dependencies)
+}
+
+func readSinkRecords(t *testing.T, filePath string) []SinkRecord {
+	fileContent, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	var records []SinkRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(fileContent)), "\n") {
+		record := SinkRecord{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestResultSinks(t *testing.T) {
+	t.Run("should fan the results out to every sink, without the token", func(t *testing.T) {
+		firstFilePath := filepath.Join(t.TempDir(), "results.jsonl")
+		secondFilePath := filepath.Join(t.TempDir(), "results.jsonl")
+		resultSinks := mockResultSinks([]servicestate.ResultSinkConfig{
+			{Type: enums.FileResultSink, Path: firstFilePath},
+			{Type: enums.FileResultSink, Path: secondFilePath},
+		}, ResultSinkDependencies{})
+
+		evaluationResult := mockEvaluationResult("Default", "CONTAINERS_MISSING_MEMORY_LIMIT_KEY")
+		assert.NoError(t, resultSinks.SendEvaluationResult(evaluationResult))
+		resultSinks.SendRequestMetadataBatch([]*cliClient.ClusterRequestMetadata{{Token: "secret-token", ResourceName: "my-deployment", Allowed: false}})
+
+		for _, filePath := range []string{firstFilePath, secondFilePath} {
+			records := readSinkRecords(t, filePath)
+			assert.Len(t, records, 2)
+			assert.Equal(t, SinkRecordTypeEvaluationResult, records[0].Type)
+			assert.Equal(t, "my-deployment", records[0].EvaluationResult.MetadataName)
+			assert.Equal(t, false, records[0].EvaluationResult.Allowed)
+			assert.Empty(t, records[0].EvaluationResult.Token)
+			assert.Equal(t, SinkRecordTypeRequestMetadata, records[1].Type)
+			assert.Empty(t, records[1].RequestMetadata.Token)
+		}
+		assert.Equal(t, "secret-token", evaluationResult.Token)
+		assert.False(t, resultSinks.IsSendingToDatree())
+	})
+
+	t.Run("should skip a sink that can't be created", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "results.jsonl")
+		resultSinks := mockResultSinks([]servicestate.ResultSinkConfig{
+			{Type: enums.PolicyReportResultSink},
+			{Type: enums.FileResultSink, Path: filePath},
+		}, ResultSinkDependencies{})
+
+		assert.NoError(t, resultSinks.SendEvaluationResult(mockEvaluationResult("Default")))
+		assert.Len(t, readSinkRecords(t, filePath), 1)
+	})
+
+	t.Run("should post the payload template of every record to the webhook", func(t *testing.T) {
+		payloads := make(chan string, 1)
+		webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, "Splunk hec-token", r.Header.Get("Authorization"))
+			payloads <- string(body)
+		}))
+		defer webhookServer.Close()
+
+		resultSinks := mockResultSinks([]servicestate.ResultSinkConfig{{
+			Type:            enums.WebhookResultSink,
+			URL:             webhookServer.URL,
+			Headers:         map[string]string{"Authorization": "Splunk hec-token"},
+			PayloadTemplate: `{"event": {"resource": {{ toJson .EvaluationResult.MetadataName }}, "allowed": {{ .EvaluationResult.Allowed }}}}`,
+		}}, ResultSinkDependencies{})
+
+		assert.NoError(t, resultSinks.SendEvaluationResult(mockEvaluationResult("Default", "CONTAINERS_MISSING_MEMORY_LIMIT_KEY")))
+		resultSinks.Shutdown(context.Background())
+
+		assert.Equal(t, `{"event": {"resource": "my-deployment", "allowed": false}}`, <-payloads)
+	})
+}
+
+func mockPolicyReportResultSink(resources []*metav1.APIResourceList, objects ...runtime.Object) (*policyReportResultSink, *dynamicFake.FakeDynamicClient) {
+	dynamicClient := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		policyReportResource:        "PolicyReportList",
+		clusterPolicyReportResource: "ClusterPolicyReportList",
+	})
+	metadataScheme := metadataFake.NewTestScheme()
+	_ = metav1.AddMetaToScheme(metadataScheme)
+	discoveryClient := &discoveryFake.FakeDiscovery{Fake: &k8sTesting.Fake{Resources: resources}}
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	return newPolicyReportResultSink(dynamicClient, discoveryClient, metadataFake.NewSimpleMetadataClient(metadataScheme, objects...), nil, &mockLogger), dynamicClient
+}
+
+func getPolicyReportResultsOf(t *testing.T, dynamicClient *dynamicFake.FakeDynamicClient, namespace string) ([]interface{}, map[string]interface{}) {
+	reportClient := dynamicClient.Resource(clusterPolicyReportResource).Namespace("")
+	if namespace != "" {
+		reportClient = dynamicClient.Resource(policyReportResource).Namespace(namespace)
+	}
+	report, err := reportClient.Get(context.Background(), policyReportName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	results, _, _ := unstructured.NestedSlice(report.Object, "results")
+	summary, _, _ := unstructured.NestedMap(report.Object, "summary")
+	return results, summary
+}
+
+func mockPartialObjectMetadata(name string, uid k8sTypes.UID) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "my-namespace", UID: uid},
+	}
+}
+
+func TestPolicyReportResultSink(t *testing.T) {
+	t.Run("should keep the latest results of every resource and policy in the report of the namespace", func(t *testing.T) {
+		sink, dynamicClient := mockPolicyReportResultSink(nil)
+
+		assert.NoError(t, sink.SendEvaluationResult(mockEvaluationResult("Default", "CONTAINERS_MISSING_MEMORY_LIMIT_KEY")))
+		sink.flush()
+		assert.NoError(t, sink.SendEvaluationResult(mockEvaluationResult("Starter")))
+		otherResource := mockEvaluationResult("Default")
+		otherResource.MetadataName = "other-deployment"
+		assert.NoError(t, sink.SendEvaluationResult(otherResource))
+		sink.flush()
+
+		results, summary := getPolicyReportResultsOf(t, dynamicClient, "my-namespace")
+		assert.Len(t, results, 3)
+		assert.Equal(t, int64(2), summary["pass"])
+		assert.Equal(t, int64(1), summary["fail"])
+		failedResult := results[0].(map[string]interface{})
+		assert.Equal(t, "CONTAINERS_MISSING_MEMORY_LIMIT_KEY", failedResult["rule"])
+		assert.Equal(t, "Missing property object `limits.memory`", failedResult["message"])
+		resource := failedResult["resources"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, "my-deployment", resource["name"])
+		assert.Equal(t, "my-namespace", resource["namespace"])
+
+		assert.NoError(t, sink.SendEvaluationResult(mockEvaluationResult("Default")))
+		sink.Shutdown(context.Background())
+
+		results, summary = getPolicyReportResultsOf(t, dynamicClient, "my-namespace")
+		assert.Len(t, results, 3)
+		assert.Equal(t, int64(3), summary["pass"])
+		assert.Equal(t, int64(0), summary["fail"])
+	})
+
+	t.Run("should not report the results of a dry-run request", func(t *testing.T) {
+		sink, dynamicClient := mockPolicyReportResultSink(nil)
+
+		assert.NoError(t, sink.SendEvaluationResult(mockEvaluationResult("Default")))
+		sink.flush()
+		dryRunResult := mockEvaluationResult("Default", "CONTAINERS_MISSING_MEMORY_LIMIT_KEY")
+		dryRunResult.DryRun = true
+		assert.NoError(t, sink.SendEvaluationResult(dryRunResult))
+		sink.Shutdown(context.Background())
+
+		results, summary := getPolicyReportResultsOf(t, dynamicClient, "my-namespace")
+		assert.Len(t, results, 1)
+		assert.Equal(t, int64(1), summary["pass"])
+		assert.Equal(t, int64(0), summary["fail"])
+	})
+
+	t.Run("should report a cluster scoped resource in the ClusterPolicyReport", func(t *testing.T) {
+		sink, dynamicClient := mockPolicyReportResultSink(nil)
+
+		evaluationResult := mockEvaluationResult("Default", "CONTAINERS_MISSING_MEMORY_LIMIT_KEY")
+		evaluationResult.Namespace = ""
+		evaluationResult.Kind = "ClusterRole"
+		assert.NoError(t, sink.SendEvaluationResult(evaluationResult))
+		sink.Shutdown(context.Background())
+
+		_, summary := getPolicyReportResultsOf(t, dynamicClient, "")
+		assert.Equal(t, int64(0), summary["pass"])
+		assert.Equal(t, int64(1), summary["fail"])
+	})
+
+	t.Run("should prune the results of the deleted and recreated resources", func(t *testing.T) {
+		sink, dynamicClient := mockPolicyReportResultSink([]*metav1.APIResourceList{{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{{Name: "deployments", Namespaced: true, Kind: "Deployment"}},
+		}}, mockPartialObjectMetadata("unchanged", "unchanged-uid"), mockPartialObjectMetadata("recreated", "new-uid"), mockPartialObjectMetadata("created", "created-uid"))
+
+		evaluations := []struct {
+			name        string
+			uid         k8sTypes.UID
+			evaluatedAt time.Time
+		}{
+			{name: "unchanged", uid: "unchanged-uid", evaluatedAt: time.Now()},
+			{name: "recreated", uid: "old-uid", evaluatedAt: time.Now()},
+			{name: "deleted", uid: "deleted-uid", evaluatedAt: time.Now()},
+			{name: "created", evaluatedAt: time.Now()},
+			{name: "recently-denied", evaluatedAt: time.Now()},
+			{name: "denied", evaluatedAt: time.Now().Add(-2 * policyReportPruneGracePeriod)},
+		}
+		for _, evaluation := range evaluations {
+			evaluationResult := mockEvaluationResult("Default")
+			evaluationResult.MetadataName = evaluation.name
+			evaluationResult.ResourceUid = evaluation.uid
+			evaluationResult.EvaluatedAt = evaluation.evaluatedAt
+			assert.NoError(t, sink.SendEvaluationResult(evaluationResult))
+		}
+		sink.flush()
+		sink.prune()
+		sink.Shutdown(context.Background())
+
+		results, _ := getPolicyReportResultsOf(t, dynamicClient, "my-namespace")
+		uidsByName := map[string]interface{}{}
+		for _, result := range results {
+			resource := result.(map[string]interface{})["resources"].([]interface{})[0].(map[string]interface{})
+			uidsByName[resource["name"].(string)] = resource["uid"]
+		}
+		assert.Equal(t, map[string]interface{}{"unchanged": "unchanged-uid", "created": "created-uid", "recently-denied": nil}, uidsByName)
+	})
+}
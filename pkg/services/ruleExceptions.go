@@ -0,0 +1,187 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/admission-webhook-datree/pkg/policyException"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/datree/pkg/evaluation"
+	"github.com/datreeio/datree/pkg/extractor"
+	admission "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// the annotations a resource declares its exceptions with, e.g.
+// admission.datree/exception-rules: "CONTAINERS_MISSING_MEMORY_LIMIT_KEY,CONTAINERS_MISSING_CPU_LIMIT_KEY"
+// admission.datree/exception-justification: "the limits are tuned in JIRA-123"
+// admission.datree/exception-expires: "2024-01-31T00:00:00Z"
+const (
+	exceptionRulesAnnotation         = "admission.datree/exception-rules"
+	exceptionJustificationAnnotation = "admission.datree/exception-justification"
+	exceptionExpiresAnnotation       = "admission.datree/exception-expires"
+)
+
+// RuleException a rule the resource is excepted from until ExpiresAt, by its annotations or by a DatreePolicyException
+type RuleException struct {
+	RuleIdentifier string    `json:"ruleIdentifier"`
+	Justification  string    `json:"justification"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	// PolicyException the namespace/name of the DatreePolicyException, empty when the exception is declared by annotations
+	PolicyException string `json:"policyException,omitempty"`
+	ApprovedBy      string `json:"approvedBy,omitempty"`
+}
+
+// ruleExceptionsRequest what the exceptions of a resource are checked against
+type ruleExceptionsRequest struct {
+	resourceName       string
+	namespace          string
+	annotations        map[string]string
+	userInfo           authenticationv1.UserInfo
+	openShiftRequester string
+	// isAudit the requester isn't checked, the resource was already admitted with its annotations
+	isAudit bool
+	now     time.Time
+}
+
+// getRuleExceptions returns the exceptions the resource declares that are allowed and not expired, and a warning with
+// the applied exceptions, or with the reason they were ignored
+func (vs *ValidationService) getRuleExceptions(requestLogger *logger.Logger, ruleExceptions *servicestate.RuleExceptions, request ruleExceptionsRequest) (exceptions []RuleException, warningMessages []string) {
+	rulesAnnotation := strings.TrimSpace(request.annotations[exceptionRulesAnnotation])
+	if rulesAnnotation == "" {
+		return nil, nil
+	}
+
+	ignoredWarning := func(reason string) []string {
+		return []string{fmt.Sprintf("⚠️ The exceptions of object with name \"%s\" were ignored: %s", request.resourceName, reason)}
+	}
+
+	justification := strings.TrimSpace(request.annotations[exceptionJustificationAnnotation])
+	if justification == "" {
+		return nil, ignoredWarning(fmt.Sprintf("the %s annotation is required", exceptionJustificationAnnotation))
+	}
+	expiresAt, err := time.Parse(time.RFC3339, strings.TrimSpace(request.annotations[exceptionExpiresAnnotation]))
+	if err != nil {
+		return nil, ignoredWarning(fmt.Sprintf("the %s annotation must be an RFC 3339 timestamp", exceptionExpiresAnnotation))
+	}
+	if !request.now.Before(expiresAt) {
+		return nil, ignoredWarning(fmt.Sprintf("they expired at %s", expiresAt.Format(time.RFC3339)))
+	}
+
+	var appliedRuleIdentifiers []string
+	var notAllowedRuleIdentifiers []string
+	for _, ruleIdentifier := range strings.Split(rulesAnnotation, ",") {
+		ruleIdentifier = strings.TrimSpace(ruleIdentifier)
+		if ruleIdentifier == "" {
+			continue
+		}
+		if !vs.isRuleExceptionAllowed(requestLogger, ruleExceptions, request, ruleIdentifier, expiresAt) {
+			notAllowedRuleIdentifiers = append(notAllowedRuleIdentifiers, ruleIdentifier)
+			continue
+		}
+		appliedRuleIdentifiers = append(appliedRuleIdentifiers, ruleIdentifier)
+		exceptions = append(exceptions, RuleException{RuleIdentifier: ruleIdentifier, Justification: justification, ExpiresAt: expiresAt})
+	}
+
+	if len(appliedRuleIdentifiers) > 0 {
+		warningMessages = append(warningMessages, fmt.Sprintf("🛡️ Object with name \"%s\" is excepted from rules %s until %s: %s",
+			request.resourceName, strings.Join(appliedRuleIdentifiers, ", "), expiresAt.Format(time.RFC3339), justification))
+	}
+	if len(notAllowedRuleIdentifiers) > 0 {
+		warningMessages = append(warningMessages, ignoredWarning(fmt.Sprintf("rules %s may not be excepted by this requester, in this namespace or for this long",
+			strings.Join(notAllowedRuleIdentifiers, ", ")))...)
+	}
+	return exceptions, warningMessages
+}
+
+func (vs *ValidationService) isRuleExceptionAllowed(requestLogger *logger.Logger, ruleExceptions *servicestate.RuleExceptions, request ruleExceptionsRequest, ruleIdentifier string, expiresAt time.Time) bool {
+	if ruleExceptions == nil {
+		return false
+	}
+	for _, allowance := range ruleExceptions.Allowances {
+		if !allowance.AllowsRule(ruleIdentifier, request.namespace) {
+			continue
+		}
+		if allowance.MaxDurationDays > 0 && expiresAt.After(request.now.AddDate(0, 0, allowance.MaxDurationDays)) {
+			continue
+		}
+		if allowance.Requesters != nil && !request.isAudit && !vs.shouldBypassByPermissions(requestLogger, allowance.Requesters, request.userInfo, request.openShiftRequester) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// getResourceExceptions returns the exceptions the resource is evaluated with, the ones its annotations declare and the ones
+// of the DatreePolicyExceptions that apply to it, and their warnings
+func (vs *ValidationService) getResourceExceptions(requestLogger *logger.Logger, config *servicestate.Config, admissionReviewReq *admission.AdmissionReview, rootObject RootObject, openShiftRequester string, isAudit bool) (exceptions []RuleException, warningMessages []string) {
+	namespace, _, resourceName, _ := getResourceMetadata(admissionReviewReq, rootObject)
+	now := time.Now()
+
+	ruleExceptions, ruleExceptionsWarnings := vs.getRuleExceptions(requestLogger, config.RuleExceptions, ruleExceptionsRequest{
+		resourceName:       resourceName,
+		namespace:          namespace,
+		annotations:        rootObject.Metadata.Annotations,
+		userInfo:           admissionReviewReq.Request.UserInfo,
+		openShiftRequester: openShiftRequester,
+		isAudit:            isAudit,
+		now:                now,
+	})
+	policyExceptions, policyExceptionsWarnings := vs.getPolicyExceptions(policyException.MatchedResource{
+		Namespace: namespace,
+		Kind:      admissionReviewReq.Request.Kind.Kind,
+		Name:      resourceName,
+		Labels:    rootObject.Metadata.Labels,
+	}, now)
+	return append(ruleExceptions, policyExceptions...), append(ruleExceptionsWarnings, policyExceptionsWarnings...)
+}
+
+// getPolicyExceptions returns the exceptions of the DatreePolicyExceptions that apply to the resource, and a warning for
+// each of those exceptions
+func (vs *ValidationService) getPolicyExceptions(resource policyException.MatchedResource, now time.Time) (exceptions []RuleException, warningMessages []string) {
+	for _, activeException := range vs.PolicyExceptions.GetActiveExceptions(resource, now) {
+		exceptionName := fmt.Sprintf("%s/%s", activeException.Namespace, activeException.Name)
+		for _, ruleIdentifier := range activeException.Spec.Rules {
+			exceptions = append(exceptions, RuleException{
+				RuleIdentifier:  ruleIdentifier,
+				Justification:   activeException.Spec.Justification,
+				ExpiresAt:       activeException.Spec.ExpiresAt.Time,
+				PolicyException: exceptionName,
+				ApprovedBy:      activeException.Spec.ApprovedBy,
+			})
+		}
+		warningMessages = append(warningMessages, fmt.Sprintf("🛡️ Object with name \"%s\" is excepted from rules %s until %s by policy exception %s, approved by %s",
+			resource.Name, strings.Join(activeException.Spec.Rules, ", "), activeException.Spec.ExpiresAt.Format(time.RFC3339), exceptionName, activeException.Spec.ApprovedBy))
+	}
+	return exceptions, warningMessages
+}
+
+// applyRuleExceptions the excepted rules are skipped the way the datree.skip/<rule identifier> annotations skip them,
+// so they don't fail the policy check and are reported as skipped in the results
+func applyRuleExceptions(filesConfigurations []*extractor.FileConfigurations, exceptions []RuleException) {
+	for _, fileConfigurations := range filesConfigurations {
+		for i := range fileConfigurations.Configurations {
+			configuration := &fileConfigurations.Configurations[i]
+			if configuration.Annotations == nil {
+				configuration.Annotations = map[string]interface{}{}
+			}
+			for _, exception := range exceptions {
+				configuration.Annotations[evaluation.SKIP_RULE_PREFIX+exception.RuleIdentifier] = getSkipMessage(exception)
+			}
+		}
+	}
+}
+
+func getSkipMessage(exception RuleException) string {
+	skipMessage := fmt.Sprintf("excepted until %s", exception.ExpiresAt.Format(time.RFC3339))
+	if exception.PolicyException != "" {
+		skipMessage = fmt.Sprintf("%s by policy exception %s, approved by %s", skipMessage, exception.PolicyException, exception.ApprovedBy)
+	}
+	if exception.Justification != "" {
+		skipMessage = fmt.Sprintf("%s: %s", skipMessage, exception.Justification)
+	}
+	return skipMessage
+}
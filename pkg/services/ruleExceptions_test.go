@@ -0,0 +1,135 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/datreeio/datree/pkg/extractor"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+var ruleExceptionsNow = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func mockRuleExceptionsRequest(annotations map[string]string) ruleExceptionsRequest {
+	return ruleExceptionsRequest{
+		resourceName: "my-deployment",
+		namespace:    "dev-1",
+		annotations:  annotations,
+		userInfo:     authenticationv1.UserInfo{Username: "my-user", Groups: []string{"platform-team"}},
+		now:          ruleExceptionsNow,
+	}
+}
+
+func mockRuleExceptionsAnnotations(rules string, expires string) map[string]string {
+	return map[string]string{
+		exceptionRulesAnnotation:         rules,
+		exceptionJustificationAnnotation: "the limits are tuned in JIRA-123",
+		exceptionExpiresAnnotation:       expires,
+	}
+}
+
+func TestGetRuleExceptions(t *testing.T) {
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	validationService := &ValidationService{Logger: &mockLogger}
+	ruleExceptions := &servicestate.RuleExceptions{Allowances: []servicestate.RuleExceptionAllowance{{
+		Rules:             []string{"CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "CONTAINERS_MISSING_CPU_LIMIT_KEY"},
+		NamespacePatterns: []string{"^dev-"},
+		Requesters:        &servicestate.BypassPermissions{Groups: []string{"^platform-team$"}},
+		MaxDurationDays:   30,
+	}}}
+
+	t.Run("should return the allowed exceptions", func(t *testing.T) {
+		exceptions, warningMessages := validationService.getRuleExceptions(&mockLogger, ruleExceptions, mockRuleExceptionsRequest(
+			mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY, CONTAINERS_MISSING_CPU_LIMIT_KEY", "2023-06-15T00:00:00Z")))
+
+		expiresAt := time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, []RuleException{
+			{RuleIdentifier: "CONTAINERS_MISSING_MEMORY_LIMIT_KEY", Justification: "the limits are tuned in JIRA-123", ExpiresAt: expiresAt},
+			{RuleIdentifier: "CONTAINERS_MISSING_CPU_LIMIT_KEY", Justification: "the limits are tuned in JIRA-123", ExpiresAt: expiresAt},
+		}, exceptions)
+		assert.Equal(t, []string{"🛡️ Object with name \"my-deployment\" is excepted from rules CONTAINERS_MISSING_MEMORY_LIMIT_KEY, CONTAINERS_MISSING_CPU_LIMIT_KEY until 2023-06-15T00:00:00Z: the limits are tuned in JIRA-123"}, warningMessages)
+	})
+
+	t.Run("should return nothing when the resource declares no exception", func(t *testing.T) {
+		exceptions, warningMessages := validationService.getRuleExceptions(&mockLogger, ruleExceptions, mockRuleExceptionsRequest(nil))
+
+		assert.Empty(t, exceptions)
+		assert.Empty(t, warningMessages)
+	})
+
+	t.Run("should ignore the exceptions that are expired or invalid", func(t *testing.T) {
+		for name, annotations := range map[string]map[string]string{
+			"expired":               mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "2023-05-31T00:00:00Z"),
+			"invalid expiry":        mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "next week"),
+			"missing justification": {exceptionRulesAnnotation: "CONTAINERS_MISSING_MEMORY_LIMIT_KEY", exceptionExpiresAnnotation: "2023-06-15T00:00:00Z"},
+		} {
+			exceptions, warningMessages := validationService.getRuleExceptions(&mockLogger, ruleExceptions, mockRuleExceptionsRequest(annotations))
+
+			assert.Empty(t, exceptions, name)
+			assert.Len(t, warningMessages, 1, name)
+			assert.Contains(t, warningMessages[0], "were ignored", name)
+		}
+	})
+
+	t.Run("should ignore the exceptions that no allowance matches", func(t *testing.T) {
+		notAllowedRule := mockRuleExceptionsRequest(mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY,INGRESS_INCORRECT_HOST_VALUE_PERMISSIVE", "2023-06-15T00:00:00Z"))
+		notAllowedNamespace := mockRuleExceptionsRequest(mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "2023-06-15T00:00:00Z"))
+		notAllowedNamespace.namespace = "prod-1"
+		notAllowedRequester := mockRuleExceptionsRequest(mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "2023-06-15T00:00:00Z"))
+		notAllowedRequester.userInfo.Groups = nil
+		tooLong := mockRuleExceptionsRequest(mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "2023-12-31T00:00:00Z"))
+
+		exceptions, warningMessages := validationService.getRuleExceptions(&mockLogger, ruleExceptions, notAllowedRule)
+		assert.Len(t, exceptions, 1)
+		assert.Len(t, warningMessages, 2)
+		assert.Contains(t, warningMessages[1], "rules INGRESS_INCORRECT_HOST_VALUE_PERMISSIVE may not be excepted")
+
+		for name, request := range map[string]ruleExceptionsRequest{"namespace": notAllowedNamespace, "requester": notAllowedRequester, "too long": tooLong} {
+			exceptions, warningMessages := validationService.getRuleExceptions(&mockLogger, ruleExceptions, request)
+			assert.Empty(t, exceptions, name)
+			assert.Len(t, warningMessages, 1, name)
+		}
+
+		exceptions, _ = validationService.getRuleExceptions(&mockLogger, nil, notAllowedNamespace)
+		assert.Empty(t, exceptions)
+	})
+
+	t.Run("should not check the requester of an audited resource", func(t *testing.T) {
+		request := mockRuleExceptionsRequest(mockRuleExceptionsAnnotations("CONTAINERS_MISSING_MEMORY_LIMIT_KEY", "2023-06-15T00:00:00Z"))
+		request.userInfo = authenticationv1.UserInfo{Username: auditUsername}
+		request.isAudit = true
+
+		exceptions, _ := validationService.getRuleExceptions(&mockLogger, ruleExceptions, request)
+
+		assert.Len(t, exceptions, 1)
+	})
+}
+
+func TestApplyRuleExceptions(t *testing.T) {
+	filesConfigurations := []*extractor.FileConfigurations{{FileName: "my-deployment", Configurations: []extractor.Configuration{{MetadataName: "my-deployment"}}}}
+
+	applyRuleExceptions(filesConfigurations, []RuleException{{
+		RuleIdentifier: "CONTAINERS_MISSING_MEMORY_LIMIT_KEY",
+		Justification:  "the limits are tuned in JIRA-123",
+		ExpiresAt:      time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC),
+	}})
+
+	assert.Equal(t, map[string]interface{}{
+		"datree.skip/CONTAINERS_MISSING_MEMORY_LIMIT_KEY": "excepted until 2023-06-15T00:00:00Z: the limits are tuned in JIRA-123",
+	}, filesConfigurations[0].Configurations[0].Annotations)
+}
+
+func TestGetSkipMessage(t *testing.T) {
+	assert.Equal(t, "excepted until 2023-06-15T00:00:00Z by policy exception my-namespace/my-exception, approved by compliance-team: the limits are tuned in JIRA-123",
+		getSkipMessage(RuleException{
+			RuleIdentifier:  "CONTAINERS_MISSING_MEMORY_LIMIT_KEY",
+			Justification:   "the limits are tuned in JIRA-123",
+			ExpiresAt:       time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC),
+			PolicyException: "my-namespace/my-exception",
+			ApprovedBy:      "compliance-team",
+		}))
+}
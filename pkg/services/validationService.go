@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/openshiftService"
@@ -16,12 +18,17 @@ import (
 	authenticationv1 "k8s.io/api/authentication/v1"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/errorReporter"
+	"github.com/datreeio/admission-webhook-datree/pkg/eventRecorder"
+	"github.com/datreeio/admission-webhook-datree/pkg/namespaceLabels"
+	"github.com/datreeio/admission-webhook-datree/pkg/policyException"
 	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/k8sMetadataUtil"
 
 	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/admission-webhook-datree/pkg/metrics"
 	"github.com/datreeio/admission-webhook-datree/pkg/server"
+	"github.com/prometheus/client_golang/prometheus"
 
 	cliDefaultRules "github.com/datreeio/datree/pkg/defaultRules"
 
@@ -29,7 +36,6 @@ import (
 
 	policyFactory "github.com/datreeio/datree/bl/policy"
 	"github.com/datreeio/datree/pkg/ciContext"
-	baseCliClient "github.com/datreeio/datree/pkg/cliClient"
 	"github.com/datreeio/datree/pkg/evaluation"
 	"github.com/datreeio/datree/pkg/extractor"
 	"github.com/datreeio/datree/pkg/printer"
@@ -38,9 +44,12 @@ import (
 	cliClient "github.com/datreeio/admission-webhook-datree/pkg/clients"
 
 	"github.com/ghodss/yaml"
+	"github.com/google/uuid"
 	admission "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/strings/slices"
 )
 
 type ManagedFields struct {
@@ -49,6 +58,7 @@ type ManagedFields struct {
 
 type Metadata struct {
 	Name              string                     `json:"name"`
+	Uid               k8sTypes.UID               `json:"uid"`
 	DeletionTimestamp string                     `json:"deletionTimestamp"`
 	ManagedFields     []ManagedFields            `json:"managedFields"`
 	Labels            map[string]string          `json:"labels"`
@@ -57,38 +67,76 @@ type Metadata struct {
 }
 
 type ValidationService struct {
-	CliServiceClient *cliClient.CliClient
-	K8sMetadataUtil  *k8sMetadataUtil.K8sMetadataUtil
-	ErrorReporter    *errorReporter.ErrorReporter
-	State            *servicestate.ServiceState
-	OpenshiftService *openshiftService.OpenshiftService
-	Logger           *logger.Logger
+	CliServiceClient   *cliClient.CliClient
+	K8sMetadataUtil    *k8sMetadataUtil.K8sMetadataUtil
+	ErrorReporter      *errorReporter.ErrorReporter
+	State              *servicestate.ServiceState
+	OpenshiftService   *openshiftService.OpenshiftService
+	PrerunDataProvider PrerunDataProvider
+	// EventRecorder is nil when Kubernetes Events are disabled
+	EventRecorder *eventRecorder.EventRecorder
+	// PolicyExceptions is nil when the DatreePolicyExceptions are disabled
+	PolicyExceptions *policyException.Store
+	// NamespaceLabels is nil when the namespaces can't be watched
+	NamespaceLabels *namespaceLabels.Cache
+	ResultSinks     *ResultSinks
+	Logger          *logger.Logger
 }
 
-func (vs *ValidationService) Validate(admissionReviewReq *admission.AdmissionReview, warningMessages *[]string) (admissionReview *admission.AdmissionReview, isSkipped bool) {
+const (
+	// nonEssentialCallsMinBudget the version messages are only waited for when at least this much of the request budget
+	// is left, otherwise they are fetched in the background so the decision is returned in time
+	nonEssentialCallsMinBudget = 5 * time.Second
+	// backgroundCallTimeout bounds the backend calls that were moved to the background
+	backgroundCallTimeout = 30 * time.Second
+)
+
+// Validate logs with requestLogger, a child of vs.Logger bound to the admission request.
+// ctx carries the request budget, the backend calls return once it is done
+func (vs *ValidationService) Validate(ctx context.Context, admissionReviewReq *admission.AdmissionReview, warningMessages *[]string, requestLogger *logger.Logger) (admissionReview *admission.AdmissionReview, isSkipped bool) {
+	validateTimer := prometheus.NewTimer(metrics.ValidateDurationSeconds)
+	defer validateTimer.ObserveDuration()
+	return vs.validate(ctx, admissionReviewReq, warningMessages, requestLogger, false)
+}
+
+// Audit evaluates a resource that is already in the cluster the way it would be admitted, the results are sent to the
+// result sinks. the admission side effects, the admission metrics, the Kubernetes Events and the request metadata, are skipped
+func (vs *ValidationService) Audit(ctx context.Context, admissionReviewReq *admission.AdmissionReview, requestLogger *logger.Logger) (admissionReview *admission.AdmissionReview, isSkipped bool) {
+	var warningMessages []string
+	return vs.validate(ctx, admissionReviewReq, &warningMessages, requestLogger, true)
+}
+
+func (vs *ValidationService) validate(ctx context.Context, admissionReviewReq *admission.AdmissionReview, warningMessages *[]string, requestLogger *logger.Logger, isAudit bool) (admissionReview *admission.AdmissionReview, isSkipped bool) {
 	startTime := time.Now()
+	recordOutcome := metrics.RecordAdmission
+	if isAudit {
+		recordOutcome = metrics.RecordAuditEvaluation
+	}
 	msg := "We're good!"
 	cliEvaluationId := -1
 	var err error
-
-	ciContext := ciContext.Extract()
+	cliServiceClient := vs.CliServiceClient.WithLogger(requestLogger).WithContext(ctx)
 
 	clusterK8sVersion := vs.State.GetK8sVersion()
 	token := vs.State.GetToken()
-	if token == "" {
+	if token == "" && !vs.State.GetIsOfflineMode() {
 		errorMessage := "no DATREE_TOKEN was found in env"
 		vs.ErrorReporter.ReportUnexpectedError(errors.New(errorMessage))
-		vs.Logger.LogError(errorMessage)
+		requestLogger.LogError(errorMessage)
 	}
 
 	rootObject := getResourceRootObject(admissionReviewReq)
 	namespace, resourceKind, resourceName, managers := getResourceMetadata(admissionReviewReq, rootObject)
 	resourceUserInfo := admissionReviewReq.Request.UserInfo
 	enabledWarnings := vs.State.GetEnabledWarnings()
+	config := vs.State.GetConfig()
 
 	saveMetadataAndReturnAResponseForSkippedResource := func(addSkipWarning bool) (admissionReview *admission.AdmissionReview, isSkipped bool) {
-		clusterRequestMetadata := getClusterRequestMetadata(vs.State.GetClusterUuid(), vs.State.GetServiceVersion(), cliEvaluationId, token, true, true, resourceKind, resourceName, managers, clusterK8sVersion, "", namespace, server.ConfigMapScanningFilters, rootObject.Metadata.OwnerReferences)
-		vs.saveRequestMetadataLogInAggregator(clusterRequestMetadata)
+		clusterRequestMetadata := getClusterRequestMetadata(vs.State.GetClusterUuid(), vs.State.GetServiceVersion(), cliEvaluationId, token, true, true, resourceKind, resourceName, managers, clusterK8sVersion, "", namespace, server.ConfigMapScanningFiltersType{SkipList: servicestate.GetSkipListStrings(config.SkipList)}, rootObject.Metadata.OwnerReferences)
+		if !isAudit {
+			vs.saveRequestMetadataLogInAggregator(clusterRequestMetadata, requestLogger)
+		}
+		recordOutcome(metrics.OutcomeSkipped, resourceKind, namespace, "")
 		if addSkipWarning && enabledWarnings.SkippedBySkipList {
 			*warningMessages = append([]string{
 				fmt.Sprintf("⏩ Object with name \"%s\" was skipped by Datree's policy check.", resourceName),
@@ -98,57 +146,72 @@ func (vs *ValidationService) Validate(admissionReviewReq *admission.AdmissionRev
 		return ParseEvaluationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, true, msg, *warningMessages), true
 	}
 
-	shouldValidatedResourceData := ShouldResourceBeValidated(admissionReviewReq, rootObject)
+	shouldValidatedResourceData := ShouldResourceBeValidated(admissionReviewReq, rootObject, requestLogger)
 
 	if !shouldValidatedResourceData.ShouldValidate {
 		return saveMetadataAndReturnAResponseForSkippedResource(false)
 	}
 
-	prerunData, err := vs.CliServiceClient.RequestClusterEvaluationPrerunData(token, vs.State.GetClusterUuid())
+	prerunData, err := vs.PrerunDataProvider.GetPrerunData(ctx)
 	if err != nil {
-		vs.Logger.LogAndReportUnexpectedError(fmt.Sprintf("Getting prerun data err: %s", err.Error()))
+		requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("Getting prerun data err: %s", err.Error()))
+		recordOutcome(metrics.OutcomeError, resourceKind, namespace, "")
 
 		prerunWarningMsg := "Datree failed to run policy check - an error occurred when pulling your policy"
+		if config.FailurePolicy.IsFailClosed(namespace) {
+			return ParseEvaluationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, false, GetFailClosedMessage(prerunWarningMsg), *warningMessages), false
+		}
 		*warningMessages = append(*warningMessages, prerunWarningMsg)
 		return ParseEvaluationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, true, msg, *warningMessages), true
 	}
-	if !vs.State.GetConfigFromHelm() {
-		vs.State.SetIsEnforceMode(prerunData.ActionOnFailure == enums.EnforceActionOnFailure)
-		server.OverrideSkipList(prerunData.IgnorePatterns)
-		vs.State.SetBypassPermissions(&prerunData.BypassPermissions)
-	}
+	config = applyPrerunDataConfig(vs.State, config, prerunData, requestLogger)
 
-	if ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject) {
+	if ShouldResourceBeSkippedByConfigMapScanningFilters(admissionReviewReq, rootObject, config.SkipList, requestLogger) {
 		return saveMetadataAndReturnAResponseForSkippedResource(true)
 	}
 
-	// convert default rules string into DefaultRulesDefinitions structure
-	defaultRules, err := cliDefaultRules.YAMLToStruct(prerunData.DefaultRulesYaml)
-	if err != nil {
-		// get default rules from cli binary on failure
-		defaultRules, err = cliDefaultRules.GetDefaultRules()
-		// panic if didn't manage to get default rules
-		if err != nil {
-			panic(err)
-		}
-	}
+	defaultRules := getDefaultRules(prerunData)
 
-	filesConfigurations := getFileConfiguration(admissionReviewReq.Request)
+	filesConfigurations := getFileConfiguration(admissionReviewReq.Request, admissionReviewReq.Request.Object.Raw)
+	ruleExceptions, ruleExceptionsWarnings := vs.getResourceExceptions(requestLogger, config, admissionReviewReq, rootObject, shouldValidatedResourceData.OpenShiftRequester, isAudit)
+	applyRuleExceptions(filesConfigurations, ruleExceptions)
+	*warningMessages = append(*warningMessages, ruleExceptionsWarnings...)
+	// oldFilesConfigurations is only set when the update is only denied for the violations it introduces
+	var oldFilesConfigurations []*extractor.FileConfigurations
+	if !isAudit && admissionReviewReq.Request.Operation == admission.Update && admissionReviewReq.Request.OldObject.Raw != nil && config.NewViolationsOnly.IsEnabled(namespace) {
+		oldFilesConfigurations = getFileConfiguration(admissionReviewReq.Request, admissionReviewReq.Request.OldObject.Raw)
+	}
 
-	evaluator := evaluation.New(vs.CliServiceClient, ciContext)
+	evaluator := getEvaluator()
 
 	allowed := true
 
 	sb := strings.Builder{}
 
+	// denyByFailurePolicy the policy check of the current policy failed to run and the namespace fails closed
+	denyByFailurePolicy := func(reason string) {
+		allowed = false
+		sb.WriteString("\n---\n")
+		sb.WriteString(GetFailClosedMessage(reason))
+		recordOutcome(metrics.OutcomeError, resourceKind, namespace, "")
+	}
+
+	policyScope := policyScopeResource{Kind: resourceKind, Labels: rootObject.Metadata.Labels, Operation: string(admissionReviewReq.Request.Operation)}
+	getNamespaceLabels := namespaceLabelsGetter(ctx, vs.NamespaceLabels, namespace, requestLogger)
+	scopedPoliciesCount := 0
 	for _, policyName := range prerunData.ActivePolicies {
-		if !vs.shouldPolicyRunForNamespace(policyName, namespace) {
+		if !shouldPolicyRunForResource(config.MultiplePolicies, policyName, policyScope) || !shouldPolicyRunForNamespace(config.MultiplePolicies, policyName, namespace, getNamespaceLabels) {
 			continue
 		}
+		scopedPoliciesCount++
 
 		// create policy
 		policy, err := policyFactory.CreatePolicy(prerunData.PoliciesJson, policyName, prerunData.RegistrationURL, defaultRules, false)
 		if err != nil {
+			if config.FailurePolicy.IsFailClosed(namespace) {
+				denyByFailurePolicy(fmt.Sprintf("Policy %s not found", policyName))
+				continue
+			}
 			*warningMessages = append(*warningMessages, fmt.Sprintf("Policy %s not found, skipping evaluation", policyName))
 			continue
 		}
@@ -161,9 +224,18 @@ func (vs *ValidationService) Validate(admissionReviewReq *admission.AdmissionRev
 		}
 
 		// evaluate policy against configuration
+		evaluationTimer := prometheus.NewTimer(metrics.PolicyEvaluationDurationSeconds.WithLabelValues(policyName))
 		policyCheckResults, err := evaluator.Evaluate(policyCheckData)
+		evaluationTimer.ObserveDuration()
 		if err != nil {
-			vs.Logger.LogAndReportUnexpectedError(fmt.Sprintf("Evaluate err: %s", err.Error()))
+			requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("Evaluate err: %s", err.Error()))
+			if config.FailurePolicy.IsFailClosed(namespace) {
+				denyByFailurePolicy(fmt.Sprintf("Policy %s failed to be evaluated", policyName))
+				continue
+			}
+			recordOutcome(metrics.OutcomeError, resourceKind, namespace, "")
+			*warningMessages = append(*warningMessages, fmt.Sprintf("Policy %s failed to be evaluated, skipping evaluation", policyName))
+			continue
 		}
 
 		results := policyCheckResults.FormattedResults
@@ -174,19 +246,7 @@ func (vs *ValidationService) Validate(admissionReviewReq *admission.AdmissionRev
 
 		evaluationSummary := getEvaluationSummary(policyCheckResults, passedPolicyCheckCount)
 
-		// send results to backend
-		noRecords := os.Getenv(enums.NoRecord)
-		if noRecords != "true" {
-			evaluationResultResp, err := vs.sendEvaluationResult(vs.getEvaluationRequestData(policy.Name, startTime,
-				policyCheckResults, namespace, resourceKind, resourceName))
-			if err == nil {
-				cliEvaluationId = evaluationResultResp.EvaluationId
-			} else {
-				cliEvaluationId = -2
-				vs.Logger.LogAndReportUnexpectedError("saving evaluation results failed")
-				*warningMessages = append(*warningMessages, "saving evaluation results failed")
-			}
-		}
+		actionOnFailure := vs.getPolicyActionOnFailure(config, policyName, prerunData)
 
 		// get results text
 		resultStr, err := evaluation.GetResultsText(&evaluation.PrintResultsData{
@@ -200,17 +260,77 @@ func (vs *ValidationService) Validate(admissionReviewReq *admission.AdmissionRev
 			OutputFormat:      os.Getenv(enums.Output),
 		})
 		if err != nil {
-			vs.Logger.LogAndReportUnexpectedError(fmt.Sprintf("GetResultsText err: %s", err.Error()))
+			requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("GetResultsText err: %s", err.Error()))
 		}
 
 		didFailCurrentPolicyCheck := evaluationSummary.PassedPolicyCheckCount == 0
-		shouldBypassByPermissions := vs.shouldBypassByPermissions(resourceUserInfo, shouldValidatedResourceData.OpenShiftRequester)
+		shouldBypassByPermissions := vs.shouldBypassByPermissions(requestLogger, config.BypassPermissions, resourceUserInfo, shouldValidatedResourceData.OpenShiftRequester)
+
+		// updateViolations is nil unless the update is only denied for the violations it introduces
+		var updateViolations *violations
+		if didFailCurrentPolicyCheck && oldFilesConfigurations != nil && actionOnFailure == enums.EnforceActionOnFailure && !shouldBypassByPermissions {
+			var violationsErr error
+			updateViolations, violationsErr = getViolations(evaluator, policyCheckData, oldFilesConfigurations, policyCheckResults.RawResults)
+			if violationsErr != nil {
+				requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("Evaluate old object err: %s", violationsErr.Error()))
+			}
+		}
+		isDenied := didFailCurrentPolicyCheck && actionOnFailure == enums.EnforceActionOnFailure && !shouldBypassByPermissions && !updateViolations.hasOnlyPreExisting()
 
-		if didFailCurrentPolicyCheck && vs.State.GetIsEnforceMode() && !shouldBypassByPermissions {
+		// send results to the result sinks
+		noRecords := os.Getenv(enums.NoRecord)
+		evaluationUploadId := ""
+		if noRecords != "true" {
+			evaluationUploadId, err = vs.sendEvaluationResult(vs.getEvaluationRequestData(policy.Name, startTime,
+				policyCheckResults, namespace, resourceKind, resourceName, actionOnFailure == enums.EnforceActionOnFailure), admissionReviewReq.Request.Kind, rootObject.Metadata.Uid, actionOnFailure, !didFailCurrentPolicyCheck, !isDenied, isAudit, isDryRun(admissionReviewReq.Request), ruleExceptions)
+			if err != nil {
+				cliEvaluationId = -2
+				requestLogger.LogAndReportUnexpectedError(fmt.Sprintf("saving evaluation results failed, err: %s", err))
+				*warningMessages = append(*warningMessages, "saving evaluation results failed")
+			}
+		}
+
+		invocationUrl := ""
+		if !vs.State.GetIsOfflineMode() {
+			// the results are uploaded after the response, so the evaluation id the backend assigns isn't known yet.
+			// the link is built with the upload id the webhook generated instead, which the backend accepts for the upload
+			invocationUrl = getInvocationUrl(prerunData.RegistrationURL, evaluationUploadId)
+		}
+
+		if didFailCurrentPolicyCheck && !isAudit {
+			vs.EventRecorder.RecordPolicyFailure(eventRecorder.PolicyFailure{
+				Request:         admissionReviewReq.Request,
+				ResourceName:    resourceName,
+				ResourceUid:     rootObject.Metadata.Uid,
+				PolicyName:      policyName,
+				FailedRuleNames: getFailedRuleNames(policyCheckResults.RawResults),
+				EvaluationUrl:   invocationUrl,
+				IsDenied:        isDenied,
+			})
+		}
+
+		if updateViolations != nil && len(updateViolations.preExistingRuleNames) > 0 {
+			*warningMessages = append([]string{
+				getPreExistingViolationsWarning(resourceName, policyName, updateViolations.preExistingRuleNames),
+			}, *warningMessages...)
+		}
+
+		if isDenied {
 			allowed = false
 
 			sb.WriteString("\n---\n")
+			if updateViolations != nil {
+				sb.WriteString(getNewViolationsMessage(policyName, updateViolations.newRuleNames))
+				sb.WriteString("\n")
+			}
 			sb.WriteString(resultStr)
+			recordOutcome(metrics.OutcomeDenied, resourceKind, namespace, policyName)
+		} else if updateViolations.hasOnlyPreExisting() {
+			recordOutcome(metrics.OutcomeAllowed, resourceKind, namespace, policyName)
+		} else if didFailCurrentPolicyCheck && actionOnFailure == enums.EnforceActionOnFailure {
+			recordOutcome(metrics.OutcomeBypassed, resourceKind, namespace, policyName)
+		} else {
+			recordOutcome(metrics.OutcomeAllowed, resourceKind, namespace, policyName)
 		}
 
 		if shouldBypassByPermissions && didFailCurrentPolicyCheck {
@@ -219,106 +339,152 @@ func (vs *ValidationService) Validate(admissionReviewReq *admission.AdmissionRev
 					"🚩 Your resource failed the policy check, but it has been applied due to your bypass privileges",
 				}, *warningMessages...)
 			}
-		} else if !vs.State.GetIsEnforceMode() {
-			baseUrl := strings.Split(prerunData.RegistrationURL, "datree.io")[0] + "datree.io"
-			invocationUrl := fmt.Sprintf("%s/cli/invocations/%d?webhook=true", baseUrl, cliEvaluationId)
-			if didFailCurrentPolicyCheck && enabledWarnings.FailedPolicyCheck {
-				*warningMessages = append([]string{
+		} else if actionOnFailure != enums.EnforceActionOnFailure {
+			var reportWarningMessages []string
+			if invocationUrl != "" {
+				reportWarningMessages = append(reportWarningMessages, fmt.Sprintf("👉 Get the full report %s", invocationUrl))
+			}
+			if didFailCurrentPolicyCheck && (enabledWarnings.FailedPolicyCheck || actionOnFailure == enums.WarnActionOnFailure) {
+				*warningMessages = append(append([]string{
 					fmt.Sprintf("🚩 Object with name \"%s\" and kind \"%s\" failed the policy check for policy \"%s\"", resourceName, resourceKind, policyName),
-					fmt.Sprintf("👉 Get the full report %s", invocationUrl),
-				}, *warningMessages...)
+				}, reportWarningMessages...), *warningMessages...)
 			} else if !didFailCurrentPolicyCheck && enabledWarnings.PassedPolicyCheck {
-				*warningMessages = append([]string{
+				*warningMessages = append(append([]string{
 					fmt.Sprintf("✅  Object with name \"%s\" and kind \"%s\" passed Datree's policy check for policy \"%s\"", resourceName, resourceKind, policyName),
-					fmt.Sprintf("👉 Get the full report %s", invocationUrl),
-				}, *warningMessages...)
+				}, reportWarningMessages...), *warningMessages...)
 			}
 		}
 	}
 
+	// the resource is counted once when multiplePolicies doesn't run any of the policies for it
+	if scopedPoliciesCount == 0 {
+		recordOutcome(metrics.OutcomeSkipped, resourceKind, namespace, "")
+	}
+
 	msg = sb.String()
 
-	verifyVersionResponse, err := vs.CliServiceClient.GetVersionRelatedMessages(vs.State.GetServiceVersion())
-	if err != nil {
-		*warningMessages = append(*warningMessages, err.Error())
-	} else {
-		if verifyVersionResponse != nil {
-			*warningMessages = append(*warningMessages, verifyVersionResponse.MessageTextArray...)
+	if isAudit {
+		return ParseEvaluationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, allowed, msg, *warningMessages), false
+	}
+
+	if !vs.State.GetIsOfflineMode() && !hasBudgetForNonEssentialCalls(ctx) {
+		*warningMessages = append(*warningMessages, getLastVersionMessages()...)
+		go func() {
+			backgroundContext, cancel := context.WithTimeout(context.Background(), backgroundCallTimeout)
+			defer cancel()
+			if verifyVersionResponse, err := cliServiceClient.WithContext(backgroundContext).GetVersionRelatedMessages(vs.State.GetServiceVersion()); err == nil && verifyVersionResponse != nil {
+				lastVersionMessages.Store(&verifyVersionResponse.MessageTextArray)
+			}
+		}()
+	} else if !vs.State.GetIsOfflineMode() {
+		verifyVersionResponse, err := cliServiceClient.GetVersionRelatedMessages(vs.State.GetServiceVersion())
+		if err != nil {
+			*warningMessages = append(*warningMessages, err.Error())
+		} else {
+			if verifyVersionResponse != nil {
+				lastVersionMessages.Store(&verifyVersionResponse.MessageTextArray)
+				*warningMessages = append(*warningMessages, verifyVersionResponse.MessageTextArray...)
+			}
 		}
 	}
 
-	clusterRequestMetadata := getClusterRequestMetadata(vs.State.GetClusterUuid(), vs.State.GetServiceVersion(), cliEvaluationId, token, false, allowed, resourceKind, resourceName, managers, clusterK8sVersion, vs.State.GetPolicyName(), namespace, server.ConfigMapScanningFilters, rootObject.Metadata.OwnerReferences)
-	vs.saveRequestMetadataLogInAggregator(clusterRequestMetadata)
+	clusterRequestMetadata := getClusterRequestMetadata(vs.State.GetClusterUuid(), vs.State.GetServiceVersion(), cliEvaluationId, token, false, allowed, resourceKind, resourceName, managers, clusterK8sVersion, vs.State.GetPolicyName(), namespace, server.ConfigMapScanningFiltersType{SkipList: servicestate.GetSkipListStrings(config.SkipList)}, rootObject.Metadata.OwnerReferences)
+	vs.saveRequestMetadataLogInAggregator(clusterRequestMetadata, requestLogger)
 	return ParseEvaluationResponseIntoAdmissionReview(admissionReviewReq.Request.UID, allowed, msg, *warningMessages), false
 }
 
-func (m *clusterRequestMetadataMap) Len() int {
-	return m.entriesCount
+// hasBudgetForNonEssentialCalls returns true when the request budget leaves time to wait for the non-essential backend calls
+func hasBudgetForNonEssentialCalls(ctx context.Context) bool {
+	deadline, hasDeadline := ctx.Deadline()
+	return !hasDeadline || time.Until(deadline) > nonEssentialCallsMinBudget
 }
-func (m *clusterRequestMetadataMap) ShouldSendBatchToServer() bool {
-	return m.entriesCount >= 500
+
+// lastVersionMessages the version messages fetched last, shown instead when there is no budget left to fetch them
+var lastVersionMessages atomic.Pointer[[]string]
+
+func getLastVersionMessages() []string {
+	if versionMessages := lastVersionMessages.Load(); versionMessages != nil {
+		return *versionMessages
+	}
+	return nil
+}
+
+// MetadataAggregatorSize returns the amount of request metadata entries waiting to be sent in the next batch
+func MetadataAggregatorSize() int {
+	return clusterRequestMetadataAggregatorMap.Len()
 }
 
-func (m *clusterRequestMetadataMap) LoadOrStore(logJson string, clusterRequestMetadata *cliClient.ClusterRequestMetadata) {
-	existingLog, loaded := m.clusterRequestMetadataAggregator.LoadOrStore(logJson, clusterRequestMetadata)
-	if loaded {
-		existingLog.(*cliClient.ClusterRequestMetadata).Occurrences++
+func (m *clusterRequestMetadataMap) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.clusterRequestMetadataAggregator)
+}
+
+// LoadOrStore returns true when the aggregator is full and should be sent to the server
+func (m *clusterRequestMetadataMap) LoadOrStore(logJson string, clusterRequestMetadata *cliClient.ClusterRequestMetadata) (shouldSendBatchToServer bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if existingLog, loaded := m.clusterRequestMetadataAggregator[logJson]; loaded {
+		existingLog.Occurrences++
 	} else {
-		m.entriesCount += 1
+		m.clusterRequestMetadataAggregator[logJson] = clusterRequestMetadata
 	}
+	return len(m.clusterRequestMetadataAggregator) >= 500
 }
 
-func (m *clusterRequestMetadataMap) Clear() {
-	m.clusterRequestMetadataAggregator = &sync.Map{}
-	m.entriesCount = 0
+// Drain returns all the aggregated request metadata and clears the aggregator
+func (m *clusterRequestMetadataMap) Drain() []*cliClient.ClusterRequestMetadata {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	clusterRequestMetadataArray := make([]*cliClient.ClusterRequestMetadata, 0, len(m.clusterRequestMetadataAggregator))
+	for _, clusterRequestMetadata := range m.clusterRequestMetadataAggregator {
+		clusterRequestMetadataArray = append(clusterRequestMetadataArray, clusterRequestMetadata)
+	}
+	m.clusterRequestMetadataAggregator = make(map[string]*cliClient.ClusterRequestMetadata)
+	return clusterRequestMetadataArray
 }
 
 type clusterRequestMetadataMap struct {
-	entriesCount                     int
-	clusterRequestMetadataAggregator *sync.Map
+	mutex                            sync.Mutex
+	clusterRequestMetadataAggregator map[string]*cliClient.ClusterRequestMetadata
 }
 
 func clusterRequestMetadataMapNew() *clusterRequestMetadataMap {
 	return &clusterRequestMetadataMap{
-		entriesCount:                     0,
-		clusterRequestMetadataAggregator: &sync.Map{},
+		clusterRequestMetadataAggregator: make(map[string]*cliClient.ClusterRequestMetadata),
 	}
 }
 
 var clusterRequestMetadataAggregatorMap = clusterRequestMetadataMapNew()
 
-func (vs *ValidationService) saveRequestMetadataLogInAggregator(clusterRequestMetadata *cliClient.ClusterRequestMetadata) {
+func (vs *ValidationService) saveRequestMetadataLogInAggregator(clusterRequestMetadata *cliClient.ClusterRequestMetadata, requestLogger *logger.Logger) {
 	logJsonInBytes, err := json.Marshal(clusterRequestMetadata)
 	if err != nil {
 		vs.ErrorReporter.ReportUnexpectedError(err)
-		vs.Logger.LogError(err.Error())
+		requestLogger.LogError(err.Error())
 		return
 	}
 	logJson := string(logJsonInBytes)
-	clusterRequestMetadataAggregatorMap.LoadOrStore(logJson, clusterRequestMetadata)
+	shouldSendBatchToServer := clusterRequestMetadataAggregatorMap.LoadOrStore(logJson, clusterRequestMetadata)
 
-	if clusterRequestMetadataAggregatorMap.ShouldSendBatchToServer() {
-		vs.SendMetadataInBatch()
+	if shouldSendBatchToServer {
+		go vs.SendMetadataInBatch()
 	}
 }
 
+// SendMetadataInBatch sends the aggregated metadata to the result sinks and waits for the backend, it is also called on shutdown so nothing aggregated is lost
 func (vs *ValidationService) SendMetadataInBatch() {
-	clusterRequestMetadataArray := make([]*cliClient.ClusterRequestMetadata, 0, clusterRequestMetadataAggregatorMap.entriesCount)
-	clusterRequestMetadataAggregatorMap.clusterRequestMetadataAggregator.Range(func(key, value interface{}) bool {
-		clusterRequestMetadataArray = append(clusterRequestMetadataArray, value.(*cliClient.ClusterRequestMetadata))
-		return true
-	})
-
-	go vs.CliServiceClient.SendRequestMetadataBatch(cliClient.ClusterRequestMetadataBatchReqBody{MetadataLogs: clusterRequestMetadataArray})
-
-	clusterRequestMetadataAggregatorMap.Clear()
+	clusterRequestMetadataArray := clusterRequestMetadataAggregatorMap.Drain()
+	vs.ResultSinks.SendRequestMetadataBatch(clusterRequestMetadataArray)
 }
 
-func (vs *ValidationService) sendEvaluationResult(evaluationRequestData cliClient.WebhookEvaluationRequestData) (*baseCliClient.SendEvaluationResultsResponse, error) {
+// sendEvaluationResult fans the result out to the result sinks, the remote sinks send it in the background.
+// it returns the upload id of the result, which the report link is built with, empty when the result isn't sent to the backend
+func (vs *ValidationService) sendEvaluationResult(evaluationRequestData cliClient.WebhookEvaluationRequestData, resourceKind metav1.GroupVersionKind, resourceUid k8sTypes.UID, actionOnFailure enums.ActionOnFailure, passed bool, allowed bool, isAudit bool, dryRun bool, exceptions []RuleException) (evaluationUploadId string, err error) {
 	var OSInfoFn = utils.NewOSInfo
 	osInfo := OSInfoFn()
 
-	sendEvaluationResultsResponse, err := vs.CliServiceClient.SendWebhookEvaluationResult(&cliClient.EvaluationResultRequest{
+	evaluationResultRequest := &cliClient.EvaluationResultRequest{
 		K8sVersion: evaluationRequestData.EvaluationData.K8sVersion,
 		ClientId:   evaluationRequestData.EvaluationData.ClientId,
 		Token:      evaluationRequestData.EvaluationData.Token,
@@ -341,9 +507,43 @@ func (vs *ValidationService) sendEvaluationResult(evaluationRequestData cliClien
 		Namespace:          evaluationRequestData.Namespace,
 		Kind:               evaluationRequestData.Kind,
 		MetadataName:       evaluationRequestData.MetadataName,
+		EvaluationUploadId: uuid.NewString(),
+	}
+
+	err = vs.ResultSinks.SendEvaluationResult(&EvaluationResult{
+		EvaluationResultRequest: evaluationResultRequest,
+		ApiVersion:              schema.GroupVersion{Group: resourceKind.Group, Version: resourceKind.Version}.String(),
+		ResourceUid:             resourceUid,
+		Action:                  actionOnFailure,
+		Passed:                  passed,
+		Allowed:                 allowed,
+		IsAudit:                 isAudit,
+		DryRun:                  dryRun,
+		Exceptions:              exceptions,
+		EvaluatedAt:             time.Now(),
 	})
+	if !vs.ResultSinks.IsSendingToDatree() {
+		return "", err
+	}
+	return evaluationResultRequest.EvaluationUploadId, err
+}
+
+// getInvocationUrl the link to the full report of an evaluation, empty when the result isn't uploaded to the backend
+func getInvocationUrl(registrationUrl string, evaluationUploadId string) string {
+	if evaluationUploadId == "" {
+		return ""
+	}
+	baseUrl := strings.Split(registrationUrl, "datree.io")[0] + "datree.io"
+	return fmt.Sprintf("%s/cli/invocations/%s?webhook=true", baseUrl, evaluationUploadId)
+}
 
-	return sendEvaluationResultsResponse, err
+func isDryRun(request *admission.AdmissionRequest) bool {
+	return request.DryRun != nil && *request.DryRun
+}
+
+// GetFailClosedMessage the denial message of a resource whose policy check failed to run, in a namespace that fails closed
+func GetFailClosedMessage(reason string) string {
+	return fmt.Sprintf("Datree failed to run policy check and the failure policy of this namespace is closed, the resource is denied: %s", reason)
 }
 
 func ParseEvaluationResponseIntoAdmissionReview(requestUID k8sTypes.UID, allowed bool, msg string, warningMessages []string) *admission.AdmissionReview {
@@ -372,8 +572,9 @@ func ParseEvaluationResponseIntoAdmissionReview(requestUID k8sTypes.UID, allowed
 	}
 }
 
-func (vs *ValidationService) shouldPolicyRunForNamespace(policyName string, namespace string) bool {
-	namespaceRestrictions := vs.getNamespaceRestrictionsByPolicyName(policyName)
+// shouldPolicyRunForNamespace getNamespaceLabels is only called for the policies that are scoped by a namespace label selector
+func shouldPolicyRunForNamespace(multiplePolicies *servicestate.MultiplePolicies, policyName string, namespace string, getNamespaceLabels func() (map[string]string, bool)) bool {
+	namespaceRestrictions := getNamespaceRestrictionsByPolicyName(multiplePolicies, policyName)
 	if namespaceRestrictions == nil {
 		return true
 	}
@@ -387,25 +588,145 @@ func (vs *ValidationService) shouldPolicyRunForNamespace(policyName string, name
 			return true
 		}
 	}
+	if namespaceRestrictions.LabelSelector != nil {
+		return namespaceRestrictions.MatchesLabels(getNamespaceLabels())
+	}
 	return false
 }
-func (vs *ValidationService) getNamespaceRestrictionsByPolicyName(policyName string) *servicestate.Namespaces {
-	policies := vs.State.GetMultiplePolicies()
+
+// namespaceLabelsGetter returns a getter of the namespace labels that looks them up once, on its first call. the labels
+// of a cluster scoped resource, or of a namespace that can't be looked up, are unknown
+func namespaceLabelsGetter(ctx context.Context, namespaceLabelsCache *namespaceLabels.Cache, namespace string, requestLogger *logger.Logger) func() (map[string]string, bool) {
+	var namespaceLabelsOnce sync.Once
+	var result map[string]string
+	var areLabelsKnown bool
+	return func() (map[string]string, bool) {
+		namespaceLabelsOnce.Do(func() {
+			if namespace == "" {
+				return
+			}
+			var err error
+			if result, err = namespaceLabelsCache.GetLabels(ctx, namespace); err != nil {
+				requestLogger.LogWarn(fmt.Sprintf("failed to get the labels of namespace %s, the policies scoped by a namespace label selector don't run, err: %s", namespace, err))
+				return
+			}
+			areLabelsKnown = true
+		})
+		return result, areLabelsKnown
+	}
+}
+
+func getNamespaceRestrictionsByPolicyName(policies *servicestate.MultiplePolicies, policyName string) *servicestate.Namespaces {
+	policy := getPolicyWithNamespacesByName(policies, policyName)
+	if policy == nil {
+		return nil
+	}
+	return &policy.Namespaces
+}
+
+func getPolicyWithNamespacesByName(policies *servicestate.MultiplePolicies, policyName string) *servicestate.PolicyWithNamespaces {
 	if policies == nil {
 		return nil
 	}
 
 	for _, policy := range *policies {
 		if policy.Policy == policyName {
-			return &policy.Namespaces
+			return &policy
 		}
 	}
 	return nil
 }
 
-func (vs *ValidationService) shouldBypassByPermissions(userInfo authenticationv1.UserInfo, openShiftRequester string) bool {
-	bypassPermissions := vs.State.GetBypassPermissions()
+// policyScopeResource the admission request the kinds, the object selector and the operations of a policy are matched against
+type policyScopeResource struct {
+	Kind      string
+	Labels    map[string]string
+	Operation string
+}
+
+// shouldPolicyRunForResource returns false when the resource doesn't match the kinds, the object selector or the
+// operations of the policy. it is checked before the policy is created, so a policy that doesn't apply costs nothing
+func shouldPolicyRunForResource(multiplePolicies *servicestate.MultiplePolicies, policyName string, resource policyScopeResource) bool {
+	policy := getPolicyWithNamespacesByName(multiplePolicies, policyName)
+	if policy == nil {
+		return true
+	}
+	if policy.Kinds != nil {
+		if slices.Contains(policy.Kinds.Exclude, resource.Kind) {
+			return false
+		}
+		if len(policy.Kinds.Include) > 0 && !slices.Contains(policy.Kinds.Include, resource.Kind) {
+			return false
+		}
+	}
+	if len(policy.Operations) > 0 && !slices.Contains(policy.Operations, resource.Operation) {
+		return false
+	}
+	return policy.MatchesObjectLabels(resource.Labels)
+}
+
+var (
+	evaluator     *evaluation.Evaluator
+	evaluatorOnce sync.Once
+)
+
+// getEvaluator returns an evaluator shared by all the requests, evaluation.New replaces the logger backend of yq
+// which yq reads while evaluating, so it can't be called per request. Evaluate itself is stateless,
+// the CLI client is only used by Evaluator.SendEvaluationResult which is never called here
+func getEvaluator() *evaluation.Evaluator {
+	evaluatorOnce.Do(func() {
+		evaluator = evaluation.New(nil, ciContext.Extract())
+	})
+	return evaluator
+}
+
+// applyPrerunDataConfig returns the config the current request should use, a copy of its config snapshot whose helm
+// configuration is overridden with the dashboard configuration from the prerun data
+func applyPrerunDataConfig(state *servicestate.ServiceState, config *servicestate.Config, prerunData *cliClient.ClusterEvaluationPrerunDataResponse, requestLogger *logger.Logger) *servicestate.Config {
+	// in offline mode there is no dashboard configuration to override the helm configuration with
+	if state.GetConfigFromHelm() || state.GetIsOfflineMode() {
+		return config
+	}
+
+	skipList, errs := state.CompilePrerunSkipList(prerunData.IgnorePatterns)
+	for _, err := range errs {
+		requestLogger.LogWarn(fmt.Sprintf("the ignore pattern is skipped, err: %s", err))
+	}
+
+	// the prerun data of a request is never written to the shared state, concurrent requests may have other prerun data
+	requestConfig := *config
+	// copied, the prerun data may be shared with other requests
+	bypassPermissions := prerunData.BypassPermissions
+	requestConfig.IsEnforceMode = prerunData.ActionOnFailure == enums.EnforceActionOnFailure
+	requestConfig.SkipList = skipList
+	requestConfig.BypassPermissions = &bypassPermissions
+	return &requestConfig
+}
+
+// getPolicyActionOnFailure resolves the action of a single policy, in this order:
+// the policy entry in multiplePolicies, the policy action from the prerun data, and then the global enforce mode
+func (vs *ValidationService) getPolicyActionOnFailure(config *servicestate.Config, policyName string, prerunData *cliClient.ClusterEvaluationPrerunDataResponse) enums.ActionOnFailure {
+	if policies := config.MultiplePolicies; policies != nil {
+		for _, policy := range *policies {
+			if policy.Policy == policyName && policy.Action != "" {
+				return policy.Action
+			}
+		}
+	}
 
+	if !vs.State.GetConfigFromHelm() {
+		if actionOnFailure, ok := prerunData.ActivePoliciesActions[policyName]; ok && actionOnFailure != "" {
+			return actionOnFailure
+		}
+	}
+
+	if config.IsEnforceMode {
+		return enums.EnforceActionOnFailure
+	}
+	return enums.MonitorActionOnFailure
+}
+
+func (vs *ValidationService) shouldBypassByPermissions(requestLogger *logger.Logger, bypassPermissions *servicestate.BypassPermissions, userInfo authenticationv1.UserInfo, openShiftRequester string) bool {
 	if bypassPermissions == nil {
 		return false
 	}
@@ -416,7 +737,7 @@ func (vs *ValidationService) shouldBypassByPermissions(userInfo authenticationv1
 		// override groups
 		groupsFromOpenshiftService, err := vs.OpenshiftService.GetGroupsUserBelongsTo(openShiftRequester)
 		if err != nil {
-			vs.Logger.LogError(fmt.Sprintf("Failed to get groups for user %s from openshift service: %s", openShiftRequester, err.Error()))
+			requestLogger.LogError(fmt.Sprintf("Failed to get groups for user %s from openshift service: %s", openShiftRequester, err.Error()))
 		} else {
 			groups = groupsFromOpenshiftService
 		}
@@ -452,8 +773,24 @@ func (vs *ValidationService) shouldBypassByPermissions(userInfo authenticationv1
 	return false
 }
 
-func getFileConfiguration(admissionReviewReq *admission.AdmissionRequest) []*extractor.FileConfigurations {
-	yamlSchema, _ := yaml.JSONToYAML(admissionReviewReq.Object.Raw)
+func getDefaultRules(prerunData *cliClient.ClusterEvaluationPrerunDataResponse) *cliDefaultRules.DefaultRulesDefinitions {
+	// convert default rules string into DefaultRulesDefinitions structure
+	defaultRules, err := cliDefaultRules.YAMLToStruct(prerunData.DefaultRulesYaml)
+	// the default rules are empty in offline mode when no defaultRules.yaml is provided
+	if err != nil || len(defaultRules.Rules) == 0 {
+		// get default rules from cli binary on failure
+		defaultRules, err = cliDefaultRules.GetDefaultRules()
+		// panic if didn't manage to get default rules
+		if err != nil {
+			panic(err)
+		}
+	}
+	return defaultRules
+}
+
+// getFileConfiguration objectRaw is the object, or the old object, of the request
+func getFileConfiguration(admissionReviewReq *admission.AdmissionRequest, objectRaw []byte) []*extractor.FileConfigurations {
+	yamlSchema, _ := yaml.JSONToYAML(objectRaw)
 	configs, _ := extractor.ParseYaml(string(yamlSchema))
 
 	var filesConfigurations []*extractor.FileConfigurations
@@ -504,7 +841,7 @@ func getResourceMetadata(admissionReviewReq *admission.AdmissionReview, rootObje
 }
 
 func (vs *ValidationService) getEvaluationRequestData(policyName string,
-	startTime time.Time, policyCheckResults evaluation.PolicyCheckResultData, evaluationNamespace string, kind string, metadataName string) cliClient.WebhookEvaluationRequestData {
+	startTime time.Time, policyCheckResults evaluation.PolicyCheckResultData, evaluationNamespace string, kind string, metadataName string, isEnforceMode bool) cliClient.WebhookEvaluationRequestData {
 
 	evaluationDurationSeconds := time.Since(startTime).Seconds()
 	evaluationRequestData := cliClient.WebhookEvaluationRequestData{
@@ -520,7 +857,7 @@ func (vs *ValidationService) getEvaluationRequestData(policyName string,
 		},
 		WebhookVersion: vs.State.GetServiceVersion(),
 		ClusterUuid:    vs.State.GetClusterUuid(),
-		IsEnforceMode:  vs.State.GetIsEnforceMode(),
+		IsEnforceMode:  isEnforceMode,
 		Namespace:      evaluationNamespace,
 		Kind:           kind,
 		MetadataName:   metadataName,
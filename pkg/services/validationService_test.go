@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	"github.com/datreeio/admission-webhook-datree/pkg/namespaceLabels"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestShouldPolicyRunForNamespace(t *testing.T) {
+	multiplePolicies := &servicestate.MultiplePolicies{
+		{Policy: "by-pattern", Namespaces: servicestate.Namespaces{IncludePatterns: []string{"^payments-"}}},
+		{Policy: "by-labels", Namespaces: servicestate.Namespaces{
+			ExcludePatterns: []string{"^sandbox-"},
+			LabelSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+		}},
+		{Policy: "by-pattern-or-labels", Namespaces: servicestate.Namespaces{
+			IncludePatterns: []string{"^payments-"},
+			LabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "team", Operator: metav1.LabelSelectorOpIn, Values: []string{"platform", "security"}},
+			}},
+		}},
+		{Policy: "not-sandbox", Namespaces: servicestate.Namespaces{
+			LabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "sandbox", Operator: metav1.LabelSelectorOpDoesNotExist},
+			}},
+		}},
+	}
+	compileMultiplePolicies(t, multiplePolicies)
+	labelsGetter := func(namespaceLabels map[string]string) func() (map[string]string, bool) {
+		return func() (map[string]string, bool) { return namespaceLabels, true }
+	}
+	prodLabels := labelsGetter(map[string]string{"env": "prod", "team": "platform"})
+	devLabels := labelsGetter(map[string]string{"env": "dev"})
+	unknownLabels := func() (map[string]string, bool) { return nil, false }
+
+	t.Run("should run a policy without namespace restrictions everywhere", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForNamespace(multiplePolicies, "Default", "dev-1", devLabels))
+		assert.True(t, shouldPolicyRunForNamespace(nil, "by-pattern", "dev-1", devLabels))
+	})
+
+	t.Run("should only look the namespace labels up for a label selector", func(t *testing.T) {
+		panicGetter := func() (map[string]string, bool) { panic("the namespace labels shouldn't be looked up") }
+		assert.True(t, shouldPolicyRunForNamespace(multiplePolicies, "by-pattern", "payments-1", panicGetter))
+		assert.False(t, shouldPolicyRunForNamespace(multiplePolicies, "by-pattern", "dev-1", panicGetter))
+		assert.False(t, shouldPolicyRunForNamespace(multiplePolicies, "by-labels", "sandbox-1", panicGetter))
+	})
+
+	t.Run("should run a policy for the namespaces its label selector matches", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForNamespace(multiplePolicies, "by-labels", "checkout", prodLabels))
+		assert.False(t, shouldPolicyRunForNamespace(multiplePolicies, "by-labels", "checkout", devLabels))
+	})
+
+	t.Run("should not run a policy scoped by a label selector when the namespace labels are unknown", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForNamespace(multiplePolicies, "not-sandbox", "checkout", prodLabels))
+		assert.False(t, shouldPolicyRunForNamespace(multiplePolicies, "not-sandbox", "checkout", unknownLabels))
+		assert.False(t, shouldPolicyRunForNamespace(multiplePolicies, "by-labels", "checkout", unknownLabels))
+	})
+
+	t.Run("should run a policy for the namespaces an include pattern or its label selector matches", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForNamespace(multiplePolicies, "by-pattern-or-labels", "payments-1", devLabels))
+		assert.True(t, shouldPolicyRunForNamespace(multiplePolicies, "by-pattern-or-labels", "checkout", prodLabels))
+		assert.False(t, shouldPolicyRunForNamespace(multiplePolicies, "by-pattern-or-labels", "checkout", devLabels))
+	})
+}
+
+func TestNamespaceLabelsGetter(t *testing.T) {
+	mockLogger := logger.New(zapcore.InfoLevel, nil)
+	clientSet := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "checkout", Labels: map[string]string{"env": "prod"}}})
+	namespaceLabelsCache := namespaceLabels.New(clientSet, &mockLogger)
+
+	getNamespaceLabels := namespaceLabelsGetter(context.Background(), namespaceLabelsCache, "checkout", &mockLogger)
+	namespaceLabels, areLabelsKnown := getNamespaceLabels()
+	assert.Equal(t, map[string]string{"env": "prod"}, namespaceLabels)
+	assert.True(t, areLabelsKnown)
+	assert.Len(t, clientSet.Actions(), 1)
+	// the labels are only looked up once per request
+	namespaceLabels, _ = getNamespaceLabels()
+	assert.Equal(t, map[string]string{"env": "prod"}, namespaceLabels)
+	assert.Len(t, clientSet.Actions(), 1)
+
+	for _, getUnknownLabels := range []func() (map[string]string, bool){
+		namespaceLabelsGetter(context.Background(), namespaceLabelsCache, "missing", &mockLogger),
+		namespaceLabelsGetter(context.Background(), nil, "checkout", &mockLogger),
+		namespaceLabelsGetter(context.Background(), namespaceLabelsCache, "", &mockLogger),
+	} {
+		_, areLabelsKnown := getUnknownLabels()
+		assert.False(t, areLabelsKnown)
+	}
+}
+
+func compileMultiplePolicies(t *testing.T, multiplePolicies *servicestate.MultiplePolicies) {
+	for i := range *multiplePolicies {
+		assert.NoError(t, (*multiplePolicies)[i].Compile())
+	}
+}
+
+func TestShouldPolicyRunForResource(t *testing.T) {
+	multiplePolicies := &servicestate.MultiplePolicies{
+		{Policy: "ingress-hardening", Kinds: &servicestate.PolicyKinds{Include: []string{"Ingress", "Service"}}, Operations: []string{"CREATE"}},
+		{Policy: "no-jobs", Kinds: &servicestate.PolicyKinds{Exclude: []string{"Job", "CronJob"}}},
+		{Policy: "by-object-labels", ObjectSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "frontend"}}},
+	}
+	compileMultiplePolicies(t, multiplePolicies)
+	ingressCreate := policyScopeResource{Kind: "Ingress", Labels: map[string]string{"tier": "frontend"}, Operation: "CREATE"}
+
+	t.Run("should run a policy without resource restrictions for every resource", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForResource(multiplePolicies, "Default", policyScopeResource{Kind: "Job", Operation: "UPDATE"}))
+		assert.True(t, shouldPolicyRunForResource(nil, "ingress-hardening", policyScopeResource{Kind: "Job", Operation: "UPDATE"}))
+	})
+
+	t.Run("should only run a policy for the resources that match every set restriction", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForResource(multiplePolicies, "ingress-hardening", ingressCreate))
+		assert.True(t, shouldPolicyRunForResource(multiplePolicies, "ingress-hardening", policyScopeResource{Kind: "Service", Operation: "CREATE"}))
+		assert.False(t, shouldPolicyRunForResource(multiplePolicies, "ingress-hardening", policyScopeResource{Kind: "Deployment", Operation: "CREATE"}))
+		assert.False(t, shouldPolicyRunForResource(multiplePolicies, "ingress-hardening", policyScopeResource{Kind: "Ingress", Operation: "UPDATE"}))
+	})
+
+	t.Run("should not run a policy for the excluded kinds", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForResource(multiplePolicies, "no-jobs", ingressCreate))
+		assert.False(t, shouldPolicyRunForResource(multiplePolicies, "no-jobs", policyScopeResource{Kind: "CronJob", Operation: "CREATE"}))
+	})
+
+	t.Run("should run a policy for the resources its object selector matches", func(t *testing.T) {
+		assert.True(t, shouldPolicyRunForResource(multiplePolicies, "by-object-labels", ingressCreate))
+		assert.False(t, shouldPolicyRunForResource(multiplePolicies, "by-object-labels", policyScopeResource{Kind: "Ingress", Labels: map[string]string{"tier": "backend"}, Operation: "CREATE"}))
+		assert.False(t, shouldPolicyRunForResource(multiplePolicies, "by-object-labels", policyScopeResource{Kind: "Ingress", Operation: "CREATE"}))
+	})
+}
+
+func TestGetInvocationUrl(t *testing.T) {
+	assert.Equal(t, "https://app.staging.datree.io/cli/invocations/4c5f9b9e-7a4f-4e0a-9f4b-1f0c2d3e4a5b?webhook=true", getInvocationUrl("https://app.staging.datree.io/login?t=token", "4c5f9b9e-7a4f-4e0a-9f4b-1f0c2d3e4a5b"))
+	// the result isn't uploaded when no records are saved, or when the results aren't sent to the backend
+	assert.Empty(t, getInvocationUrl("https://app.staging.datree.io/login?t=token", ""))
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/datreeio/admission-webhook-datree/pkg/logger"
+	servicestate "github.com/datreeio/admission-webhook-datree/pkg/serviceState"
+)
+
+const webhookResultSinkTimeout = 10 * time.Second
+
+// webhookResultSink posts every record to an HTTP endpoint, e.g. the HTTP event collector of a SIEM.
+// the body is the record as json, or the payload template executed with the record
+type webhookResultSink struct {
+	url             string
+	headers         map[string]string
+	payloadTemplate *template.Template
+	httpClient      *http.Client
+}
+
+// webhookPayloadTemplateFuncs are available in the payload template, toJson is needed to embed values as json strings
+var webhookPayloadTemplateFuncs = template.FuncMap{
+	"toJson": func(value interface{}) (string, error) {
+		valueJson, err := json.Marshal(value)
+		return string(valueJson), err
+	},
+}
+
+func newWebhookResultSink(resultSinkConfig servicestate.ResultSinkConfig, logger *logger.Logger) (*backgroundResultSink, error) {
+	sink := &webhookResultSink{
+		url:        resultSinkConfig.URL,
+		headers:    resultSinkConfig.Headers,
+		httpClient: &http.Client{Timeout: webhookResultSinkTimeout},
+	}
+	if resultSinkConfig.PayloadTemplate != "" {
+		payloadTemplate, err := template.New("payloadTemplate").Funcs(webhookPayloadTemplateFuncs).Parse(resultSinkConfig.PayloadTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payloadTemplate: %s", err)
+		}
+		sink.payloadTemplate = payloadTemplate
+	}
+	return newBackgroundResultSink("webhook", sink.post, logger), nil
+}
+
+func (s *webhookResultSink) post(record SinkRecord) error {
+	payload, err := s.getPayload(record)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookResultSink) getPayload(record SinkRecord) ([]byte, error) {
+	if s.payloadTemplate == nil {
+		return json.Marshal(record)
+	}
+
+	var payload bytes.Buffer
+	if err := s.payloadTemplate.Execute(&payload, record); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}